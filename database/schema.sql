@@ -9,6 +9,8 @@ CREATE TABLE
         age INTEGER NOT NULL,
         gender TEXT NOT NULL CHECK (gender IN ('male', 'female')),
         profile_pic TEXT NOT NULL DEFAULT 'avatar.webp',
+        is_admin INTEGER NOT NULL DEFAULT 0,
+        is_bot INTEGER NOT NULL DEFAULT 0,
         created_at DATETIME DEFAULT CURRENT_TIMESTAMP
     );
 
@@ -21,6 +23,78 @@ CREATE TABLE
         FOREIGN KEY (user_id) REFERENCES users (id)
     );
 
+CREATE TABLE
+    IF NOT EXISTS api_tokens (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        prefix TEXT NOT NULL, -- Start of the token, to tell tokens apart
+        token_hash TEXT NOT NULL UNIQUE, -- SHA-256, the token is shown once
+        scopes TEXT NOT NULL, -- Comma separated
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        expires_at DATETIME NOT NULL,
+        last_used_at DATETIME,
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens (user_id);
+
+CREATE TABLE
+    IF NOT EXISTS webhooks (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        url TEXT NOT NULL,
+        secret TEXT NOT NULL, -- HMAC key of the signatures
+        events TEXT NOT NULL, -- Comma separated
+        active INTEGER NOT NULL DEFAULT 1,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE TABLE
+    IF NOT EXISTS webhook_deliveries (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        webhook_id INTEGER NOT NULL,
+        event_id TEXT NOT NULL, -- Shared by the deliveries of an event
+        event TEXT NOT NULL,
+        payload TEXT NOT NULL,
+        status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+        attempts INTEGER NOT NULL DEFAULT 0,
+        next_attempt_at DATETIME, -- NULL once done
+        response_status INTEGER,
+        last_error TEXT NOT NULL DEFAULT '',
+        replay_of INTEGER,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        delivered_at DATETIME,
+        FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, created_at);
+
+-- Background jobs, see jobs.go. Periodic jobs queue their next run when done.
+CREATE TABLE
+    IF NOT EXISTS jobs (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        kind TEXT NOT NULL,
+        payload TEXT NOT NULL DEFAULT '{}',
+        status TEXT NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'succeeded', 'failed')),
+        unique_key TEXT, -- At most one queued or running job per key
+        attempts INTEGER NOT NULL DEFAULT 0,
+        max_attempts INTEGER NOT NULL,
+        run_at DATETIME NOT NULL,
+        last_error TEXT NOT NULL DEFAULT '',
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        started_at DATETIME,
+        finished_at DATETIME
+    );
+
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs (status, run_at);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_unique ON jobs (unique_key)
+WHERE
+    unique_key IS NOT NULL
+    AND status IN ('queued', 'running');
+
 CREATE TABLE
     IF NOT EXISTS posts (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -46,6 +120,17 @@ CREATE TABLE
         name TEXT NOT NULL
     );
 
+-- Post galleries, posts.image keeps the first image for older clients.
+CREATE TABLE
+    IF NOT EXISTS post_media (
+        post_id INTEGER NOT NULL,
+        position INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        alt TEXT NOT NULL,
+        PRIMARY KEY (post_id, position),
+        FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+    );
+
 CREATE TABLE
     IF NOT EXISTS post_reactions (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -85,7 +170,7 @@ CREATE TABLE
         user_id INTEGER NOT NULL,
         actor_id INTEGER NOT NULL,
         post_id INTEGER DEFAULT NULL,
-        type TEXT NOT NULL CHECK (type IN ('like', 'dislike', 'comment')),
+        type TEXT NOT NULL CHECK (type IN ('like', 'dislike', 'comment', 'post')),
         read_status INTEGER DEFAULT 0,
         created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
         FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
@@ -104,6 +189,242 @@ CREATE TABLE
         FOREIGN KEY (receiver_id) REFERENCES users (id) ON DELETE CASCADE
     );
 
+CREATE TABLE
+    IF NOT EXISTS post_scores (
+        post_id INTEGER PRIMARY KEY,
+        score INTEGER NOT NULL DEFAULT 0,
+        hot REAL NOT NULL DEFAULT 0,
+        controversial REAL NOT NULL DEFAULT 0,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX IF NOT EXISTS idx_post_scores_hot ON post_scores (hot);
+
+CREATE TABLE
+    IF NOT EXISTS post_stats (
+        post_id INTEGER PRIMARY KEY,
+        likes INTEGER NOT NULL DEFAULT 0,
+        dislikes INTEGER NOT NULL DEFAULT 0,
+        comments INTEGER NOT NULL DEFAULT 0,
+        FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+    );
+
+-- Backfill counters of posts created before post_stats existed.
+INSERT OR IGNORE INTO post_stats (post_id, likes, dislikes, comments)
+SELECT
+    p.id,
+    (SELECT COUNT(*) FROM post_reactions r WHERE r.post_id = p.id AND r.reaction_type = 'like'),
+    (SELECT COUNT(*) FROM post_reactions r WHERE r.post_id = p.id AND r.reaction_type = 'dislike'),
+    (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id)
+FROM posts p;
+
+CREATE TABLE
+    IF NOT EXISTS polls (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        post_id INTEGER NOT NULL UNIQUE,
+        question TEXT NOT NULL,
+        multiple BOOLEAN NOT NULL DEFAULT 0,
+        anonymous BOOLEAN NOT NULL DEFAULT 1,
+        closes_at DATETIME,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+    );
+
+CREATE TABLE
+    IF NOT EXISTS poll_options (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        poll_id INTEGER NOT NULL,
+        label TEXT NOT NULL,
+        position INTEGER NOT NULL,
+        FOREIGN KEY (poll_id) REFERENCES polls (id) ON DELETE CASCADE
+    );
+
+CREATE TABLE
+    IF NOT EXISTS poll_votes (
+        poll_id INTEGER NOT NULL,
+        option_id INTEGER NOT NULL,
+        user_id INTEGER NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (poll_id, user_id, option_id),
+        FOREIGN KEY (poll_id) REFERENCES polls (id) ON DELETE CASCADE,
+        FOREIGN KEY (option_id) REFERENCES poll_options (id) ON DELETE CASCADE,
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX IF NOT EXISTS idx_poll_options_poll ON poll_options (poll_id);
+
+CREATE INDEX IF NOT EXISTS idx_poll_votes_option ON poll_votes (option_id);
+
+CREATE TABLE
+    IF NOT EXISTS post_drafts (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        title TEXT NOT NULL DEFAULT '',
+        content TEXT NOT NULL DEFAULT '',
+        categories TEXT NOT NULL DEFAULT '[]',
+        image TEXT NOT NULL DEFAULT '', -- Replaced by media
+        media TEXT NOT NULL DEFAULT '[]', -- [{"name", "alt"}] in gallery order
+        poll TEXT,
+        publish_at DATETIME,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX IF NOT EXISTS idx_post_drafts_user ON post_drafts (user_id, updated_at);
+
+CREATE INDEX IF NOT EXISTS idx_post_drafts_publish ON post_drafts (publish_at);
+
+CREATE TABLE
+    IF NOT EXISTS bookmark_collections (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE (user_id, name),
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+CREATE TABLE
+    IF NOT EXISTS bookmarks (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        post_id INTEGER NOT NULL,
+        collection_id INTEGER,
+        note TEXT NOT NULL DEFAULT '',
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE (user_id, post_id),
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+        FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+        FOREIGN KEY (collection_id) REFERENCES bookmark_collections (id) ON DELETE SET NULL
+    );
+
+CREATE INDEX IF NOT EXISTS idx_bookmarks_collection ON bookmarks (collection_id);
+
+CREATE TABLE
+    IF NOT EXISTS follows (
+        follower_id INTEGER NOT NULL,
+        followee_id INTEGER NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (follower_id, followee_id),
+        CHECK (follower_id != followee_id),
+        FOREIGN KEY (follower_id) REFERENCES users (id) ON DELETE CASCADE,
+        FOREIGN KEY (followee_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX IF NOT EXISTS idx_follows_followee ON follows (followee_id);
+
+CREATE TABLE
+    IF NOT EXISTS category_follows (
+        user_id INTEGER NOT NULL,
+        category_id INTEGER NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (user_id, category_id),
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+        FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE CASCADE
+    );
+
+CREATE TABLE
+    IF NOT EXISTS blocks (
+        user_id INTEGER NOT NULL,
+        target_id INTEGER NOT NULL,
+        kind TEXT NOT NULL CHECK (kind IN ('block', 'mute')),
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (user_id, target_id),
+        CHECK (user_id != target_id),
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+        FOREIGN KEY (target_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX IF NOT EXISTS idx_blocks_target ON blocks (target_id, kind);
+
+-- Uploads by content hash, refs counts the posts, galleries, drafts and users
+-- (profile pic) using them and is kept by the media_* triggers.
+-- Messages have no attachments.
+CREATE TABLE
+    IF NOT EXISTS media (
+        hash TEXT PRIMARY KEY,
+        name TEXT NOT NULL UNIQUE,
+        size INTEGER NOT NULL,
+        refs INTEGER NOT NULL DEFAULT 0,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        touched_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_media_unreferenced ON media (touched_at) WHERE refs <= 0;
+
+-- Unfurled links of posts and messages, by URL as written.
+-- Failed fetches are cached too, with an empty title.
+CREATE TABLE
+    IF NOT EXISTS link_previews (
+        url TEXT PRIMARY KEY,
+        title TEXT NOT NULL DEFAULT '',
+        description TEXT NOT NULL DEFAULT '',
+        image TEXT NOT NULL DEFAULT '',
+        site_name TEXT NOT NULL DEFAULT '',
+        fetched_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE TABLE
+    IF NOT EXISTS schema_migrations (
+        name TEXT PRIMARY KEY,
+        applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE TRIGGER IF NOT EXISTS post_stats_post_insert AFTER INSERT ON posts BEGIN
+INSERT OR IGNORE INTO post_stats (post_id) VALUES (NEW.id);
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS post_stats_reaction_insert AFTER INSERT ON post_reactions BEGIN
+UPDATE post_stats
+SET
+    likes = likes + (NEW.reaction_type = 'like'),
+    dislikes = dislikes + (NEW.reaction_type = 'dislike')
+WHERE
+    post_id = NEW.post_id;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS post_stats_reaction_update AFTER UPDATE OF reaction_type ON post_reactions BEGIN
+UPDATE post_stats
+SET
+    likes = likes - (OLD.reaction_type = 'like') + (NEW.reaction_type = 'like'),
+    dislikes = dislikes - (OLD.reaction_type = 'dislike') + (NEW.reaction_type = 'dislike')
+WHERE
+    post_id = NEW.post_id;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS post_stats_reaction_delete AFTER DELETE ON post_reactions BEGIN
+UPDATE post_stats
+SET
+    likes = likes - (OLD.reaction_type = 'like'),
+    dislikes = dislikes - (OLD.reaction_type = 'dislike')
+WHERE
+    post_id = OLD.post_id;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS post_stats_comment_insert AFTER INSERT ON comments BEGIN
+UPDATE post_stats
+SET
+    comments = comments + 1
+WHERE
+    post_id = NEW.post_id;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS post_stats_comment_delete AFTER DELETE ON comments BEGIN
+UPDATE post_stats
+SET
+    comments = comments - 1
+WHERE
+    post_id = OLD.post_id;
+
+END;
+
 CREATE TRIGGER IF NOT EXISTS delete_expired_insert BEFORE INSERT ON sessions BEGIN
 DELETE FROM sessions
 WHERE
@@ -116,4 +437,86 @@ DELETE FROM sessions
 WHERE
     expires_at < DATETIME ('now');
 
-END;
\ No newline at end of file
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_post_insert AFTER INSERT ON posts BEGIN
+UPDATE media SET refs = refs + 1 WHERE name = NEW.image;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_post_update AFTER UPDATE OF image ON posts
+WHEN OLD.image IS NOT NEW.image BEGIN
+UPDATE media SET refs = refs - 1 WHERE name = OLD.image;
+UPDATE media SET refs = refs + 1 WHERE name = NEW.image;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_post_delete AFTER DELETE ON posts BEGIN
+UPDATE media SET refs = refs - 1 WHERE name = OLD.image;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_draft_insert AFTER INSERT ON post_drafts BEGIN
+UPDATE media SET refs = refs + 1 WHERE name = NEW.image;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_draft_update AFTER UPDATE OF image ON post_drafts
+WHEN OLD.image IS NOT NEW.image BEGIN
+UPDATE media SET refs = refs - 1 WHERE name = OLD.image;
+UPDATE media SET refs = refs + 1 WHERE name = NEW.image;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_draft_delete AFTER DELETE ON post_drafts BEGIN
+UPDATE media SET refs = refs - 1 WHERE name = OLD.image;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_user_insert AFTER INSERT ON users BEGIN
+UPDATE media SET refs = refs + 1 WHERE name = NEW.profile_pic;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_user_update AFTER UPDATE OF profile_pic ON users
+WHEN OLD.profile_pic IS NOT NEW.profile_pic BEGIN
+UPDATE media SET refs = refs - 1 WHERE name = OLD.profile_pic;
+UPDATE media SET refs = refs + 1 WHERE name = NEW.profile_pic;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_user_delete AFTER DELETE ON users BEGIN
+UPDATE media SET refs = refs - 1 WHERE name = OLD.profile_pic;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_post_media_insert AFTER INSERT ON post_media BEGIN
+UPDATE media SET refs = refs + 1 WHERE name = NEW.name;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_post_media_delete AFTER DELETE ON post_media BEGIN
+UPDATE media SET refs = refs - 1 WHERE name = OLD.name;
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_draft_media_insert AFTER INSERT ON post_drafts BEGIN
+UPDATE media SET refs = refs + 1
+WHERE name IN (SELECT json_extract(value, '$.name') FROM json_each(NEW.media));
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_draft_media_update AFTER UPDATE OF media ON post_drafts
+WHEN OLD.media IS NOT NEW.media BEGIN
+UPDATE media SET refs = refs - 1
+WHERE name IN (SELECT json_extract(value, '$.name') FROM json_each(OLD.media));
+UPDATE media SET refs = refs + 1
+WHERE name IN (SELECT json_extract(value, '$.name') FROM json_each(NEW.media));
+
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_draft_media_delete AFTER DELETE ON post_drafts BEGIN
+UPDATE media SET refs = refs - 1
+WHERE name IN (SELECT json_extract(value, '$.name') FROM json_each(OLD.media));
+
+END;
@@ -3,16 +3,13 @@ package server
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
 )
 
-// Num of posts on each scroll load in profile.
-var ProfileLimit = 6
-
 // Handler to Get the User's *Liked/disliked* Posts
 func LikedPosts(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -39,16 +36,20 @@ func LikedPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	visible, args := VisibleTo(user.ID, "p.user_id")
+	args = append([]any{user.ID, reaction}, args...)
+	args = append(args, Conf.Limits.ProfileItems, offset)
+
 	rows, err := DB.Query(`
-      	SELECT p.id, p.user_id, p.title, p.content, p.image, p.created_at, u.username, u.profile_pic
+      	SELECT p.id, p.user_id, p.title, p.content, p.image, p.created_at, u.username, u.profile_pic, u.is_bot
       	FROM post_reactions pr
       	JOIN posts p ON pr.post_id = p.id
       	JOIN users u ON p.user_id = u.id
-      	WHERE pr.user_id = ? AND pr.reaction_type = ?
+      	WHERE pr.user_id = ? AND pr.reaction_type = ? AND `+visible+`
       	ORDER BY p.id DESC
       	LIMIT ?
       	OFFSET ?
-    `, user.ID, reaction, ProfileLimit, offset)
+    `, args...)
 	if err != nil {
 		JsonError(w, "Failed to get posts", http.StatusInternalServerError, err)
 		return
@@ -56,16 +57,14 @@ func LikedPosts(w http.ResponseWriter, r *http.Request) {
 
 	defer rows.Close()
 
-	var posts []Post
-	for rows.Next() {
-		var p Post
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.Image, &p.CreatedAt, &p.Username, &p.ProfilePic); err != nil {
-			JsonError(w, "Failed to scan liked posts", http.StatusInternalServerError, err)
-			return
-		}
-		cats, _ := FetchPostCategories(p.ID)
-		p.Categories = cats
-		posts = append(posts, p)
+	posts, err := ScanRows(rows)
+	if err != nil {
+		JsonError(w, "Failed to scan liked posts", http.StatusInternalServerError, err)
+		return
+	}
+	if err := LoadPostDetails(posts, user.ID); err != nil {
+		JsonError(w, "Failed to load posts details", http.StatusInternalServerError, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -96,17 +95,21 @@ func UserCommentedPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	visible, args := VisibleTo(user.ID, "p.user_id")
+	args = append([]any{user.ID}, args...)
+	args = append(args, Conf.Limits.ProfileItems, offset)
+
 	// Query DISTINCT posts that this user has commented on
 	rows, err := DB.Query(`
-        SELECT DISTINCT p.id, p.user_id, p.title, p.content, p.image, p.created_at, u.username, u.profile_pic
+        SELECT DISTINCT p.id, p.user_id, p.title, p.content, p.image, p.created_at, u.username, u.profile_pic, u.is_bot
         FROM comments c
         JOIN posts p ON c.post_id = p.id
         JOIN users u ON p.user_id = u.id
-        WHERE c.user_id = ?
+        WHERE c.user_id = ? AND `+visible+`
         ORDER BY p.created_at DESC
         LIMIT ?
         OFFSET ?
-    `, user.ID, ProfileLimit, offset)
+    `, args...)
 	if err != nil {
 		JsonError(w, "Failed to fetch commented posts", http.StatusInternalServerError, err)
 		return
@@ -114,20 +117,14 @@ func UserCommentedPosts(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	// Collect each Post
-	var commentedPosts []Post
-	for rows.Next() {
-		var p Post
-		// Make sure your columns match your Scan order
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.Image, &p.CreatedAt, &p.Username, &p.ProfilePic); err != nil {
-			JsonError(w, "Failed scanning post data", http.StatusInternalServerError, err)
-			return
-		}
-
-		// Optionally fetch categories if you need them
-		cats, _ := FetchPostCategories(p.ID)
-		p.Categories = cats
-
-		commentedPosts = append(commentedPosts, p)
+	commentedPosts, err := ScanRows(rows)
+	if err != nil {
+		JsonError(w, "Failed scanning post data", http.StatusInternalServerError, err)
+		return
+	}
+	if err := LoadPostDetails(commentedPosts, user.ID); err != nil {
+		JsonError(w, "Failed to load posts details", http.StatusInternalServerError, err)
+		return
 	}
 
 	// Return only the posts
@@ -180,6 +177,8 @@ func GetUserPostComments(w http.ResponseWriter, r *http.Request) {
 			JsonError(w, "Failed reading comments", http.StatusInternalServerError, err)
 			return
 		}
+		c.ContentHTML = RenderMarkdown(c.Content)
+		c.ProfilePicThumb = ImageVariant(c.ProfilePic, "thumb")
 		comments = append(comments, c)
 	}
 
@@ -235,11 +234,6 @@ func UpdateProfilePic(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if isSVG(profilePic) {
-		JsonError(w, "SVG images aren't supported", http.StatusBadRequest, nil)
-		return
-	}
-
 	// Retrieve old pic from DB for removal later
 	var oldPic string
 	err = DB.QueryRow(`SELECT profile_pic FROM users WHERE id = ?`, user.ID).Scan(&oldPic)
@@ -250,6 +244,10 @@ func UpdateProfilePic(w http.ResponseWriter, r *http.Request) {
 
 	// Save the new pic using your SaveImg() helper
 	newPicFilename, err := SaveImg(profilePic)
+	if errors.Is(err, ErrImage) {
+		JsonError(w, err.Error(), http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
 		JsonError(w, "Failed to save new profile image", http.StatusInternalServerError, err)
 		return
@@ -258,15 +256,13 @@ func UpdateProfilePic(w http.ResponseWriter, r *http.Request) {
 	// Update user's profile_pic path in DB
 	_, err = DB.Exec(`UPDATE users SET profile_pic = ? WHERE id = ?`, newPicFilename, user.ID)
 	if err != nil {
-		// If the DB update fails, remove newly saved file to avoid orphan images
-		_ = os.Remove("./static/uploads/" + newPicFilename)
+		// The unreferenced new pic is left to the sweeper
 		JsonError(w, "Database update failed", http.StatusInternalServerError, err)
 		return
 	}
 
-	// If there was an old pic remove it
-	if oldPic != "avatar.webp" {
-		_ = os.Remove("./static/uploads/" + oldPic)
+	if oldPic != newPicFilename {
+		releaseUpload(oldPic)
 	}
 
 	response := map[string]string{"profile_pic": newPicFilename}
@@ -0,0 +1,578 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Base path of the versioned API.
+const apiPrefix = "/api/v1"
+
+// Route of the v1 API. The table below is the source of the OpenAPI
+// document, of request validation and of the deprecated /api aliases.
+type APIRoute struct {
+	Method  string
+	Path    string // Relative to apiPrefix, {name} segments are path params
+	Name    string // operationId
+	Summary string
+	Tag     string
+	Auth    bool   // Needs a session or a token
+	Scope   string // Token scope of the route, "" for sessions only
+	Params  []Parameter
+	Body    *Schema // JSON body
+	Form    *Schema // Multipart body
+	// Type of the data in the envelope, nil for a message
+	Response any
+	Paged    bool // Response is a page of Response items
+	Status   int  // Success status, 200 when 0
+	Limited  bool // Behind the rate limiter
+	Handler  http.HandlerFunc
+
+	// Old /api path, served as before with deprecation headers.
+	// Aliases with no v1 equivalent have no Path and name their Successor.
+	Legacy    string
+	Successor string
+}
+
+// Data of plain text replies in the envelope.
+type APIMessage struct {
+	Message string `json:"message"`
+}
+
+type apiError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []APIProblem `json:"details,omitempty"`
+}
+
+// Error codes of the envelope by status.
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusServiceUnavailable:    "service_unavailable",
+}
+
+// Parameter helpers for the routes table.
+func queryParam(name string, s *Schema) Parameter {
+	return Parameter{Name: name, In: "query", Schema: s}
+}
+
+func requiredQuery(name string, s *Schema) Parameter {
+	return Parameter{Name: name, In: "query", Required: true, Schema: s}
+}
+
+func pathParam(name string, s *Schema) Parameter {
+	return Parameter{Name: name, In: "path", Required: true, Schema: s}
+}
+
+var (
+	cursorParam = queryParam("cursor", strSchema())
+	offsetParam = queryParam("offset", &Schema{Type: "integer", Minimum: intPtr(0)})
+	postForm    = objSchema(
+		"title", strSchema(),
+		"content", strSchema(),
+		"categories", &Schema{Type: "string", Description: "JSON array of category names"},
+		"image", arraySchema(&Schema{Type: "string", Format: "binary"}, maxPostImages),
+		"alts", &Schema{Type: "string", Description: "JSON array, one alt text per image"},
+		"poll", &Schema{Type: "string", Description: "JSON poll, {question, options, multiple, closes_at}"},
+		"publish_at", &Schema{Type: "string", Format: "date-time", Description: "Drafts only"},
+		"remove_image", &Schema{Type: "string", Enum: []string{"true", "false"}, Description: "Drafts only"},
+		"remove_poll", &Schema{Type: "string", Enum: []string{"true", "false"}, Description: "Drafts only"},
+		"unschedule", &Schema{Type: "string", Enum: []string{"true", "false"}, Description: "Drafts only, clears publish_at"},
+	)
+	notifBulkBody = objSchema(
+		"ids", arraySchema(idSchema(), 500),
+		"type", enumSchema("like", "dislike", "comment", "post"),
+		"before", &Schema{Type: "string", Format: "date-time"},
+		"all", boolSchema(),
+	)
+)
+
+var apiRoutes = []APIRoute{
+	// Posts
+	{Method: "GET", Path: "/posts", Name: "listPosts", Summary: "List posts", Tag: "posts", Scope: ScopeRead,
+		Params: []Parameter{cursorParam, offsetParam,
+			queryParam("tags", &Schema{Type: "string", Description: "Comma separated categories"}),
+			queryParam("sort", enumSchema("new", "hot", "top", "controversial")),
+			queryParam("t", enumSchema("day", "week", "month", "year", "all")),
+			queryParam("feed", enumSchema("all", "following")),
+		},
+		Response: Post{}, Paged: true, Handler: GetPostsHandler, Legacy: "/api/get-posts"},
+	{Method: "POST", Path: "/posts", Name: "createPost", Summary: "Create a post", Tag: "posts", Auth: true, Scope: ScopePost,
+		Form: postForm, Response: map[string]int64{}, Limited: true, Status: http.StatusCreated, Handler: CreatePostHandler, Legacy: "/api/create-post"},
+	{Method: "GET", Path: "/posts/{post_id}", Name: "getPost", Summary: "Get a post", Tag: "posts", Scope: ScopeRead,
+		Params:   []Parameter{pathParam("post_id", idSchema())},
+		Response: Post{}, Handler: SinglePostHandler, Legacy: "/api/get-singlePost"},
+	{Method: "GET", Path: "/posts/{post_id}/comments", Name: "listComments", Summary: "List the comments of a post", Tag: "posts", Scope: ScopeRead,
+		Params:   []Parameter{pathParam("post_id", idSchema()), cursorParam, offsetParam},
+		Response: Comment{}, Paged: true, Handler: GetComments, Legacy: "/api/get-comments"},
+	{Method: "GET", Path: "/posts/{post_id}/comments/count", Name: "countComments", Summary: "Count the comments of a post", Tag: "posts", Scope: ScopeRead,
+		Params:   []Parameter{pathParam("post_id", idSchema())},
+		Response: map[string]int{}, Handler: GetCommentsCount, Legacy: "/api/comments-count"},
+	{Method: "GET", Path: "/posts/{post_id}/poll", Name: "getPoll", Summary: "Get the poll of a post", Tag: "posts", Scope: ScopeRead,
+		Params:   []Parameter{pathParam("post_id", idSchema())},
+		Response: Poll{}, Handler: GetPoll, Legacy: "/api/get-poll"},
+	{Method: "POST", Path: "/polls/votes", Name: "votePoll", Summary: "Vote in a poll", Tag: "posts", Auth: true, Scope: ScopeComment,
+		Body:     objSchema("poll_id*", idSchema(), "option_ids*", arraySchema(idSchema(), maxPollOptions)),
+		Response: Poll{}, Limited: true, Handler: VotePoll, Legacy: "/api/vote-poll"},
+	{Method: "POST", Path: "/comments", Name: "createComment", Summary: "Comment on a post", Tag: "posts", Auth: true, Scope: ScopeComment,
+		Body:     objSchema("id*", idSchema(), "content*", strSchema()),
+		Response: Comment{}, Limited: true, Status: http.StatusCreated, Handler: AddComment, Legacy: "/api/add-comment"},
+	{Method: "GET", Path: "/reactions", Name: "getReactions", Summary: "Count the reactions of a post or comment", Tag: "posts", Scope: ScopeRead,
+		Params:   []Parameter{queryParam("post_id", idSchema()), queryParam("comment_id", idSchema())},
+		Response: map[string]any{}, Handler: GetReactions, Legacy: "/api/get-reactions"},
+	{Method: "POST", Path: "/reactions", Name: "react", Summary: "Like or dislike a post or comment", Tag: "posts", Auth: true, Scope: ScopeComment,
+		Params:  []Parameter{requiredQuery("type", enumSchema("post", "comment"))},
+		Body:    objSchema("id*", idSchema(), "reaction_type*", enumSchema("like", "dislike")),
+		Limited: true, Handler: AddReaction, Legacy: "/api/add-reaction"},
+	{Method: "GET", Path: "/categories", Name: "listCategories", Summary: "List categories", Tag: "posts", Scope: ScopeRead,
+		Response: []string{}, Handler: GetCategoriesHandler, Legacy: "/api/get-categories"},
+	{Method: "POST", Path: "/categories", Name: "createCategories", Summary: "Add categories", Tag: "posts", Auth: true,
+		Body:    objSchema("categories*", arraySchema(strSchema(), 0)),
+		Limited: true, Status: http.StatusCreated, Handler: AddCategoriesHandler, Legacy: "/api/add-categories"},
+
+	// Users
+	{Method: "GET", Path: "/session", Name: "getSession", Summary: "Get the logged in user", Tag: "users", Scope: ScopeRead,
+		Response: map[string]any{}, Handler: CheckSession, Legacy: "/api/check-session"},
+	{Method: "GET", Path: "/users/{username}", Name: "getProfile", Summary: "Get a profile", Tag: "users", Scope: ScopeRead,
+		Params:   []Parameter{pathParam("username", strSchema())},
+		Response: UserProfile{}, Handler: GetProfileInfo, Legacy: "/api/get-profile-info"},
+	{Method: "GET", Path: "/users/{username}/exists", Name: "checkUser", Summary: "Check if a username is taken", Tag: "users", Scope: ScopeRead,
+		Params:   []Parameter{pathParam("username", strSchema())},
+		Response: CheckUser{}, Handler: CheckUserHandler, Legacy: "/api/check-user"},
+	{Method: "GET", Path: "/users/{username}/followers", Name: "listFollowers", Summary: "List the followers of a user", Tag: "users", Auth: true, Scope: ScopeRead,
+		Params:   []Parameter{pathParam("username", strSchema()), offsetParam},
+		Response: []FollowEntry{}, Handler: GetFollowers, Legacy: "/api/get-followers"},
+	{Method: "GET", Path: "/users/{username}/following", Name: "listFollowing", Summary: "List the users a user follows", Tag: "users", Auth: true, Scope: ScopeRead,
+		Params:   []Parameter{pathParam("username", strSchema()), offsetParam},
+		Response: []FollowEntry{}, Handler: GetFollowing, Legacy: "/api/get-following"},
+	{Method: "GET", Path: "/me/posts", Name: "listMyPosts", Summary: "List the posts of the logged in user", Tag: "users", Auth: true, Scope: ScopeRead,
+		Params:   []Parameter{cursorParam, offsetParam},
+		Response: Post{}, Paged: true, Handler: GetUserPosts, Legacy: "/api/get-user-posts"},
+	{Method: "GET", Path: "/me/liked-posts", Name: "listLikedPosts", Summary: "List the posts the logged in user reacted to", Tag: "users", Auth: true, Scope: ScopeRead,
+		Params:   []Parameter{offsetParam, requiredQuery("reaction", enumSchema("like", "dislike"))},
+		Response: []Post{}, Handler: LikedPosts, Legacy: "/api/user-liked-posts"},
+	{Method: "GET", Path: "/me/commented-posts", Name: "listCommentedPosts", Summary: "List the posts the logged in user commented on", Tag: "users", Auth: true, Scope: ScopeRead,
+		Params:   []Parameter{offsetParam},
+		Response: []Post{}, Handler: UserCommentedPosts, Legacy: "/api/user-commented-posts"},
+	{Method: "GET", Path: "/me/comments", Name: "listMyComments", Summary: "List the comments of the logged in user on a post", Tag: "users", Auth: true, Scope: ScopeRead,
+		Params:   []Parameter{requiredQuery("post_id", idSchema())},
+		Response: []Comment{}, Handler: GetUserPostComments, Legacy: "/api/user-post-comments"},
+	{Method: "POST", Path: "/me/profile-pic", Name: "updateProfilePic", Summary: "Change the profile picture", Tag: "users", Auth: true,
+		Form:     objSchema("profile_pic*", &Schema{Type: "string", Format: "binary"}),
+		Response: map[string]string{}, Limited: true, Handler: UpdateProfilePic, Legacy: "/api/update-profile-pic"},
+
+	// Blocks and follows
+	{Method: "GET", Path: "/blocks", Name: "listBlocks", Summary: "List blocked and muted users", Tag: "relations", Auth: true, Scope: ScopeRead,
+		Response: []BlockEntry{}, Handler: GetBlocks, Legacy: "/api/get-blocks"},
+	{Method: "POST", Path: "/blocks", Name: "block", Summary: "Block or mute a user", Tag: "relations", Auth: true,
+		Body:    objSchema("username*", strSchema(), "kind*", enumSchema("block", "mute")),
+		Limited: true, Handler: AddBlock, Legacy: "/api/block"},
+	{Method: "DELETE", Path: "/blocks/{username}", Name: "unblock", Summary: "Unblock or unmute a user", Tag: "relations", Auth: true,
+		Params:  []Parameter{pathParam("username", strSchema())},
+		Limited: true, Handler: RemoveBlock, Legacy: "/api/unblock"},
+	{Method: "POST", Path: "/follows", Name: "follow", Summary: "Follow a user", Tag: "relations", Auth: true,
+		Body:    objSchema("username*", strSchema()),
+		Limited: true, Handler: FollowUser, Legacy: "/api/follow"},
+	{Method: "DELETE", Path: "/follows/{username}", Name: "unfollow", Summary: "Unfollow a user", Tag: "relations", Auth: true,
+		Params:  []Parameter{pathParam("username", strSchema())},
+		Limited: true, Handler: UnfollowUser, Legacy: "/api/unfollow"},
+	{Method: "GET", Path: "/category-follows", Name: "listFollowedCategories", Summary: "List followed categories", Tag: "relations", Auth: true, Scope: ScopeRead,
+		Response: []Category{}, Handler: GetFollowedCategories, Legacy: "/api/get-followed-categories"},
+	{Method: "POST", Path: "/category-follows", Name: "followCategory", Summary: "Follow a category", Tag: "relations", Auth: true,
+		Body:    objSchema("category*", strSchema()),
+		Limited: true, Handler: FollowCategory, Legacy: "/api/follow-category"},
+	{Method: "DELETE", Path: "/category-follows/{category}", Name: "unfollowCategory", Summary: "Unfollow a category", Tag: "relations", Auth: true,
+		Params:  []Parameter{pathParam("category", strSchema())},
+		Limited: true, Handler: UnfollowCategory, Legacy: "/api/unfollow-category"},
+
+	// Bookmarks
+	{Method: "GET", Path: "/bookmarks", Name: "listBookmarks", Summary: "List bookmarked posts", Tag: "bookmarks", Auth: true, Scope: ScopeRead,
+		Params:   []Parameter{offsetParam, queryParam("collection_id", idSchema())},
+		Response: []Post{}, Handler: GetBookmarks, Legacy: "/api/get-bookmarks"},
+	{Method: "POST", Path: "/bookmarks", Name: "bookmark", Summary: "Bookmark a post or update its bookmark", Tag: "bookmarks", Auth: true,
+		Body:    objSchema("post_id*", idSchema(), "collection_id", &Schema{Type: "integer", Minimum: intPtr(0)}, "note", strSchema()),
+		Limited: true, Handler: AddBookmark, Legacy: "/api/add-bookmark"},
+	{Method: "DELETE", Path: "/bookmarks/{post_id}", Name: "removeBookmark", Summary: "Remove a bookmark", Tag: "bookmarks", Auth: true,
+		Params:  []Parameter{pathParam("post_id", idSchema())},
+		Limited: true, Handler: RemoveBookmark, Legacy: "/api/remove-bookmark"},
+	{Method: "GET", Path: "/collections", Name: "listCollections", Summary: "List bookmark collections", Tag: "bookmarks", Auth: true, Scope: ScopeRead,
+		Response: []Collection{}, Handler: GetCollections, Legacy: "/api/get-collections"},
+	{Method: "POST", Path: "/collections", Name: "createCollection", Summary: "Create a bookmark collection", Tag: "bookmarks", Auth: true,
+		Body:     objSchema("name*", strSchema()),
+		Response: Collection{}, Limited: true, Status: http.StatusCreated, Handler: AddCollection, Legacy: "/api/add-collection"},
+	{Method: "POST", Path: "/collections/rename", Name: "renameCollection", Summary: "Rename a bookmark collection", Tag: "bookmarks", Auth: true,
+		Body:    objSchema("id*", idSchema(), "name*", strSchema()),
+		Limited: true, Handler: RenameCollection, Legacy: "/api/rename-collection"},
+	{Method: "DELETE", Path: "/collections/{id}", Name: "deleteCollection", Summary: "Delete a bookmark collection", Tag: "bookmarks", Auth: true,
+		Params:  []Parameter{pathParam("id", idSchema())},
+		Limited: true, Handler: DeleteCollection, Legacy: "/api/delete-collection"},
+
+	// Notifications
+	{Method: "GET", Path: "/notifications", Name: "listNotifications", Summary: "List notifications", Tag: "notifications", Auth: true, Scope: ScopeRead,
+		Params:   []Parameter{cursorParam, offsetParam},
+		Response: Notification{}, Paged: true, Handler: GetNotifications, Legacy: "/api/get-notifications"},
+	{Method: "GET", Path: "/notifications/unread-count", Name: "countUnreadNotifications", Summary: "Count unread notifications", Tag: "notifications", Auth: true, Scope: ScopeRead,
+		Response: map[string]int{}, Handler: GetUnreadNotificationCount, Legacy: "/api/get-unread-notification-count"},
+	{Method: "POST", Path: "/notifications/read", Name: "markNotificationsRead", Summary: "Mark notifications as read", Tag: "notifications", Auth: true,
+		Body: notifBulkBody, Response: map[string]int64{}, Limited: true, Handler: MarkNotificationsRead, Legacy: "/api/mark-notifications-read"},
+	{Method: "POST", Path: "/notifications/unread", Name: "markNotificationsUnread", Summary: "Mark notifications as unread", Tag: "notifications", Auth: true,
+		Body: notifBulkBody, Response: map[string]int64{}, Limited: true, Handler: MarkNotificationsUnread, Legacy: "/api/mark-notifications-unread"},
+	{Method: "DELETE", Path: "/notifications", Name: "deleteNotifications", Summary: "Delete notifications", Tag: "notifications", Auth: true,
+		Body: notifBulkBody, Response: map[string]int64{}, Limited: true, Handler: DeleteNotifications, Legacy: "/api/delete-notifications"},
+	{Method: "DELETE", Path: "/notifications/{id}", Name: "deleteNotification", Summary: "Delete a notification", Tag: "notifications", Auth: true,
+		Params:  []Parameter{pathParam("id", idSchema())},
+		Limited: true, Handler: DeleteNotification, Legacy: "/api/delete-notification"},
+	{Method: "POST", Limited: true, Handler: MarkNotificationAsRead,
+		Legacy: "/api/mark-notification-read", Successor: "/notifications/read"},
+	{Method: "DELETE", Limited: true, Handler: DeleteAllNotifications,
+		Legacy: "/api/delete-all-notifications", Successor: "/notifications"},
+
+	// Messages
+	{Method: "GET", Path: "/messages", Name: "listMessages", Summary: "List the messages exchanged with a user", Tag: "messages", Auth: true, Scope: ScopeMessage,
+		Params:   []Parameter{requiredQuery("user", strSchema()), cursorParam, offsetParam},
+		Response: Message{}, Paged: true, Handler: GetMessages, Legacy: "/api/get-messages"},
+	{Method: "POST", Path: "/messages", Name: "sendMessage", Summary: "Send a message", Tag: "messages", Auth: true, Scope: ScopeMessage,
+		Body:     objSchema("receiver*", strSchema(), "content*", strSchema()),
+		Response: map[string]any{}, Limited: true, Handler: SendMessage, Legacy: "/api/send-message"},
+	{Method: "POST", Path: "/online-users", Name: "updateOnlineUsers", Summary: "Sort chat users by last message", Tag: "messages", Auth: true, Scope: ScopeMessage,
+		Body: objSchema("users*", arraySchema(objSchema(
+			"username*", strSchema(),
+			"profile_pic", strSchema(),
+			"last_msg", &Schema{Type: "string", Format: "date-time"},
+		), 0)),
+		Response: []OnlineUserInfo{}, Limited: true, Handler: UpdateOnlineUsers, Legacy: "/api/update-online-users"},
+
+	// Drafts
+	{Method: "GET", Path: "/drafts", Name: "listDrafts", Summary: "List drafts and scheduled posts", Tag: "drafts", Auth: true, Scope: ScopeRead,
+		Params:   []Parameter{queryParam("scheduled", boolSchema())},
+		Response: []*Draft{}, Handler: GetDrafts, Legacy: "/api/get-drafts"},
+	{Method: "POST", Path: "/drafts", Name: "createDraft", Summary: "Save a draft", Tag: "drafts", Auth: true, Scope: ScopePost,
+		Form: postForm, Response: Draft{}, Limited: true, Status: http.StatusCreated, Handler: SaveDraft, Legacy: "/api/save-draft"},
+	{Method: "POST", Path: "/drafts/{id}", Name: "updateDraft", Summary: "Autosave a draft", Tag: "drafts", Auth: true, Scope: ScopePost,
+		Params: []Parameter{pathParam("id", idSchema())},
+		Form:   postForm, Response: Draft{}, Limited: true, Handler: SaveDraft},
+	{Method: "GET", Path: "/drafts/{id}", Name: "getDraft", Summary: "Get a draft", Tag: "drafts", Auth: true, Scope: ScopeRead,
+		Params:   []Parameter{pathParam("id", idSchema())},
+		Response: Draft{}, Handler: GetDraft, Legacy: "/api/get-draft"},
+	{Method: "DELETE", Path: "/drafts/{id}", Name: "deleteDraft", Summary: "Delete a draft", Tag: "drafts", Auth: true, Scope: ScopePost,
+		Params:  []Parameter{pathParam("id", idSchema())},
+		Limited: true, Handler: DeleteDraft, Legacy: "/api/delete-draft"},
+	{Method: "POST", Path: "/drafts/{id}/publish", Name: "publishDraft", Summary: "Publish a draft now", Tag: "drafts", Auth: true, Scope: ScopePost,
+		Params:   []Parameter{pathParam("id", idSchema())},
+		Response: map[string]int64{}, Limited: true, Status: http.StatusCreated, Handler: PublishDraftHandler, Legacy: "/api/publish-draft"},
+
+	// Auth
+	{Method: "POST", Path: "/login", Name: "login", Summary: "Log in with email or username", Tag: "auth",
+		Body:     objSchema("login*", strSchema(), "password*", strSchema()),
+		Response: map[string]string{}, Limited: true, Handler: LoginHandler, Legacy: "/api/login"},
+	{Method: "POST", Path: "/signup", Name: "signup", Summary: "Create an account", Tag: "auth",
+		Form: objSchema(
+			"email*", strSchema(),
+			"username*", strSchema(),
+			"password*", strSchema(),
+			"first_name*", strSchema(),
+			"last_name*", strSchema(),
+			"age*", strSchema(),
+			"gender*", strSchema(),
+			"profile_pic", &Schema{Type: "string", Format: "binary"},
+		),
+		Response: map[string]string{}, Limited: true, Status: http.StatusCreated, Handler: SignUpHandler, Legacy: "/api/signup"},
+	{Method: "POST", Path: "/logout", Name: "logout", Summary: "Log out", Tag: "auth", Auth: true,
+		Limited: true, Handler: LogoutHandler, Legacy: "/api/logout"},
+	{Method: "POST", Path: "/social-signup", Name: "socialSignup", Summary: "Pick a username after a social login", Tag: "auth", Auth: true,
+		Body:     objSchema("username*", strSchema()),
+		Response: map[string]string{}, Handler: SocialSignupHandler, Legacy: "/api/social-signup"},
+	{Method: "GET", Path: "/social-providers", Name: "listSocialProviders", Summary: "List the configured social logins", Tag: "auth", Scope: ScopeRead,
+		Response: map[string]bool{}, Handler: CheckOAuth, Legacy: "/api/social-check"},
+
+	// Personal access tokens and bot accounts
+	{Method: "GET", Path: "/tokens", Name: "listTokens", Summary: "List personal access tokens", Tag: "tokens", Auth: true,
+		Response: []APIToken{}, Handler: GetTokens},
+	{Method: "POST", Path: "/tokens", Name: "createToken", Summary: "Create a personal access token, shown once", Tag: "tokens", Auth: true,
+		Body: objSchema(
+			"name*", &Schema{Type: "string", MaxLength: maxTokenNameSize},
+			"scopes*", arraySchema(enumSchema(tokenScopes...), len(tokenScopes)),
+			"expires_in_days", &Schema{Type: "integer", Minimum: intPtr(1), Description: "90 when omitted, up to 365"},
+		),
+		Response: APIToken{}, Status: http.StatusCreated, Limited: true, Handler: CreateToken},
+	{Method: "DELETE", Path: "/tokens/{id}", Name: "deleteToken", Summary: "Revoke a personal access token", Tag: "tokens", Auth: true,
+		Params:   []Parameter{pathParam("id", idSchema())},
+		Response: map[string]int{}, Limited: true, Handler: DeleteToken},
+	{Method: "POST", Path: "/me/bot", Name: "setBotAccount", Summary: "Flag the account as a bot", Tag: "tokens", Auth: true,
+		Body:     objSchema("is_bot*", boolSchema()),
+		Response: map[string]bool{}, Limited: true, Handler: SetBotAccount},
+
+	// Admin
+	{Method: "GET", Path: "/admin/storage", Name: "getStorageReport", Summary: "Report upload storage use", Tag: "admin", Auth: true,
+		Params:   []Parameter{offsetParam},
+		Response: StorageReport{}, Handler: GetStorageReport, Legacy: "/api/admin/storage"},
+	{Method: "GET", Path: "/admin/webhooks", Name: "listWebhooks", Summary: "List webhooks", Tag: "admin", Auth: true,
+		Response: []Webhook{}, Handler: GetWebhooks},
+	{Method: "POST", Path: "/admin/webhooks", Name: "createWebhook", Summary: "Subscribe a URL to events, the secret is shown once", Tag: "admin", Auth: true,
+		Body: objSchema(
+			"url*", &Schema{Type: "string", MaxLength: maxWebhookURL},
+			"events*", arraySchema(enumSchema(webhookEvents...), len(webhookEvents)),
+			"secret", &Schema{Type: "string", Description: "Generated when omitted"},
+		),
+		Response: Webhook{}, Status: http.StatusCreated, Limited: true, Handler: AddWebhook},
+	{Method: "DELETE", Path: "/admin/webhooks/{id}", Name: "deleteWebhook", Summary: "Delete a webhook and its deliveries", Tag: "admin", Auth: true,
+		Params:   []Parameter{pathParam("id", idSchema())},
+		Response: map[string]int{}, Limited: true, Handler: DeleteWebhook},
+	{Method: "GET", Path: "/admin/webhooks/{id}/deliveries", Name: "listWebhookDeliveries", Summary: "List the deliveries of a webhook", Tag: "admin", Auth: true,
+		Params:   []Parameter{pathParam("id", idSchema()), offsetParam, queryParam("status", enumSchema("pending", "succeeded", "failed"))},
+		Response: []WebhookDelivery{}, Handler: GetWebhookDeliveries},
+	{Method: "POST", Path: "/admin/webhook-deliveries/{id}/replay", Name: "replayWebhookDelivery", Summary: "Send a delivery again", Tag: "admin", Auth: true,
+		Params:   []Parameter{pathParam("id", idSchema())},
+		Response: map[string]int64{}, Status: http.StatusCreated, Limited: true, Handler: ReplayWebhookDelivery},
+	{Method: "GET", Path: "/admin/jobs", Name: "listJobs", Summary: "List background jobs", Tag: "admin", Auth: true,
+		Params:   []Parameter{offsetParam, queryParam("status", enumSchema(jobStatuses...)), queryParam("kind", strSchema())},
+		Response: []Job{}, Handler: GetJobs},
+	{Method: "POST", Path: "/admin/jobs/{id}/retry", Name: "retryJob", Summary: "Queue a failed job again", Tag: "admin", Auth: true,
+		Params:   []Parameter{pathParam("id", idSchema())},
+		Response: map[string]int{}, Limited: true, Handler: RetryJob},
+}
+
+type apiContextKey struct{}
+
+// Whether the request came through the v1 API.
+func IsAPIv1(r *http.Request) bool {
+	v1, _ := r.Context().Value(apiContextKey{}).(bool)
+	return v1
+}
+
+// Register the v1 API and the deprecated /api aliases.
+func RegisterAPI(mux *http.ServeMux, rl *RateLimiter) {
+	for _, route := range apiRoutes {
+		if route.Legacy == "" {
+			continue
+		}
+		successor := apiPrefix + route.Successor
+		if route.Path != "" {
+			successor = apiPrefix + route.Path
+		}
+		handler := deprecated(requireScope(route.Scope, validated(&route, nil)), successor)
+		if route.Limited {
+			handler = rl.Middleware(handler)
+		}
+		mux.Handle(route.Legacy, handler)
+	}
+	mux.Handle(apiPrefix+"/", &apiRouter{rl: rl})
+}
+
+// Old route, answered as before with a pointer to its v1 successor.
+func deprecated(next http.Handler, successor string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+type apiRouter struct {
+	rl *RateLimiter
+}
+
+func (api *apiRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	if path == "/openapi.json" {
+		OpenAPIHandler(w, r)
+		return
+	}
+
+	route, params, allowed := matchRoute(r.Method, path)
+	if route == nil {
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+			return
+		}
+		writeAPIError(w, http.StatusNotFound, "Not found", nil)
+		return
+	}
+
+	// Path params are read as query params by the handlers
+	query := r.URL.Query()
+	for name, value := range params {
+		query.Set(name, value)
+	}
+	r.URL.RawQuery = query.Encode()
+	r = r.WithContext(context.WithValue(r.Context(), apiContextKey{}, true))
+	logRoute(r, apiPrefix+route.Path)
+
+	rec := &apiRecorder{ResponseWriter: w}
+	handler := requireScope(route.Scope, validated(route, func(problems []APIProblem) {
+		rec.details = problems
+	}))
+	if route.Limited {
+		handler = api.rl.Middleware(handler)
+	}
+	handler.ServeHTTP(rec, r)
+	rec.flush()
+}
+
+// Handler of a route checking requests against the spec first, the
+// problems found are given to report. Old routes without a v1 path, and
+// their requests with another method, are left to the handler.
+func validated(route *APIRoute, report func([]APIProblem)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route.Path != "" && r.Method == route.Method {
+			op := apiSpec().Paths[route.Path][strings.ToLower(route.Method)]
+			if problems, status := validateRequest(r, op); len(problems) > 0 {
+				if report != nil {
+					report(problems)
+				}
+				JsonError(w, problems[0].Field+" "+problems[0].Problem, status, nil)
+				return
+			}
+		}
+		route.Handler(w, r)
+	})
+}
+
+// Route of a method and path, with its path params. When the path exists
+// with other methods, they are returned instead.
+func matchRoute(method, path string) (*APIRoute, map[string]string, []string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	var best []*APIRoute
+	bestScore := -1
+	var bestParams []map[string]string
+	for i := range apiRoutes {
+		route := &apiRoutes[i]
+		if route.Path == "" {
+			continue
+		}
+		params, score, ok := matchPath(route.Path, segments)
+		if !ok || score < bestScore {
+			continue
+		}
+		// Literal segments win over params
+		if score > bestScore {
+			best, bestParams, bestScore = nil, nil, score
+		}
+		best = append(best, route)
+		bestParams = append(bestParams, params)
+	}
+
+	var allowed []string
+	for i, route := range best {
+		if route.Method == method {
+			return route, bestParams[i], nil
+		}
+		allowed = append(allowed, route.Method)
+	}
+	return nil, nil, allowed
+}
+
+// Match path segments against a template, score is the number of literal segments.
+func matchPath(template string, segments []string) (map[string]string, int, bool) {
+	parts := strings.Split(strings.Trim(template, "/"), "/")
+	if len(parts) != len(segments) {
+		return nil, 0, false
+	}
+	params := make(map[string]string)
+	score := 0
+	for i, part := range parts {
+		if name, ok := strings.CutPrefix(part, "{"); ok {
+			value, err := url.PathUnescape(segments[i])
+			if err != nil || value == "" {
+				return nil, 0, false
+			}
+			params[strings.TrimSuffix(name, "}")] = value
+			continue
+		}
+		if part != segments[i] {
+			return nil, 0, false
+		}
+		score++
+	}
+	return params, score, true
+}
+
+// Buffers a handler's reply to wrap it in the v1 envelope.
+// Headers go straight to the client, so cookies set by handlers are kept.
+type apiRecorder struct {
+	http.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	details []APIProblem
+}
+
+func (rec *apiRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *apiRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+// For http.ResponseController and writerLogger.
+func (rec *apiRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Write the buffered reply as {"data": ...} or {"error": ...}.
+func (rec *apiRecorder) flush() {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := bytes.TrimSpace(rec.body.Bytes())
+
+	if status >= 400 {
+		var reply struct {
+			Msg string `json:"msg"`
+		}
+		json.Unmarshal(body, &reply)
+		if reply.Msg == "" {
+			reply.Msg = http.StatusText(status)
+		}
+		writeAPIError(rec.ResponseWriter, status, reply.Msg, rec.details)
+		return
+	}
+
+	var data any
+	switch {
+	case len(body) == 0:
+		data = nil
+	case json.Valid(body):
+		data = json.RawMessage(body)
+	default:
+		data = APIMessage{Message: string(body)}
+	}
+	writeAPIJSON(rec.ResponseWriter, status, map[string]any{"data": data})
+}
+
+// Write an error envelope.
+func writeAPIError(w http.ResponseWriter, status int, message string, details []APIProblem) {
+	code, ok := apiErrorCodes[status]
+	if !ok {
+		code = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+	writeAPIJSON(w, status, map[string]apiError{"error": {Code: code, Message: message, Details: details}})
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
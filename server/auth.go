@@ -6,32 +6,25 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"regexp"
 
 	"github.com/gofrs/uuid"
 )
 
-var (
-	GithubClientSecret string
-	GoogleClientSecret string
-	GithubClientID     string
-	GoogleClientID     string
-)
-
-// Functions to set up OAuth callback URLs.
+// Functions to set up OAuth callback URLs, on the site or localhost.
 func getGithubReURL() string {
-	if os.Getenv("FLY_APP_NAME") != "" {
-		return "https://dwi.fly.dev/auth/callback?provider=github"
-	}
-	return fmt.Sprintf("https://localhost:%s/auth/callback?provider=github", Port)
+	return callbackBase() + "/auth/callback?provider=github"
 }
 
 func getGoogleReURL() string {
-	if os.Getenv("FLY_APP_NAME") != "" {
-		return "https://dwi.fly.dev/auth/callback?provider=google"
+	return callbackBase() + "/auth/callback?provider=google"
+}
+
+func callbackBase() string {
+	if Conf.SiteURL != "" {
+		return Conf.SiteURL
 	}
-	return fmt.Sprintf("https://localhost:%s/auth/callback?provider=google", Port)
+	return fmt.Sprintf("https://localhost:%s", Port)
 }
 
 type GitHub struct {
@@ -47,7 +40,7 @@ type Google struct {
 func GoogleLoginHandler(w http.ResponseWriter, r *http.Request) {
 	authURL := fmt.Sprintf(
 		"https://accounts.google.com/o/oauth2/v2/auth?client_id=%s&redirect_uri=%s&response_type=code&scope=openid%%20email",
-		GoogleClientID,
+		Conf.OAuth.GoogleClientID,
 		getGoogleReURL(),
 	)
 	http.Redirect(w, r, authURL, http.StatusFound)
@@ -57,7 +50,7 @@ func GoogleLoginHandler(w http.ResponseWriter, r *http.Request) {
 func GithubLoginHandler(w http.ResponseWriter, r *http.Request) {
 	authURL := fmt.Sprintf(
 		"https://github.com/login/oauth/authorize?client_id=%s&redirect_uri=%s&scope=user",
-		GithubClientID,
+		Conf.OAuth.GithubClientID,
 		getGithubReURL(),
 	)
 	http.Redirect(w, r, authURL, http.StatusFound)
@@ -77,8 +70,8 @@ func SocialCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	case "google":
 		// Assuming GoogleReURL is the redirect URI configured with Google.
 		data := url.Values{}
-		data.Set("client_id", GoogleClientID)
-		data.Set("client_secret", GoogleClientSecret)
+		data.Set("client_id", Conf.OAuth.GoogleClientID)
+		data.Set("client_secret", Conf.OAuth.GoogleClientSecret)
 		data.Set("redirect_uri", getGoogleReURL())
 		data.Set("grant_type", "authorization_code")
 		data.Set("code", code)
@@ -113,8 +106,8 @@ func SocialCallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 	case "github":
 		data := url.Values{}
-		data.Set("client_id", GithubClientID)
-		data.Set("client_secret", GithubClientSecret)
+		data.Set("client_id", Conf.OAuth.GithubClientID)
+		data.Set("client_secret", Conf.OAuth.GithubClientSecret)
 		data.Set("code", code)
 		resp, err := http.PostForm("https://github.com/login/oauth/access_token", data)
 		if err != nil || resp.StatusCode != http.StatusOK {
@@ -236,6 +229,7 @@ func SocialSignupHandler(w http.ResponseWriter, r *http.Request) {
 		Email:    email,
 		Username: reqData.Username,
 	}
+	Publish(UserRegistered{UserID: newUser.ID, Username: newUser.Username})
 	// Create a session for the newly signed-up user.
 	if err = CreateSession(w, &newUser); err != nil {
 		JsonError(w, "Error creating session", http.StatusInternalServerError, err)
@@ -280,20 +274,20 @@ func ValidateUsername(w http.ResponseWriter, username string) bool {
 
 // Check if env variables for social OAuth buttons are available.
 func CheckOAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
 	type OauthResponse struct {
 		HasGoogle bool `json:"hasGoogle"`
 		HasGithub bool `json:"hasGithub"`
 	}
 
-	googleClientID := os.Getenv("GOOGLE_CLIENT_ID")
-	googleClientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
-	githubClientID := os.Getenv("GITHUB_CLIENT_ID")
-	githubClientSecret := os.Getenv("GITHUB_CLIENT_SECRET")
-
-	// Build a response indicating which env vars are set
+	// Build a response indicating which providers are configured
 	response := OauthResponse{
-		HasGoogle: googleClientID != "" && googleClientSecret != "",
-		HasGithub: githubClientID != "" && githubClientSecret != "",
+		HasGoogle: Conf.OAuth.GoogleClientID != "" && Conf.OAuth.GoogleClientSecret != "",
+		HasGithub: Conf.OAuth.GithubClientID != "" && Conf.OAuth.GithubClientSecret != "",
 	}
 
 	// Send the JSON response
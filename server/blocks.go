@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Returned when an action targets a user blocked either way.
+var ErrBlocked = errors.New("you can't interact with this user")
+
+// Block is two-way: no DMs, reactions, comments or presence between both users,
+// and their content is hidden to each other.
+// Mute is one-way: the muted user's content and presence are hidden to the muter only.
+var BlockKinds = map[string]bool{
+	"block": true,
+	"mute":  true,
+}
+
+// Entry of the blocked/muted users list.
+type BlockEntry struct {
+	Username   string    `json:"username"`
+	ProfilePic string    `json:"profile_pic"`
+	Kind       string    `json:"kind"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// IDs of users whose content and presence are hidden to the viewer.
+const hiddenUsersQuery = `
+    SELECT target_id FROM blocks WHERE user_id = ?
+    UNION
+    SELECT user_id FROM blocks WHERE target_id = ? AND kind = 'block'`
+
+// SQL condition excluding rows of users hidden to the viewer,
+// col holds the user ID (e.g. "p.user_id"). Guests (0) see everything.
+func VisibleTo(viewerID int, col string) (string, []any) {
+	if viewerID == 0 {
+		return "1 = 1", nil
+	}
+	return col + " NOT IN (" + hiddenUsersQuery + ")", []any{viewerID, viewerID}
+}
+
+// Posts whose author isn't hidden to the viewer.
+func VisiblePosts(viewerID int) PostScope {
+	where, args := VisibleTo(viewerID, "p.user_id")
+	return PostScope{Where: where, Args: args}
+}
+
+// Set of users hidden to the viewer, for filtering in memory.
+func HiddenUsers(viewerID int) (map[int]bool, error) {
+	rows, err := DB.Query(hiddenUsersQuery, viewerID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hidden := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		hidden[id] = true
+	}
+	return hidden, rows.Err()
+}
+
+// Whether one of the two users blocked the other.
+func IsBlocked(userA, userB int) (bool, error) {
+	var blocked bool
+	err := DB.QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM blocks
+            WHERE kind = 'block'
+            AND ((user_id = ? AND target_id = ?) OR (user_id = ? AND target_id = ?)))`,
+		userA, userB, userB, userA,
+	).Scan(&blocked)
+	return blocked, err
+}
+
+// Whether the target is hidden to the viewer (blocked either way, or muted by the viewer).
+func IsHidden(viewerID, targetID int) (bool, error) {
+	if viewerID == 0 {
+		return false, nil
+	}
+	var hidden bool
+	err := DB.QueryRow(`SELECT ? IN (`+hiddenUsersQuery+`)`, targetID, viewerID, viewerID).Scan(&hidden)
+	return hidden, err
+}
+
+// Block or mute a user, replaces the previous relation if any.
+func AddBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	var payload struct {
+		Username string `json:"username"`
+		Kind     string `json:"kind"` // "block" or "mute"
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		JsonError(w, "Invalid payload", http.StatusBadRequest, err)
+		return
+	}
+	if !BlockKinds[payload.Kind] {
+		JsonError(w, "Invalid kind", http.StatusBadRequest, nil)
+		return
+	}
+
+	target, err := GetUserByUsername(payload.Username)
+	if err != nil {
+		JsonError(w, "User not found", http.StatusNotFound, err)
+		return
+	}
+	if target.ID == user.ID {
+		JsonError(w, "You can't "+payload.Kind+" yourself", http.StatusBadRequest, nil)
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		JsonError(w, "Failed to "+payload.Kind+" user", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        INSERT INTO blocks (user_id, target_id, kind)
+        VALUES (?, ?, ?)
+        ON CONFLICT(user_id, target_id) DO UPDATE SET
+            kind = excluded.kind,
+            created_at = CURRENT_TIMESTAMP`,
+		user.ID, target.ID, payload.Kind,
+	)
+	if err != nil {
+		JsonError(w, "Failed to "+payload.Kind+" user", http.StatusInternalServerError, err)
+		return
+	}
+
+	// Blocking breaks the follows both ways
+	if payload.Kind == "block" {
+		_, err = tx.Exec(`
+            DELETE FROM follows
+            WHERE (follower_id = ? AND followee_id = ?) OR (follower_id = ? AND followee_id = ?)`,
+			user.ID, target.ID, target.ID, user.ID,
+		)
+		if err != nil {
+			JsonError(w, "Failed to block user", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		JsonError(w, "Failed to "+payload.Kind+" user", http.StatusInternalServerError, err)
+		return
+	}
+
+	// Presence lists changed for both users
+	BroadcastOnlineUsers()
+	w.WriteHeader(http.StatusOK)
+}
+
+// Undo a block or mute.
+func RemoveBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	target, err := GetUserByUsername(r.URL.Query().Get("username"))
+	if err != nil {
+		JsonError(w, "User not found", http.StatusNotFound, err)
+		return
+	}
+
+	res, err := DB.Exec(`DELETE FROM blocks WHERE user_id = ? AND target_id = ?`, user.ID, target.ID)
+	if err != nil {
+		JsonError(w, "Failed to unblock user", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		JsonError(w, "User isn't blocked or muted", http.StatusNotFound, nil)
+		return
+	}
+
+	BroadcastOnlineUsers()
+	w.WriteHeader(http.StatusOK)
+}
+
+// List the users blocked or muted by the user.
+func GetBlocks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	rows, err := DB.Query(`
+        SELECT u.username, u.profile_pic, b.kind, b.created_at
+        FROM blocks b
+        JOIN users u ON u.id = b.target_id
+        WHERE b.user_id = ?
+        ORDER BY b.created_at DESC`,
+		user.ID,
+	)
+	if err != nil {
+		JsonError(w, "Failed to fetch blocked users", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	blocks := []BlockEntry{}
+	for rows.Next() {
+		var b BlockEntry
+		if err := rows.Scan(&b.Username, &b.ProfilePic, &b.Kind, &b.CreatedAt); err != nil {
+			JsonError(w, "Failed reading blocked users", http.StatusInternalServerError, err)
+			return
+		}
+		blocks = append(blocks, b)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blocks)
+}
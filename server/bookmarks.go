@@ -0,0 +1,398 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxBookmarkNote    = 1000
+	maxCollectionName  = 50
+	maxCollectionsUser = 50
+)
+
+// Named folder of bookmarks, private to its owner.
+type Collection struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Count     int       `json:"count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Bookmark a post, or update the collection and note of an existing bookmark.
+func AddBookmark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Login to bookmark posts", http.StatusUnauthorized, err)
+		return
+	}
+
+	var payload struct {
+		PostID       int    `json:"post_id"`
+		CollectionID int    `json:"collection_id"` // 0 for none
+		Note         string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		JsonError(w, "Invalid payload", http.StatusBadRequest, err)
+		return
+	}
+
+	payload.Note = strings.TrimSpace(payload.Note)
+	if len(payload.Note) > maxBookmarkNote {
+		JsonError(w, fmt.Sprintf("Note exceeded max length of %d characters", maxBookmarkNote), http.StatusBadRequest, nil)
+		return
+	}
+
+	var exists bool
+	err = DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)`, payload.PostID).Scan(&exists)
+	if err != nil {
+		JsonError(w, "Failed to verify post existence", http.StatusInternalServerError, err)
+		return
+	}
+	if !exists {
+		JsonError(w, "Post not found", http.StatusNotFound, nil)
+		return
+	}
+
+	var collectionID any
+	if payload.CollectionID != 0 {
+		err = DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM bookmark_collections WHERE id = ? AND user_id = ?)`,
+			payload.CollectionID, user.ID).Scan(&exists)
+		if err != nil {
+			JsonError(w, "Failed to verify collection existence", http.StatusInternalServerError, err)
+			return
+		}
+		if !exists {
+			JsonError(w, "Collection not found", http.StatusNotFound, nil)
+			return
+		}
+		collectionID = payload.CollectionID
+	}
+
+	_, err = DB.Exec(`
+        INSERT INTO bookmarks (user_id, post_id, collection_id, note)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT(user_id, post_id) DO UPDATE SET
+            collection_id = excluded.collection_id,
+            note = excluded.note`,
+		user.ID, payload.PostID, collectionID, html.EscapeString(payload.Note),
+	)
+	if err != nil {
+		JsonError(w, "Failed to bookmark post", http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Remove a post from the user's bookmarks.
+func RemoveBookmark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.URL.Query().Get("post_id"))
+	if err != nil {
+		JsonError(w, "Invalid post_id", http.StatusBadRequest, err)
+		return
+	}
+
+	res, err := DB.Exec(`DELETE FROM bookmarks WHERE user_id = ? AND post_id = ?`, user.ID, postID)
+	if err != nil {
+		JsonError(w, "Failed to remove bookmark", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		JsonError(w, "Bookmark not found", http.StatusNotFound, nil)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Handler to Get the User's bookmarked Posts, optionally of one collection.
+func GetBookmarks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	// Parse offset
+	offsetParam := r.URL.Query().Get("offset")
+	if offsetParam == "" {
+		offsetParam = "0"
+	}
+	offset, err := strconv.Atoi(offsetParam)
+	if err != nil {
+		JsonError(w, "Bad request", http.StatusBadRequest, err)
+		return
+	}
+
+	filter, args := VisibleTo(user.ID, "p.user_id")
+	args = append([]any{user.ID}, args...)
+	if collectionParam := r.URL.Query().Get("collection_id"); collectionParam != "" {
+		collectionID, err := strconv.Atoi(collectionParam)
+		if err != nil {
+			JsonError(w, "Invalid collection_id", http.StatusBadRequest, err)
+			return
+		}
+		filter += " AND b.collection_id = ?"
+		args = append(args, collectionID)
+	}
+	args = append(args, Conf.Limits.ProfileItems, offset)
+
+	rows, err := DB.Query(`
+        SELECT p.id, p.user_id, p.title, p.content, p.image, p.created_at, u.username, u.profile_pic, u.is_bot
+        FROM bookmarks b
+        JOIN posts p ON b.post_id = p.id
+        JOIN users u ON p.user_id = u.id
+        WHERE b.user_id = ? AND `+filter+`
+        ORDER BY b.created_at DESC, b.id DESC
+        LIMIT ?
+        OFFSET ?
+    `, args...)
+	if err != nil {
+		JsonError(w, "Failed to fetch bookmarks", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	posts, err := ScanRows(rows)
+	if err != nil {
+		JsonError(w, "Failed scanning post data", http.StatusInternalServerError, err)
+		return
+	}
+	if err := LoadPostDetails(posts, user.ID); err != nil {
+		JsonError(w, "Failed to load posts details", http.StatusInternalServerError, err)
+		return
+	}
+	if posts == nil {
+		posts = []Post{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(posts)
+}
+
+// List the user's collections with their bookmarks count.
+func GetCollections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	rows, err := DB.Query(`
+        SELECT c.id, c.name, COUNT(b.id), c.created_at
+        FROM bookmark_collections c
+        LEFT JOIN bookmarks b ON b.collection_id = c.id
+        WHERE c.user_id = ?
+        GROUP BY c.id
+        ORDER BY c.name`,
+		user.ID,
+	)
+	if err != nil {
+		JsonError(w, "Failed to fetch collections", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	collections := []Collection{}
+	for rows.Next() {
+		var c Collection
+		if err := rows.Scan(&c.ID, &c.Name, &c.Count, &c.CreatedAt); err != nil {
+			JsonError(w, "Failed reading collections", http.StatusInternalServerError, err)
+			return
+		}
+		collections = append(collections, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(collections)
+}
+
+// Create a named collection.
+func AddCollection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	var payload struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		JsonError(w, "Invalid payload", http.StatusBadRequest, err)
+		return
+	}
+	name, quit := collectionName(w, payload.Name)
+	if quit {
+		return
+	}
+
+	var count int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM bookmark_collections WHERE user_id = ?`, user.ID).Scan(&count); err != nil {
+		JsonError(w, "Failed to create collection", http.StatusInternalServerError, err)
+		return
+	}
+	if count >= maxCollectionsUser {
+		JsonError(w, fmt.Sprintf("You can have up to %d collections", maxCollectionsUser), http.StatusBadRequest, nil)
+		return
+	}
+
+	var c Collection
+	err = DB.QueryRow(`
+        INSERT INTO bookmark_collections (user_id, name)
+        VALUES (?, ?)
+        ON CONFLICT(user_id, name) DO NOTHING
+        RETURNING id, name, created_at`,
+		user.ID, name,
+	).Scan(&c.ID, &c.Name, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		JsonError(w, "Collection already exists", http.StatusConflict, nil)
+		return
+	} else if err != nil {
+		JsonError(w, "Failed to create collection", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// Rename one of the user's collections.
+func RenameCollection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	var payload struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		JsonError(w, "Invalid payload", http.StatusBadRequest, err)
+		return
+	}
+	name, quit := collectionName(w, payload.Name)
+	if quit {
+		return
+	}
+
+	res, err := DB.Exec(`UPDATE bookmark_collections SET name = ? WHERE id = ? AND user_id = ?`, name, payload.ID, user.ID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			JsonError(w, "Collection already exists", http.StatusConflict, err)
+			return
+		}
+		JsonError(w, "Failed to rename collection", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		JsonError(w, "Collection not found", http.StatusNotFound, nil)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Delete a collection, its bookmarks are kept without a collection.
+func DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		JsonError(w, "Invalid collection id", http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		JsonError(w, "Failed to delete collection", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM bookmark_collections WHERE id = ? AND user_id = ?`, id, user.ID)
+	if err != nil {
+		JsonError(w, "Failed to delete collection", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		JsonError(w, "Collection not found", http.StatusNotFound, nil)
+		return
+	}
+	// Foreign keys aren't enforced, unlink bookmarks by hand.
+	if _, err := tx.Exec(`UPDATE bookmarks SET collection_id = NULL WHERE collection_id = ? AND user_id = ?`, id, user.ID); err != nil {
+		JsonError(w, "Failed to delete collection", http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		JsonError(w, "Failed to delete collection", http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Trim, check and escape a collection name.
+func collectionName(w http.ResponseWriter, name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		JsonError(w, "Collection name is required", http.StatusBadRequest, nil)
+		return "", true
+	}
+	if len(name) > maxCollectionName {
+		JsonError(w, fmt.Sprintf("Collection name exceeded max length of %d characters", maxCollectionName), http.StatusBadRequest, nil)
+		return "", true
+	}
+	return html.EscapeString(name), false
+}
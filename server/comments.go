@@ -1,16 +1,18 @@
 package server
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"html"
 	"net/http"
-	"regexp"
-	"strconv"
 	"strings"
+	"time"
 )
 
+// Num of comments on each scroll load.
+const commentsLimit = 20
+
 // Expected JSON structure for adding comments
 type CommentPayload struct {
 	PostID  int    `json:"id"`
@@ -38,68 +40,88 @@ func AddComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = processComment(&payload, user)
+	comment, err := processComment(&payload, user)
+	if err == ErrBlocked {
+		JsonError(w, "You can't comment on this post", http.StatusForbidden, err)
+		return
+	}
 	if err != nil {
 		JsonError(w, err.Error(), http.StatusBadRequest, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte("Comment added successfully"))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(comment)
 }
 
-// Validates, sanitizes and inserts the comment
-func processComment(payload *CommentPayload, user *User) error {
-	// Trim spaces and escape HTML
+// Validates and inserts the comment
+func processComment(payload *CommentPayload, user *User) (*Comment, error) {
+	// Trim spaces, Markdown source is stored as is and sanitized on render
 	payload.Content = strings.TrimSpace(payload.Content)
-	payload.Content = html.EscapeString(payload.Content)
 
-	// Remove more than three consecutive new lines to just two
-	re := regexp.MustCompile(`(\r\n|\r|\n){3,}`)
-	payload.Content = re.ReplaceAllString(payload.Content, "\n\n")
-
-	// Check minimum length after sanitization
+	// Check minimum length
 	if len(payload.Content) < 5 {
-		return errors.New("comment is too short")
+		return nil, errors.New("comment is too short")
 	}
 
 	if payload.Content == "" {
-		return errors.New("write something in your comment")
+		return nil, errors.New("write something in your comment")
 	}
 
 	// Check if post exists
 	var exists bool
 	err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)`, payload.PostID).Scan(&exists)
 	if err != nil {
-		return fmt.Errorf("failed to verify post existence: %w", err)
+		return nil, fmt.Errorf("failed to verify post existence: %w", err)
 	}
 	if !exists {
-		return errors.New("post does not exist")
+		return nil, errors.New("post does not exist")
+	}
+
+	// Get the post's owner (to notify)
+	var ownerID int
+	err = DB.QueryRow(`SELECT user_id FROM posts WHERE id = ?`, payload.PostID).Scan(&ownerID)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := IsBlocked(user.ID, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify post owner: %w", err)
+	}
+	if blocked {
+		return nil, ErrBlocked
 	}
 
 	// Save to database
-	_, err = DB.Exec(`
+	res, err := DB.Exec(`
         INSERT INTO comments (post_id, user_id, content)
         VALUES (?, ?, ?)
     `, payload.PostID, user.ID, payload.Content)
 	if err != nil {
-		return fmt.Errorf("failed to add comments: %w", err)
+		return nil, fmt.Errorf("failed to add comments: %w", err)
 	}
-
-	// Get the post's owner (to notify)
-	var ownerID int
-	err = DB.QueryRow(`SELECT user_id FROM posts WHERE id = ?`, payload.PostID).Scan(&ownerID)
+	commentID, err := res.LastInsertId()
 	if err != nil {
-		return err
-	}
-	// Insert to notification if the commenter != post owner
-	if ownerID != user.ID {
-		err := InsertNotification(ownerID, user.ID, &payload.PostID, "comment")
-		if err != nil {
-			fmt.Println("Failed to insert notification:", err)
-		}
-	}
-	return nil
+		return nil, fmt.Errorf("failed to add comments: %w", err)
+	}
+	comment := Comment{
+		ID:              int(commentID),
+		Username:        user.Username,
+		Content:         payload.Content,
+		ContentHTML:     RenderMarkdown(payload.Content),
+		ProfilePic:      user.ProfilePic,
+		ProfilePicThumb: ImageVariant(user.ProfilePic, "thumb"),
+		IsBot:           user.IsBot,
+		CreatedAt:       time.Now().UTC().Truncate(time.Second),
+	}
+	Publish(CommentCreated{
+		Comment:     comment,
+		PostID:      payload.PostID,
+		PostOwnerID: ownerID,
+		Author:      *user,
+	})
+	return &comment, nil
 }
 
 // Fetch all comments
@@ -115,39 +137,21 @@ func GetComments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
-	if err != nil || offset < 0 {
-		JsonError(w, "Wrong offset", http.StatusBadRequest, err)
+	cursor, offset, legacy, err := ParsePaging(r)
+	if err != nil {
+		JsonError(w, "Wrong offset or cursor", http.StatusBadRequest, err)
 		return
 	}
-
-	rows, err := DB.Query(`
-		SELECT c.id, c.content, c.created_at, u.username, u.profile_pic
-		FROM comments c
-		JOIN users u ON c.user_id = u.id
-		WHERE c.post_id = ?
-		ORDER BY c.created_at DESC
-		LIMIT 20 OFFSET ?
-	`, postID, offset)
+	comments, err := FetchComments(postID, ViewerID(r), cursor, offset)
 	if err != nil {
 		JsonError(w, "Failed to query comments", http.StatusInternalServerError, err)
 		return
 	}
 
-	defer rows.Close()
-
-	var comments []Comment
-	for rows.Next() {
-		var comment Comment
-		if err := rows.Scan(&comment.ID, &comment.Content, &comment.CreatedAt, &comment.Username, &comment.ProfilePic); err != nil {
-			fmt.Fprintln(w, err.Error())
-			continue
-		}
-		comments = append(comments, comment)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comments)
+	comments, next := paginate(comments, commentsLimit, func(c Comment) Cursor {
+		return Cursor{CreatedAt: c.CreatedAt, ID: c.ID}
+	})
+	WritePage(w, comments, next, legacy)
 }
 
 // Fetch just the number of comments
@@ -163,9 +167,12 @@ func GetCommentsCount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Counter maintained by post_stats triggers
 	var count int
-	err := DB.QueryRow(`SELECT COUNT(*) FROM comments WHERE post_id = ?`, postID).Scan(&count)
-	if err != nil {
+	err := DB.QueryRow(`SELECT comments FROM post_stats WHERE post_id = ?`, postID).Scan(&count)
+	if err == sql.ErrNoRows {
+		count = 0
+	} else if err != nil {
 		JsonError(w, "Error querying comment count: "+err.Error(), http.StatusInternalServerError, err)
 		return
 	}
@@ -175,3 +182,38 @@ func GetCommentsCount(w http.ResponseWriter, r *http.Request) {
 		"count": count,
 	})
 }
+
+// Comments of a post visible to the viewer, newest first
+// (commentsLimit + 1 to detect next page).
+func FetchComments(postID any, viewerID int, cursor *Cursor, offset int) ([]Comment, error) {
+	keyset, args := cursor.Where("c.created_at", "c.id")
+	visible, visibleArgs := VisibleTo(viewerID, "c.user_id")
+	args = append([]any{postID}, args...)
+	args = append(args, visibleArgs...)
+	args = append(args, commentsLimit+1, offset)
+
+	rows, err := DB.Query(`
+		SELECT c.id, c.content, c.created_at, u.username, u.profile_pic, u.is_bot
+		FROM comments c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.post_id = ? AND `+keyset+` AND `+visible+`
+		ORDER BY c.created_at DESC, c.id DESC
+		LIMIT ? OFFSET ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var comment Comment
+		if err := rows.Scan(&comment.ID, &comment.Content, &comment.CreatedAt, &comment.Username, &comment.ProfilePic, &comment.IsBot); err != nil {
+			return nil, err
+		}
+		comment.ContentHTML = RenderMarkdown(comment.Content)
+		comment.ProfilePicThumb = ImageVariant(comment.ProfilePic, "thumb")
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Server settings, read from the config file (config.toml), then env vars,
+// then flags, each overriding the previous ones.
+type Config struct {
+	Port    string `toml:"port"`     // Random available port when empty
+	SiteURL string `toml:"site_url"` // Public address, for OAuth callbacks, WebSocket origins and absolute links
+	DBPath  string `toml:"db_path"`
+
+	TLS struct {
+		Enabled bool   `toml:"enabled"` // Off when a proxy ends TLS, as on Fly.io
+		Cert    string `toml:"cert"`
+		Key     string `toml:"key"`
+		CA      string `toml:"ca"` // Extra trusted authority, optional
+	} `toml:"tls"`
+
+	OAuth struct {
+		GoogleClientID     string `toml:"google_client_id"`
+		GoogleClientSecret string `toml:"google_client_secret"`
+		GithubClientID     string `toml:"github_client_id"`
+		GithubClientSecret string `toml:"github_client_secret"`
+	} `toml:"oauth"`
+
+	Media struct {
+		Store string   `toml:"store"` // local or s3
+		S3    S3Config `toml:"s3"`
+	} `toml:"media"`
+
+	LinkPreviews struct {
+		AllowPrivate bool `toml:"allow_private"` // Never in production
+	} `toml:"link_previews"`
+
+	Log struct {
+		Level  string `toml:"level"`  // debug, info, warn or error
+		Format string `toml:"format"` // text or json
+	} `toml:"log"`
+
+	Limits struct {
+		HomePosts    int `toml:"home_posts"`     // Posts on each home page
+		ProfileItems int `toml:"profile_items"`  // Posts, comments or reactions on each profile page
+		Messages     int `toml:"messages"`       // Messages on each chat scroll load
+		MaxImageSize int `toml:"max_image_size"` // Bytes, at least the 20MB newPost.js allows
+	} `toml:"limits"`
+}
+
+// Settings, loaded by Initialise.
+var Conf = defaultConfig()
+
+const defaultConfigFile = "config.toml"
+
+func defaultConfig() Config {
+	var c Config
+	c.DBPath = "./database/forum.db"
+	c.TLS.Enabled = true
+	c.TLS.Cert = "tls/server.crt"
+	c.TLS.Key = "tls/server.key"
+	c.TLS.CA = "tls/ca.crt"
+	c.Media.Store = "local"
+	c.Media.S3.UseSSL = true
+	c.Log.Level = "info"
+	c.Log.Format = "text"
+	c.Limits.HomePosts = 10
+	c.Limits.ProfileItems = 6
+	c.Limits.Messages = 10
+	c.Limits.MaxImageSize = 20 << 20
+	return c
+}
+
+// Flags of the settings. The env var of a flag is its name in upper
+// snake case, e.g. -tls-cert and TLS_CERT.
+func (c *Config) flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Port, "port", c.Port, "Port to listen on, random when empty")
+	fs.StringVar(&c.SiteURL, "site-url", c.SiteURL, "Public address of the site, e.g. https://dwi.fly.dev")
+	fs.StringVar(&c.DBPath, "db-path", c.DBPath, "SQLite database file")
+	fs.BoolVar(&c.TLS.Enabled, "tls-enabled", c.TLS.Enabled, "Serve HTTPS, off behind a proxy ending TLS")
+	fs.StringVar(&c.TLS.Cert, "tls-cert", c.TLS.Cert, "TLS certificate file")
+	fs.StringVar(&c.TLS.Key, "tls-key", c.TLS.Key, "TLS key file")
+	fs.StringVar(&c.TLS.CA, "tls-ca", c.TLS.CA, "Extra trusted CA certificate file, optional")
+	fs.StringVar(&c.OAuth.GoogleClientID, "google-client-id", c.OAuth.GoogleClientID, "Google OAuth client id")
+	fs.StringVar(&c.OAuth.GoogleClientSecret, "google-client-secret", c.OAuth.GoogleClientSecret, "Google OAuth client secret")
+	fs.StringVar(&c.OAuth.GithubClientID, "github-client-id", c.OAuth.GithubClientID, "GitHub OAuth client id")
+	fs.StringVar(&c.OAuth.GithubClientSecret, "github-client-secret", c.OAuth.GithubClientSecret, "GitHub OAuth client secret")
+	fs.StringVar(&c.Media.Store, "media-store", c.Media.Store, "Where uploads are stored: local or s3")
+	fs.StringVar(&c.Media.S3.Endpoint, "s3-endpoint", c.Media.S3.Endpoint, "S3 host[:port], without scheme")
+	fs.StringVar(&c.Media.S3.Bucket, "s3-bucket", c.Media.S3.Bucket, "S3 bucket, created if missing")
+	fs.StringVar(&c.Media.S3.AccessKey, "s3-access-key", c.Media.S3.AccessKey, "S3 access key")
+	fs.StringVar(&c.Media.S3.SecretKey, "s3-secret-key", c.Media.S3.SecretKey, "S3 secret key")
+	fs.StringVar(&c.Media.S3.Region, "s3-region", c.Media.S3.Region, "S3 region, optional")
+	fs.BoolVar(&c.Media.S3.UseSSL, "s3-use-ssl", c.Media.S3.UseSSL, "Connect to S3 over HTTPS")
+	fs.StringVar(&c.Media.S3.PublicURL, "s3-public-url", c.Media.S3.PublicURL, "Public bucket/CDN address clients are redirected to, optional")
+	fs.BoolVar(&c.LinkPreviews.AllowPrivate, "link-preview-allow-private", c.LinkPreviews.AllowPrivate, "Unfurl links to private addresses, for local tests only")
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "Lowest logged level: debug, info, warn or error")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "Log format: text or json")
+	fs.IntVar(&c.Limits.HomePosts, "home-limit", c.Limits.HomePosts, "Posts on each home page")
+	fs.IntVar(&c.Limits.ProfileItems, "profile-limit", c.Limits.ProfileItems, "Items on each profile page")
+	fs.IntVar(&c.Limits.Messages, "messages-limit", c.Limits.Messages, "Messages on each chat load")
+	fs.IntVar(&c.Limits.MaxImageSize, "max-image-size", c.Limits.MaxImageSize, "Max size of an uploaded image, in bytes")
+}
+
+func envName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load the settings from the config file (-config or CONFIG, config.toml
+// by default and then optional), env vars and the flags in args.
+func LoadConfig(fs *flag.FlagSet, args []string) (Config, error) {
+	c := defaultConfig()
+	configFile := fs.String("config", "", "Config file, "+defaultConfigFile+" when present")
+	c.flags(fs)
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+
+	// Flags are applied last, keep them aside
+	given := map[string]string{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = f.Value.String() })
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG")
+	}
+	required := path != ""
+	if !required {
+		path = defaultConfigFile
+	}
+	md, err := toml.DecodeFile(path, &c)
+	if err != nil && (required || !errors.Is(err, os.ErrNotExist)) {
+		return c, fmt.Errorf("config file: %w", err)
+	}
+	if unknown := md.Undecoded(); len(unknown) > 0 {
+		return c, fmt.Errorf("config file: unknown settings %v", unknown)
+	}
+
+	var problems []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err := f.Value.Set(v); err != nil {
+				problems = append(problems, fmt.Errorf("%s: %w", envName(f.Name), err))
+			}
+		}
+		if v, ok := given[f.Name]; ok {
+			f.Value.Set(v)
+		}
+	})
+	if len(problems) > 0 {
+		return c, errors.Join(problems...)
+	}
+	return c, c.validate()
+}
+
+// Check the settings, all problems are reported at once.
+func (c *Config) validate() error {
+	var problems []error
+	fail := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if c.Port != "" {
+		if n, err := strconv.Atoi(c.Port); err != nil || n < 0 || n > 65535 {
+			fail("port must be a number from 0 to 65535, got %q", c.Port)
+		}
+	}
+	if c.SiteURL != "" {
+		c.SiteURL = strings.TrimSuffix(c.SiteURL, "/")
+		u, err := url.Parse(c.SiteURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			fail("site_url must be like https://example.com, got %q", c.SiteURL)
+		}
+	}
+	if c.DBPath == "" {
+		fail("db_path is required")
+	}
+	if c.TLS.Enabled && (c.TLS.Cert == "" || c.TLS.Key == "") {
+		fail("tls.cert and tls.key are required when tls.enabled")
+	}
+	if (c.OAuth.GoogleClientID == "") != (c.OAuth.GoogleClientSecret == "") {
+		fail("oauth.google_client_id and oauth.google_client_secret go together")
+	}
+	if (c.OAuth.GithubClientID == "") != (c.OAuth.GithubClientSecret == "") {
+		fail("oauth.github_client_id and oauth.github_client_secret go together")
+	}
+	switch c.Media.Store {
+	case "local":
+	case "s3":
+		s3 := c.Media.S3
+		if s3.Endpoint == "" || s3.Bucket == "" || s3.AccessKey == "" || s3.SecretKey == "" {
+			fail("media.s3 needs endpoint, bucket, access_key and secret_key")
+		}
+	default:
+		fail("media.store must be local or s3, got %q", c.Media.Store)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		fail("log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		fail("log.format must be text or json, got %q", c.Log.Format)
+	}
+	if n := c.Limits.HomePosts; n < 1 || n > 100 {
+		fail("limits.home_posts must be from 1 to 100, got %d", n)
+	}
+	if n := c.Limits.ProfileItems; n < 1 || n > 100 {
+		fail("limits.profile_items must be from 1 to 100, got %d", n)
+	}
+	if n := c.Limits.Messages; n < 1 || n > 100 {
+		fail("limits.messages must be from 1 to 100, got %d", n)
+	}
+	if n := c.Limits.MaxImageSize; n < 20<<20 || n > maxImagesSize {
+		fail("limits.max_image_size must be from %d (newPost.js) to %d bytes, got %d", 20<<20, maxImagesSize, n)
+	}
+	return errors.Join(problems...)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Config file with the given content, in a temp dir.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Clear the env vars of the settings, restored after the test.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	var c Config
+	fs := flag.NewFlagSet("env", flag.ContinueOnError)
+	c.flags(fs)
+	names := []string{"CONFIG"}
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, envName(f.Name)) })
+	for _, name := range names {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func loadConfig(t *testing.T, args ...string) (Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("forum", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return LoadConfig(fs, args)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfig(t, `
+site_url = "https://file.example.com"
+
+[limits]
+home_posts = 20
+profile_items = 7
+messages = 8
+`)
+	t.Setenv("HOME_LIMIT", "30")
+	t.Setenv("PROFILE_LIMIT", "9")
+	t.Setenv("SITE_URL", "https://env.example.com/")
+
+	c, err := loadConfig(t, "-config", path, "-home-limit", "40")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"default", c.Log.Level, "info"},
+		{"file over default", c.Limits.Messages, 8},
+		{"env over file", c.Limits.ProfileItems, 9},
+		{"env over file, normalized", c.SiteURL, "https://env.example.com"},
+		{"flag over env", c.Limits.HomePosts, 40},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s: got %v, want %v", check.name, check.got, check.want)
+		}
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("CONFIG", writeConfig(t, "[limits]\nhome_posts = 15\n"))
+
+	c, err := loadConfig(t)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if c.Limits.HomePosts != 15 {
+		t.Errorf("home_posts = %d, want 15 from the CONFIG file", c.Limits.HomePosts)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string // Substring of the error
+	}{
+		{name: "unknown key", file: "[limits]\nhome_post = 5\n", want: "unknown settings [limits.home_post]"},
+		{name: "unknown table", file: "[cache]\nsize = 5\n", want: "unknown settings"},
+		{name: "wrong type", file: "port = 8080\n", want: "config file"},
+		{name: "invalid env", env: map[string]string{"HOME_LIMIT": "many"}, want: "HOME_LIMIT"},
+		{name: "invalid flag", args: []string{"-home-limit", "many"}, want: "home-limit"},
+		{name: "unknown flag", args: []string{"-cache-size", "5"}, want: "cache-size"},
+		{name: "out of range", file: "[limits]\nhome_posts = 500\n", want: "limits.home_posts"},
+		{name: "missing file", args: []string{"-config", "missing.toml"}, want: "config file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+			_, err := loadConfig(t, args...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig = %v, want an error with %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout used by SQLite's CURRENT_TIMESTAMP (always UTC).
+const sqlTimeLayout = "2006-01-02 15:04:05"
+
+// Cursor points at the last item of a page ordered by (created_at, id) DESC.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// Paginated response envelope.
+type Page struct {
+	Items      any    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Encode the cursor into an opaque, url-safe token.
+func (c Cursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(sqlTimeLayout) + "|" + strconv.Itoa(c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// Decode a token received from the client, an empty token means first page.
+func DecodeCursor(token string) (*Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	t, err := time.Parse(sqlTimeLayout, parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor time")
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor id")
+	}
+	return &Cursor{CreatedAt: t, ID: id}, nil
+}
+
+// Opaque cursor of the page at offset, for orders without a stable keyset
+// (ranked posts).
+func OffsetCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o|" + strconv.Itoa(offset)))
+}
+
+// Offset of a token made by OffsetCursor, false for other tokens.
+func decodeOffsetCursor(token string) (int, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, false
+	}
+	n, ok := strings.CutPrefix(string(raw), "o|")
+	if !ok {
+		return 0, false
+	}
+	offset, err := strconv.Atoi(n)
+	if err != nil || offset < 0 {
+		return 0, false
+	}
+	return offset, true
+}
+
+// SQL condition selecting rows after the cursor in (created_at, id) DESC order.
+// A nil cursor matches everything.
+func (c *Cursor) Where(timeCol, idCol string) (string, []any) {
+	if c == nil {
+		return "1 = 1", nil
+	}
+	t := c.CreatedAt.UTC().Format(sqlTimeLayout)
+	clause := fmt.Sprintf("(%s < ? OR (%s = ? AND %s < ?))", timeCol, timeCol, idCol)
+	return clause, []any{t, t, c.ID}
+}
+
+// Read the "cursor" and "offset" query params of a paginated endpoint.
+// legacy is true when only an offset is given outside /api/v1, old clients expect a bare array.
+func ParsePaging(r *http.Request) (cursor *Cursor, offset int, legacy bool, err error) {
+	token := r.URL.Query().Get("cursor")
+	if offset, ok := decodeOffsetCursor(token); ok {
+		return nil, offset, false, nil
+	}
+	cursor, err = DecodeCursor(token)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	offsetParam := r.URL.Query().Get("offset")
+	if offsetParam == "" || cursor != nil {
+		return cursor, 0, false, nil
+	}
+	offset, err = strconv.Atoi(offsetParam)
+	if err != nil || offset < 0 {
+		return nil, 0, false, fmt.Errorf("invalid offset")
+	}
+	// v1 clients always get pages, even with an offset
+	return nil, offset, !IsAPIv1(r), nil
+}
+
+// Keep the first limit items (one extra row is fetched to detect a next page)
+// and return the cursor of the last kept item when there is more to load.
+func paginate[T any](items []T, limit int, key func(T) Cursor) ([]T, string) {
+	if len(items) <= limit {
+		return items, ""
+	}
+	items = items[:limit]
+	return items, key(items[len(items)-1]).Encode()
+}
+
+// Like paginate for pages by offset, the next page has an offset cursor.
+func paginateOffset[T any](items []T, limit, offset int) ([]T, string) {
+	if len(items) <= limit {
+		return items, ""
+	}
+	return items[:limit], OffsetCursor(offset + limit)
+}
+
+// Write a page as an envelope, or as a bare array for legacy offset clients.
+func WritePage[T any](w http.ResponseWriter, items []T, next string, legacy bool) {
+	if items == nil {
+		items = []T{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if legacy {
+		json.NewEncoder(w).Encode(items)
+		return
+	}
+	json.NewEncoder(w).Encode(Page{Items: items, NextCursor: next})
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParsePagingCursors(t *testing.T) {
+	keyset := Cursor{CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), ID: 7}
+
+	tests := []struct {
+		query      string
+		wantCursor *Cursor
+		wantOffset int
+		wantLegacy bool
+		wantErr    bool
+	}{
+		{"", nil, 0, false, false},
+		{"offset=20", nil, 20, true, false},
+		{"cursor=" + keyset.Encode(), &keyset, 0, false, false},
+		{"cursor=" + OffsetCursor(30), nil, 30, false, false},
+		// An offset cursor wins over an offset
+		{"cursor=" + OffsetCursor(30) + "&offset=10", nil, 30, false, false},
+		{"cursor=bm9wZQ", nil, 0, false, true},
+		{"offset=-1", nil, 0, false, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/get-posts?"+tt.query, nil)
+		cursor, offset, legacy, err := ParsePaging(r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePaging(%q) err = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if (cursor == nil) != (tt.wantCursor == nil) || (cursor != nil && *cursor != *tt.wantCursor) {
+			t.Errorf("ParsePaging(%q) cursor = %v, want %v", tt.query, cursor, tt.wantCursor)
+		}
+		if offset != tt.wantOffset || legacy != tt.wantLegacy {
+			t.Errorf("ParsePaging(%q) = offset %d, legacy %v, want %d, %v", tt.query, offset, legacy, tt.wantOffset, tt.wantLegacy)
+		}
+	}
+}
+
+func TestPaginateOffset(t *testing.T) {
+	items := []int{1, 2, 3, 4}
+
+	page, next := paginateOffset(items, 3, 6)
+	if len(page) != 3 || next != OffsetCursor(9) {
+		t.Errorf("paginateOffset with more rows = %v, %q, want 3 items and the cursor of offset 9", page, next)
+	}
+	if offset, ok := decodeOffsetCursor(next); !ok || offset != 9 {
+		t.Errorf("decodeOffsetCursor(%q) = %d, %v, want 9", next, offset, ok)
+	}
+	if page, next := paginateOffset(items, 4, 0); len(page) != 4 || next != "" {
+		t.Errorf("paginateOffset of the last page = %v, %q, want all items and no cursor", page, next)
+	}
+}
@@ -0,0 +1,589 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	maxDrafts        = 50               // Per user, drafts keep uploaded images
+	draftsCheckEvery = 30 * time.Second // Scheduled drafts publishing interval
+)
+
+// Unfinished or scheduled post, only visible to its author.
+type Draft struct {
+	ID          int         `json:"id"`
+	UserID      int         `json:"-"`
+	Title       string      `json:"title"`   // Raw, escaped when published
+	Content     string      `json:"content"` // Markdown source
+	ContentHTML string      `json:"content_html"`
+	Categories  []string    `json:"categories"`
+	Media       []PostMedia `json:"media"` // Alt texts raw, escaped when published
+	Poll        *PollInput  `json:"poll,omitempty"`
+	PublishAt   *time.Time  `json:"publish_at"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// Sent over the notifications socket when a scheduled draft is processed.
+type DraftEvent struct {
+	Action  string `json:"action"` // "draft_published" or "draft_failed"
+	DraftID int    `json:"draft_id"`
+	PostID  int64  `json:"post_id,omitempty"`
+	Msg     string `json:"msg,omitempty"`
+}
+
+// Fresh post form holding the draft values.
+func (d *Draft) Form() *PostForm {
+	f := &PostForm{
+		Title:      d.Title,
+		Content:    d.Content,
+		Categories: slices.Clone(d.Categories),
+		Media:      slices.Clone(d.Media),
+		Alts:       make([]string, len(d.Media)),
+	}
+	for i, m := range d.Media {
+		f.Alts[i] = m.Alt
+	}
+	if d.Poll != nil {
+		poll := *d.Poll
+		poll.Options = slices.Clone(d.Poll.Options)
+		f.Poll = &poll
+	}
+	return f
+}
+
+// Create (no id param) or autosave a draft, same form as a new post.
+func SaveDraft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Login to save a draft", http.StatusUnauthorized, err)
+		return
+	}
+
+	form, quit := ReadPostForm(w, r)
+	if quit {
+		return
+	}
+
+	// Existing draft when autosaving
+	draft := &Draft{UserID: user.ID}
+	if idParam := r.URL.Query().Get("id"); idParam != "" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			JsonError(w, "Invalid draft id", http.StatusBadRequest, err)
+			return
+		}
+		draft, err = LoadDraft(id, user.ID)
+		if err == sql.ErrNoRows {
+			JsonError(w, "Draft not found", http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			JsonError(w, "Failed to load draft", http.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		var count int
+		if err := DB.QueryRow(`SELECT COUNT(*) FROM post_drafts WHERE user_id = ?`, user.ID).Scan(&count); err != nil {
+			JsonError(w, "Failed to save draft", http.StatusInternalServerError, err)
+			return
+		}
+		if count >= maxDrafts {
+			JsonError(w, fmt.Sprintf("You can keep up to %d drafts", maxDrafts), http.StatusBadRequest, nil)
+			return
+		}
+	}
+
+	// Autosaves only send the fields that changed, the others are kept.
+	if form.Sent("title") {
+		draft.Title = form.Title
+	}
+	if form.Sent("content") {
+		draft.Content = form.Content
+	}
+	if form.Sent("categories") {
+		draft.Categories = form.Categories
+	}
+	switch {
+	case form.RemovePoll:
+		draft.Poll = nil
+	case form.Poll != nil:
+		draft.Poll = form.Poll
+	}
+	switch {
+	case form.Unschedule:
+		draft.PublishAt = nil
+	case form.PublishAt != nil:
+		draft.PublishAt = form.PublishAt
+	}
+
+	// Replace, remove or relabel the pending images
+	oldMedia := draft.Media
+	switch {
+	case len(form.Images) > 0:
+		if len(form.Alts) > len(form.Images) {
+			JsonError(w, "More alt texts than images", http.StatusBadRequest, nil)
+			return
+		}
+		draft.Media = nil
+		for i, image := range form.Images {
+			name, err := SaveImg(image)
+			if errors.Is(err, ErrImage) {
+				JsonError(w, fmt.Sprintf("Image %d: %v", i+1, err), http.StatusBadRequest, err)
+				return
+			}
+			if err != nil {
+				JsonError(w, "Failed to save draft", http.StatusInternalServerError, err)
+				return
+			}
+			// Alt texts can be added by later autosaves
+			m := PostMedia{Name: name, Position: i}
+			if i < len(form.Alts) {
+				m.Alt = form.Alts[i]
+			}
+			draft.Media = append(draft.Media, m)
+		}
+	case form.RemoveImage:
+		draft.Media = nil
+	case form.Alts != nil:
+		if len(form.Alts) != len(draft.Media) {
+			JsonError(w, "Expected one alt text per image", http.StatusBadRequest, nil)
+			return
+		}
+		for i := range draft.Media {
+			draft.Media[i].Alt = form.Alts[i]
+		}
+	}
+
+	// Scheduled drafts must be publishable as they are.
+	if draft.PublishAt != nil {
+		if !draft.PublishAt.After(time.Now()) {
+			JsonError(w, "Publish time must be in the future", http.StatusBadRequest, nil)
+			return
+		}
+		if p := draft.Poll; p != nil && p.ClosesAt != nil && !p.ClosesAt.After(*draft.PublishAt) {
+			JsonError(w, "Poll must close after the publish time", http.StatusBadRequest, nil)
+			return
+		}
+		if err := draft.Form().Validate(); err != nil {
+			JsonError(w, err.Error(), http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	status := http.StatusOK
+	if draft.ID == 0 {
+		status = http.StatusCreated
+	}
+	if err := StoreDraft(draft); err != nil {
+		if err == sql.ErrNoRows {
+			JsonError(w, "Draft not found", http.StatusNotFound, err)
+			return
+		}
+		JsonError(w, "Failed to save draft", http.StatusInternalServerError, err)
+		return
+	}
+	for _, m := range oldMedia {
+		if !slices.ContainsFunc(draft.Media, func(n PostMedia) bool { return n.Name == m.Name }) {
+			releaseUpload(m.Name)
+		}
+	}
+
+	draft, err = LoadDraft(draft.ID, user.ID)
+	if err != nil {
+		JsonError(w, "Failed to load draft", http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(draft)
+}
+
+// Insert or update a draft, sets the ID of new drafts.
+// Returns sql.ErrNoRows when the updated draft no longer exists.
+func StoreDraft(d *Draft) error {
+	categories, err := json.Marshal(d.Categories)
+	if err != nil {
+		return err
+	}
+	if d.Categories == nil {
+		categories = []byte("[]")
+	}
+	// Derived sizes aren't stored
+	stored := make([]draftMedia, len(d.Media))
+	for i, m := range d.Media {
+		stored[i] = draftMedia{Name: m.Name, Alt: m.Alt}
+	}
+	media, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	var poll, publishAt any
+	if d.Poll != nil {
+		b, err := json.Marshal(d.Poll)
+		if err != nil {
+			return err
+		}
+		poll = string(b)
+	}
+	if d.PublishAt != nil {
+		publishAt = d.PublishAt.UTC().Format(sqlTimeLayout)
+	}
+
+	if d.ID == 0 {
+		res, err := DB.Exec(`
+            INSERT INTO post_drafts (user_id, title, content, categories, media, poll, publish_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			d.UserID, d.Title, d.Content, string(categories), string(media), poll, publishAt,
+		)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		d.ID = int(id)
+		return err
+	}
+
+	res, err := DB.Exec(`
+        UPDATE post_drafts
+        SET title = ?, content = ?, categories = ?, media = ?, poll = ?, publish_at = ?,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = ? AND user_id = ?`,
+		d.Title, d.Content, string(categories), string(media), poll, publishAt, d.ID, d.UserID,
+	)
+	if err != nil {
+		return err
+	}
+	// Published or deleted meanwhile
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const draftColumns = `id, user_id, title, content, categories, media, poll, publish_at, created_at, updated_at`
+
+// Image of a draft as stored in post_drafts.media.
+type draftMedia struct {
+	Name string `json:"name"`
+	Alt  string `json:"alt"`
+}
+
+// Scan a post_drafts row selected with draftColumns.
+func scanDraft(row interface{ Scan(...any) error }) (*Draft, error) {
+	var d Draft
+	var categories, media string
+	var poll sql.NullString
+	var publishAt sql.NullTime
+	err := row.Scan(&d.ID, &d.UserID, &d.Title, &d.Content, &categories, &media, &poll, &publishAt, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(categories), &d.Categories); err != nil {
+		return nil, err
+	}
+	var stored []draftMedia
+	if err := json.Unmarshal([]byte(media), &stored); err != nil {
+		return nil, err
+	}
+	d.Media = make([]PostMedia, len(stored))
+	for i, m := range stored {
+		d.Media[i] = PostMedia{
+			Name:     m.Name,
+			Thumb:    ImageVariant(m.Name, "thumb"),
+			Medium:   ImageVariant(m.Name, "medium"),
+			Alt:      m.Alt,
+			Position: i,
+		}
+	}
+	if poll.Valid {
+		d.Poll = &PollInput{}
+		if err := json.Unmarshal([]byte(poll.String), d.Poll); err != nil {
+			return nil, err
+		}
+	}
+	if publishAt.Valid {
+		d.PublishAt = &publishAt.Time
+	}
+	d.ContentHTML = RenderMarkdown(d.Content)
+	return &d, nil
+}
+
+// Load a draft owned by userID, sql.ErrNoRows if there is none.
+func LoadDraft(id, userID int) (*Draft, error) {
+	row := DB.QueryRow(`SELECT `+draftColumns+` FROM post_drafts WHERE id = ? AND user_id = ?`, id, userID)
+	return scanDraft(row)
+}
+
+// List the user's drafts, "scheduled" param filters scheduled (true) or unscheduled (false) ones.
+func GetDrafts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	filter := "1 = 1"
+	order := "updated_at DESC, id DESC"
+	switch r.URL.Query().Get("scheduled") {
+	case "true":
+		filter = "publish_at IS NOT NULL"
+		order = "publish_at, id"
+	case "false":
+		filter = "publish_at IS NULL"
+	case "":
+	default:
+		JsonError(w, "Invalid scheduled filter", http.StatusBadRequest, nil)
+		return
+	}
+
+	rows, err := DB.Query(`
+        SELECT `+draftColumns+`
+        FROM post_drafts
+        WHERE user_id = ? AND `+filter+`
+        ORDER BY `+order,
+		user.ID,
+	)
+	if err != nil {
+		JsonError(w, "Failed to fetch drafts", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	drafts := []*Draft{}
+	for rows.Next() {
+		d, err := scanDraft(rows)
+		if err != nil {
+			JsonError(w, "Failed to fetch drafts", http.StatusInternalServerError, err)
+			return
+		}
+		drafts = append(drafts, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drafts)
+}
+
+// Serve a single draft for editing.
+func GetDraft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		JsonError(w, "Invalid draft id", http.StatusBadRequest, err)
+		return
+	}
+	draft, err := LoadDraft(id, user.ID)
+	if err == sql.ErrNoRows {
+		JsonError(w, "Draft not found", http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		JsonError(w, "Failed to load draft", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(draft)
+}
+
+// Delete a draft and its pending images.
+func DeleteDraft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		JsonError(w, "Invalid draft id", http.StatusBadRequest, err)
+		return
+	}
+
+	var media string
+	err = DB.QueryRow(`
+        DELETE FROM post_drafts
+        WHERE id = ? AND user_id = ?
+        RETURNING media`,
+		id, user.ID,
+	).Scan(&media)
+	if err == sql.ErrNoRows {
+		JsonError(w, "Draft not found", http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		JsonError(w, "Failed to delete draft", http.StatusInternalServerError, err)
+		return
+	}
+	var stored []draftMedia
+	if err := json.Unmarshal([]byte(media), &stored); err != nil {
+		Logger(r).Error("Failed to release draft images", "error", err)
+	}
+	for _, m := range stored {
+		releaseUpload(m.Name)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Publish a draft right away.
+func PublishDraftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Login to add a post", http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		JsonError(w, "Invalid draft id", http.StatusBadRequest, err)
+		return
+	}
+
+	postID, userErr, err := PublishDraft(id, user.ID)
+	if err == sql.ErrNoRows {
+		JsonError(w, "Draft not found", http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		JsonError(w, "Failed to publish draft", http.StatusInternalServerError, err)
+		return
+	}
+	if userErr != nil {
+		JsonError(w, userErr.Error(), http.StatusBadRequest, userErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int64{"post_id": postID})
+}
+
+// Promote a draft into posts and delete it, in one transaction.
+// userErr is set when the draft is not valid for publishing.
+func PublishDraft(id, userID int) (postID int64, userErr error, err error) {
+	draft, err := LoadDraft(id, userID)
+	if err != nil {
+		return 0, nil, err
+	}
+	form := draft.Form()
+	if err := form.Validate(); err != nil {
+		return 0, err, nil
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	// Claim the draft, another request or the scheduler may be publishing it.
+	res, err := tx.Exec(`DELETE FROM post_drafts WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return 0, nil, sql.ErrNoRows
+	}
+
+	postID, err = insertPost(tx, userID, form)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	Publish(PostCreated{PostID: postID, AuthorID: userID, Content: form.Content})
+	return postID, nil, nil
+}
+
+// Publish every draft whose publish time has come, and tell their authors.
+// Drafts that can't be published anymore are unscheduled.
+func PublishScheduledDrafts(ctx context.Context) error {
+	now := time.Now().UTC().Format(sqlTimeLayout)
+	rows, err := DB.QueryContext(ctx, `
+        SELECT id, user_id
+        FROM post_drafts
+        WHERE publish_at IS NOT NULL AND publish_at <= ?
+        ORDER BY publish_at`,
+		now,
+	)
+	if err != nil {
+		return err
+	}
+	type due struct{ id, userID int }
+	var drafts []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.userID); err != nil {
+			rows.Close()
+			return err
+		}
+		drafts = append(drafts, d)
+	}
+	rows.Close()
+
+	for _, d := range drafts {
+		// The rest are published on the next run
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		postID, userErr, err := PublishDraft(d.id, d.userID)
+		if err == sql.ErrNoRows {
+			continue // Published or deleted meanwhile
+		} else if err != nil {
+			return err
+		}
+
+		if userErr != nil {
+			if _, err := DB.ExecContext(ctx, `UPDATE post_drafts SET publish_at = NULL WHERE id = ?`, d.id); err != nil {
+				return err
+			}
+			NotifyUserWithData(d.userID, DraftEvent{
+				Action:  "draft_failed",
+				DraftID: d.id,
+				Msg:     userErr.Error(),
+			})
+			continue
+		}
+		NotifyUserWithData(d.userID, DraftEvent{
+			Action:  "draft_published",
+			DraftID: d.id,
+			PostID:  postID,
+		})
+	}
+	return nil
+}
@@ -1,10 +1,11 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -18,10 +19,7 @@ type ErrorData struct {
 
 // Fetch external error page depending on error type.
 func ErrorHandler(w http.ResponseWriter, statusCode int, msg1, msg2 string, err error) {
-	// Log the error in case of internal server error.
-	if err != nil && statusCode == 500 {
-		log.Println(err)
-	}
+	logError(w, msg1, statusCode, err)
 
 	Error := ErrorData{
 		Msg1:       msg1,
@@ -35,14 +33,10 @@ func ErrorHandler(w http.ResponseWriter, statusCode int, msg1, msg2 string, err
 
 // Serve the error page from the json file error link.
 func ServeCloudError(w http.ResponseWriter, error ErrorData, err error) {
-	if err != nil {
-		log.Println(err)
-	}
-
 	errBody, err := GetErrorPage()
 	if err != nil {
 		http.Error(w, http.StatusText(error.StatusCode), error.StatusCode)
-		log.Println(err)
+		writerLogger(w).Warn("Failed to serve the error page", "error", err)
 		return
 	}
 
@@ -82,10 +76,7 @@ func GetErrorPage() (string, error) {
 
 // Return error response to JS fetches.
 func JsonError(w http.ResponseWriter, msg string, statusCode int, err error) {
-	// Log the error in case of internal server error.
-	if err != nil && statusCode == 500 {
-		log.Println(err)
-	}
+	logError(w, msg, statusCode, err)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 
@@ -96,3 +87,20 @@ func JsonError(w http.ResponseWriter, msg string, statusCode int, err error) {
 	}
 	json.NewEncoder(w).Encode(resp)
 }
+
+// Log the error of a reply with the request's context: server errors
+// always, even without an error, client errors with one at debug level.
+func logError(w http.ResponseWriter, msg string, statusCode int, err error) {
+	level := slog.LevelError
+	if statusCode < 500 {
+		if err == nil {
+			return
+		}
+		level = slog.LevelDebug
+	}
+	attrs := []any{"status", statusCode}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	writerLogger(w).Log(context.Background(), level, msg, attrs...)
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogError(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	tests := []struct {
+		name      string
+		status    int
+		err       error
+		wantLevel string // "" when not logged
+	}{
+		{"server error", http.StatusInternalServerError, errors.New("db down"), "ERROR"},
+		{"server error without error", http.StatusServiceUnavailable, nil, "ERROR"},
+		{"client error", http.StatusBadRequest, errors.New("bad json"), "DEBUG"},
+		{"client error without error", http.StatusNotFound, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			logError(httptest.NewRecorder(), "reply", tt.status, tt.err)
+
+			if tt.wantLevel == "" {
+				if buf.Len() > 0 {
+					t.Errorf("logged %s, want nothing", buf.String())
+				}
+				return
+			}
+			var line map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("log line %q: %v", buf.String(), err)
+			}
+			if line["level"] != tt.wantLevel || line["status"] != float64(tt.status) {
+				t.Errorf("logged %v, want level %s and status %d", line, tt.wantLevel, tt.status)
+			}
+			if _, ok := line["error"]; ok != (tt.err != nil) {
+				t.Errorf("logged %v, want an error attribute only with an error", line)
+			}
+		})
+	}
+}
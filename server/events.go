@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Domain events, published once their write succeeded. Side effects
+// (notifications, webhooks, link previews...) subscribe to them instead
+// of being called from the handlers.
+
+// A post was published, directly or from a draft.
+type PostCreated struct {
+	PostID   int64
+	AuthorID int
+	Content  string
+}
+
+// A comment was added to a post.
+type CommentCreated struct {
+	Comment     Comment
+	PostID      int
+	PostOwnerID int
+	Author      User
+}
+
+// A reaction was added to a post or a comment, or changed.
+type ReactionAdded struct {
+	Target   string // post or comment
+	TargetID int
+	OwnerID  int // Author of the post or comment
+	Reaction string
+	User     User
+}
+
+// A private message was sent.
+type MessageSent struct {
+	MessageID  int64
+	SenderID   int
+	ReceiverID int
+	Content    string // Escaped
+	Previews   []LinkPreview
+	CreatedAt  time.Time
+}
+
+// An account was created, by signup or a social login.
+type UserRegistered struct {
+	UserID   int
+	Username string
+}
+
+type subscriber struct {
+	async bool
+	fn    func(any)
+}
+
+var (
+	subscribersMu sync.RWMutex
+	subscribers   = map[reflect.Type][]subscriber{}
+)
+
+// Run fn for each event of type T, before Publish returns and in the
+// order of subscription. For side effects that must be done with the write.
+func Subscribe[T any](fn func(T)) {
+	subscribe(false, fn)
+}
+
+// Run fn in its own goroutine for each event of type T, waited for on shutdown.
+func SubscribeAsync[T any](fn func(T)) {
+	subscribe(true, fn)
+}
+
+func subscribe[T any](async bool, fn func(T)) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	t := reflect.TypeFor[T]()
+	subscribers[t] = append(subscribers[t], subscriber{
+		async: async,
+		fn:    func(event any) { fn(event.(T)) },
+	})
+}
+
+// Send an event to its subscribers. A failing subscriber is logged and
+// doesn't stop the others, nor the handler that published.
+func Publish(event any) {
+	subscribersMu.RLock()
+	subs := subscribers[reflect.TypeOf(event)]
+	subscribersMu.RUnlock()
+
+	for _, sub := range subs {
+		if sub.async {
+			goBackground(func() { runSubscriber(sub, event) })
+		} else {
+			runSubscriber(sub, event)
+		}
+	}
+}
+
+func runSubscriber(sub subscriber, event any) {
+	defer func() {
+		if err := recover(); err != nil {
+			slog.Error("Subscriber panicked", "event", fmt.Sprintf("%T", event), "panic", err, "stack", string(debug.Stack()))
+		}
+	}()
+	sub.fn(event)
+}
+
+// Register the subscribers of the forum's side effects, before serving.
+func initialiseEvents() {
+	subscribeNotifications()
+	subscribeLinkPreviews()
+	subscribeMessages()
+	subscribeWebhooks()
+}
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Feed readers poll often, let shared caches answer for a while.
+const feedMaxAge = 5 * time.Minute
+
+// Title of the site in feeds and shared pages.
+const siteName = "dwi"
+
+// Posts of a feed, newest first.
+type feed struct {
+	Title   string
+	Link    string // Page of the feed on the site
+	SelfURL string
+	Posts   []Post
+	Updated time.Time
+}
+
+// Serve Atom, RSS 2.0 and JSON Feed versions of public post lists:
+//
+//	/feeds/all.atom
+//	/feeds/category/<name>[,<name>...].rss (posts having all categories)
+//	/feeds/user/<username>.json
+//
+// Feeds are built as seen by a guest, so blocks and mutes don't apply.
+func FeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/feeds/")
+	format := path.Ext(rest)
+	rest = strings.TrimSuffix(rest, format)
+	kind, name, _ := strings.Cut(rest, "/")
+
+	site := siteURL(r)
+	f := feed{SelfURL: site + r.URL.Path, Link: site + "/"}
+	scope := VisiblePosts(0)
+	newest := Ranking{Sort: "new"}
+	var err error
+
+	switch {
+	case kind == "all" && name == "":
+		f.Title = siteName
+		f.Posts, err = FetchAllPosts(nil, 0, newest, scope)
+
+	case kind == "category" && name != "":
+		// Same tags as the home filter
+		var tags []string
+		for _, t := range strings.Split(name, ",") {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+				tags = append(tags, t)
+			}
+		}
+		var found int
+		if len(tags) > 0 {
+			args := make([]any, len(tags))
+			for i, t := range tags {
+				args[i] = t
+			}
+			err = DB.QueryRow(`SELECT COUNT(*) FROM categories WHERE LOWER(name) IN (`+Placeholders(len(args))+`)`, args...).Scan(&found)
+			if err != nil {
+				JsonError(w, "Failed to load feed", http.StatusInternalServerError, err)
+				return
+			}
+		}
+		if len(tags) == 0 || found < len(tags) {
+			JsonError(w, "Category not found", http.StatusNotFound, nil)
+			return
+		}
+		f.Title = siteName + ": " + strings.Join(tags, ", ")
+		f.Posts, err = FetchPostsByTags(nil, 0, tags, newest, scope)
+
+	case kind == "user" && name != "":
+		author, lookupErr := GetUserByUsername(name)
+		if lookupErr != nil {
+			JsonError(w, "User not found", http.StatusNotFound, lookupErr)
+			return
+		}
+		f.Title = siteName + ": posts by " + author.Username
+		f.Link = site + "/profile?user=" + url.QueryEscape(author.Username)
+		scope = scope.And(PostScope{Where: "p.user_id = ?", Args: []any{author.ID}})
+		f.Posts, err = FetchAllPosts(nil, 0, newest, scope)
+
+	default:
+		JsonError(w, "Feed not found", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		JsonError(w, "Failed to load feed", http.StatusInternalServerError, err)
+		return
+	}
+
+	f.Posts, _ = paginate(f.Posts, Conf.Limits.HomePosts, postCursor)
+	if err := LoadPostDetails(f.Posts, 0); err != nil {
+		JsonError(w, "Failed to load feed", http.StatusInternalServerError, err)
+		return
+	}
+	for _, p := range f.Posts {
+		if p.CreatedAt.After(f.Updated) {
+			f.Updated = p.CreatedAt
+		}
+	}
+
+	var body []byte
+	var contentType string
+	switch format {
+	case ".atom":
+		contentType = "application/atom+xml; charset=utf-8"
+		body, err = f.atom(site)
+	case ".rss":
+		contentType = "application/rss+xml; charset=utf-8"
+		body, err = f.rss(site)
+	case ".json":
+		contentType = "application/feed+json; charset=utf-8"
+		body, err = f.jsonFeed(site)
+	default:
+		JsonError(w, "Unknown feed format, use .atom, .rss or .json", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		JsonError(w, "Failed to encode feed", http.StatusInternalServerError, err)
+		return
+	}
+
+	// Conditional GET (If-None-Match, If-Modified-Since) is handled by ServeContent
+	sum := sha256.Sum256(body)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(feedMaxAge.Seconds())))
+	http.ServeContent(w, r, "", f.Updated, bytes.NewReader(body))
+}
+
+// Public address of the site, site_url or the requested host.
+func siteURL(r *http.Request) string {
+	if Conf.SiteURL != "" {
+		return Conf.SiteURL
+	}
+	// A proxy ending TLS isn't seen, site_url is needed behind one
+	if r.TLS != nil || Conf.TLS.Enabled {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+// Page of a post on the site.
+func postURL(site string, id int) string {
+	return site + "/post/" + strconv.Itoa(id)
+}
+
+// Absolute address of an upload.
+func uploadURL(site, name string) string {
+	u := Media.URL(name)
+	if strings.HasPrefix(u, "/") {
+		return site + u
+	}
+	return u
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomAuthor     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func (f feed) atom(site string) ([]byte, error) {
+	updated := f.Updated
+	if updated.IsZero() {
+		updated = time.Unix(0, 0)
+	}
+	out := atomFeed{
+		Title:   f.Title,
+		ID:      f.SelfURL,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: f.SelfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: f.Link, Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for _, p := range f.Posts {
+		link := postURL(site, p.ID)
+		e := atomEntry{
+			Title:     html.UnescapeString(p.Title),
+			ID:        link,
+			Link:      atomLink{Href: link, Rel: "alternate", Type: "text/html"},
+			Published: p.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   p.CreatedAt.UTC().Format(time.RFC3339),
+			Author:    atomAuthor{Name: p.Username, URI: site + "/profile?user=" + url.QueryEscape(p.Username)},
+			Content:   atomContent{Type: "html", Body: feedContent(site, p)},
+		}
+		for _, c := range p.Categories {
+			e.Categories = append(e.Categories, atomCategory{Term: c.Name})
+		}
+		out.Entries = append(out.Entries, e)
+	}
+	return encodeXML(out)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	AtomLink      atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creator     string   `xml:"dc:creator"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (f feed) rss(site string) ([]byte, error) {
+	out := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DCNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.Link,
+			Description: f.Title,
+			AtomLink:    atomLink{Href: f.SelfURL, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if !f.Updated.IsZero() {
+		out.Channel.LastBuildDate = f.Updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, p := range f.Posts {
+		link := postURL(site, p.ID)
+		item := rssItem{
+			Title:       html.UnescapeString(p.Title),
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			PubDate:     p.CreatedAt.UTC().Format(time.RFC1123Z),
+			Creator:     p.Username,
+			Description: feedContent(site, p),
+		}
+		for _, c := range p.Categories {
+			item.Categories = append(item.Categories, c.Name)
+		}
+		out.Channel.Items = append(out.Channel.Items, item)
+	}
+	return encodeXML(out)
+}
+
+// JSON Feed 1.1, see https://jsonfeed.org/version/1.1
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	Image         string           `json:"image,omitempty"`
+	DatePublished string           `json:"date_published"`
+	Authors       []jsonFeedAuthor `json:"authors"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+func (f feed) jsonFeed(site string) ([]byte, error) {
+	out := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.Link,
+		FeedURL:     f.SelfURL,
+		Items:       []jsonFeedItem{},
+	}
+	for _, p := range f.Posts {
+		link := postURL(site, p.ID)
+		item := jsonFeedItem{
+			ID:            link,
+			URL:           link,
+			Title:         html.UnescapeString(p.Title),
+			ContentHTML:   feedContent(site, p),
+			DatePublished: p.CreatedAt.UTC().Format(time.RFC3339),
+			Authors:       []jsonFeedAuthor{{Name: p.Username, URL: site + "/profile?user=" + url.QueryEscape(p.Username)}},
+		}
+		if p.Image != "" {
+			item.Image = uploadURL(site, p.ImageMedium)
+		}
+		for _, c := range p.Categories {
+			item.Tags = append(item.Tags, c.Name)
+		}
+		out.Items = append(out.Items, item)
+	}
+	return json.Marshal(out)
+}
+
+// Rendered post followed by its gallery, with absolute image addresses.
+func feedContent(site string, p Post) string {
+	var b strings.Builder
+	b.WriteString(p.ContentHTML)
+	for _, m := range p.Media {
+		fmt.Fprintf(&b, `<p><img src="%s" alt="%s"></p>`, html.EscapeString(uploadURL(site, m.Medium)), m.Alt)
+	}
+	return b.String()
+}
+
+func encodeXML(v any) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
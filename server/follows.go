@@ -0,0 +1,369 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Num of users on each scroll load of followers/following lists.
+const followsLimit = 20
+
+// Restricts a posts query, "p" is the posts alias.
+type PostScope struct {
+	Where string
+	Args  []any
+}
+
+// Both scopes.
+func (s PostScope) And(o PostScope) PostScope {
+	return PostScope{
+		Where: "(" + s.Where + ") AND (" + o.Where + ")",
+		Args:  append(append([]any{}, s.Args...), o.Args...),
+	}
+}
+
+// Posts of followed users and of followed categories.
+func FollowingScope(userID int) PostScope {
+	return PostScope{
+		Where: `(p.user_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)
+            OR p.id IN (
+                SELECT pc.post_id
+                FROM post_categories pc
+                JOIN category_follows cf ON cf.category_id = pc.category_id
+                WHERE cf.user_id = ?))`,
+		Args: []any{userID, userID},
+	}
+}
+
+// User in followers/following lists.
+type FollowEntry struct {
+	Username   string    `json:"username"`
+	ProfilePic string    `json:"profile_pic"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// Follow a user.
+func FollowUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Login to follow users", http.StatusUnauthorized, err)
+		return
+	}
+
+	var payload struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		JsonError(w, "Invalid payload", http.StatusBadRequest, err)
+		return
+	}
+
+	followee, err := GetUserByUsername(payload.Username)
+	if err != nil {
+		JsonError(w, "User not found", http.StatusNotFound, err)
+		return
+	}
+	if followee.ID == user.ID {
+		JsonError(w, "You can't follow yourself", http.StatusBadRequest, nil)
+		return
+	}
+
+	blocked, err := IsBlocked(user.ID, followee.ID)
+	if err != nil {
+		JsonError(w, "Failed to follow user", http.StatusInternalServerError, err)
+		return
+	}
+	if blocked {
+		JsonError(w, "You can't follow this user", http.StatusForbidden, ErrBlocked)
+		return
+	}
+
+	_, err = DB.Exec(`INSERT OR IGNORE INTO follows (follower_id, followee_id) VALUES (?, ?)`, user.ID, followee.ID)
+	if err != nil {
+		JsonError(w, "Failed to follow user", http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Unfollow a user.
+func UnfollowUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	followee, err := GetUserByUsername(r.URL.Query().Get("username"))
+	if err != nil {
+		JsonError(w, "User not found", http.StatusNotFound, err)
+		return
+	}
+
+	_, err = DB.Exec(`DELETE FROM follows WHERE follower_id = ? AND followee_id = ?`, user.ID, followee.ID)
+	if err != nil {
+		JsonError(w, "Failed to unfollow user", http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// List the followers of a user.
+func GetFollowers(w http.ResponseWriter, r *http.Request) {
+	listFollows(w, r, `
+        SELECT u.username, u.profile_pic, f.created_at
+        FROM follows f
+        JOIN users u ON u.id = f.follower_id
+        WHERE f.followee_id = ? AND %s
+        ORDER BY f.created_at DESC, u.id DESC
+        LIMIT ? OFFSET ?`)
+}
+
+// List the users followed by a user.
+func GetFollowing(w http.ResponseWriter, r *http.Request) {
+	listFollows(w, r, `
+        SELECT u.username, u.profile_pic, f.created_at
+        FROM follows f
+        JOIN users u ON u.id = f.followee_id
+        WHERE f.follower_id = ? AND %s
+        ORDER BY f.created_at DESC, u.id DESC
+        LIMIT ? OFFSET ?`)
+}
+
+// Serve a page of users selected by query (user ID, visibility, limit, offset).
+// The %s placeholder of the query receives the visibility condition on u.id.
+func listFollows(w http.ResponseWriter, r *http.Request, query string) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	viewer, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	target, err := GetUserByUsername(r.URL.Query().Get("username"))
+	if err != nil {
+		JsonError(w, "User not found", http.StatusNotFound, err)
+		return
+	}
+	hidden, err := IsHidden(viewer.ID, target.ID)
+	if err != nil {
+		JsonError(w, "Failed to fetch users", http.StatusInternalServerError, err)
+		return
+	}
+	if hidden {
+		JsonError(w, "User not found", http.StatusNotFound, nil)
+		return
+	}
+
+	offsetParam := r.URL.Query().Get("offset")
+	if offsetParam == "" {
+		offsetParam = "0"
+	}
+	offset, err := strconv.Atoi(offsetParam)
+	if err != nil {
+		JsonError(w, "Bad request", http.StatusBadRequest, err)
+		return
+	}
+
+	visible, args := VisibleTo(viewer.ID, "u.id")
+	args = append([]any{target.ID}, args...)
+	args = append(args, followsLimit, offset)
+
+	rows, err := DB.Query(fmt.Sprintf(query, visible), args...)
+	if err != nil {
+		JsonError(w, "Failed to fetch users", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	users := []FollowEntry{}
+	for rows.Next() {
+		var u FollowEntry
+		if err := rows.Scan(&u.Username, &u.ProfilePic, &u.FollowedAt); err != nil {
+			JsonError(w, "Failed reading users", http.StatusInternalServerError, err)
+			return
+		}
+		users = append(users, u)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// Follow a category.
+func FollowCategory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Login to follow categories", http.StatusUnauthorized, err)
+		return
+	}
+
+	var payload struct {
+		Category string `json:"category"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		JsonError(w, "Invalid payload", http.StatusBadRequest, err)
+		return
+	}
+
+	ids, err := CategoryIDs([]string{payload.Category})
+	if err != nil {
+		JsonError(w, err.Error(), http.StatusNotFound, err)
+		return
+	}
+
+	_, err = DB.Exec(`INSERT OR IGNORE INTO category_follows (user_id, category_id) VALUES (?, ?)`, user.ID, ids[0])
+	if err != nil {
+		JsonError(w, "Failed to follow category", http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Unfollow a category.
+func UnfollowCategory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	ids, err := CategoryIDs([]string{r.URL.Query().Get("category")})
+	if err != nil {
+		JsonError(w, err.Error(), http.StatusNotFound, err)
+		return
+	}
+
+	_, err = DB.Exec(`DELETE FROM category_follows WHERE user_id = ? AND category_id = ?`, user.ID, ids[0])
+	if err != nil {
+		JsonError(w, "Failed to unfollow category", http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// List the categories followed by the user.
+func GetFollowedCategories(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	rows, err := DB.Query(`
+        SELECT c.id, c.name
+        FROM category_follows cf
+        JOIN categories c ON c.id = cf.category_id
+        WHERE cf.user_id = ?
+        ORDER BY c.name`,
+		user.ID,
+	)
+	if err != nil {
+		JsonError(w, "Failed to fetch categories", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name); err != nil {
+			JsonError(w, "Failed reading categories", http.StatusInternalServerError, err)
+			return
+		}
+		categories = append(categories, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(categories)
+}
+
+// Followers and following counts of a user, and whether the viewer follows them.
+func FollowCounts(userID, viewerID int) (followers, following int, isFollowing bool, err error) {
+	err = DB.QueryRow(`
+        SELECT
+            (SELECT COUNT(*) FROM follows WHERE followee_id = ?),
+            (SELECT COUNT(*) FROM follows WHERE follower_id = ?),
+            EXISTS(SELECT 1 FROM follows WHERE follower_id = ? AND followee_id = ?)`,
+		userID, userID, viewerID, userID,
+	).Scan(&followers, &following, &isFollowing)
+	return
+}
+
+// Notify the followers of an author about a new post.
+func NotifyFollowers(authorID int, postID int64) {
+	rows, err := DB.Query(`
+        INSERT INTO notifications (user_id, actor_id, post_id, type)
+        SELECT follower_id, ?, ?, 'post'
+        FROM follows
+        WHERE followee_id = ?
+        AND follower_id NOT IN (SELECT user_id FROM blocks WHERE target_id = ?)
+        RETURNING id, user_id`,
+		authorID, postID, authorID, authorID,
+	)
+	if err != nil {
+		slog.Error("Failed to notify followers", "error", err)
+		return
+	}
+
+	var notifs []Notification
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.UserID); err != nil {
+			slog.Error("Failed to notify followers", "error", err)
+			break
+		}
+		notifs = append(notifs, n)
+	}
+	rows.Close()
+	if len(notifs) == 0 {
+		return
+	}
+
+	pid := int(postID)
+	username, profilePic := GetUsername(authorID), GetUserProfilePic(authorID)
+	for _, n := range notifs {
+		n.ActorID = authorID
+		n.PostID = &pid
+		n.Type = "post"
+		n.Message = buildNotification("post")
+		n.ActorUsername = username
+		n.ActorProfilePic = profilePic
+		n.CreatedAt = time.Now()
+		NotifyUser(n) // Send real-time WS update
+		PushUnreadCount(n.UserID)
+	}
+}
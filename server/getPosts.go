@@ -2,16 +2,11 @@ package server
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
 )
 
-// Num of posts on each scroll load in home.
-var HomeLimit = 10
-
 // Handle fetching all Posts with query offset and query tags.
 func GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -19,19 +14,47 @@ func GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	cursor, offset, legacy, err := ParsePaging(r)
 	if err != nil {
 		JsonError(w, "Bad request", http.StatusBadRequest, err)
 		return
 	}
 
 	tagsParam := r.URL.Query().Get("tags")
+	rank, ok := ParseRanking(r)
+	if !ok {
+		JsonError(w, "Invalid sort", http.StatusBadRequest, nil)
+		return
+	}
+	// Scores move all the time, only "new" can be paginated by keyset,
+	// ranked sorts get offset cursors.
+	if cursor != nil && rank.Sort != "new" {
+		JsonError(w, "This cursor is only valid for sort=new", http.StatusBadRequest, nil)
+		return
+	}
+
+	// Hide blocked and muted authors.
+	// "following" feed: posts of followed users and categories
+	scope := VisiblePosts(ViewerID(r))
+	switch r.URL.Query().Get("feed") {
+	case "", "all":
+	case "following":
+		user, err := GetUser(r)
+		if err != nil {
+			JsonError(w, "Login to see your feed", http.StatusUnauthorized, err)
+			return
+		}
+		scope = scope.And(FollowingScope(user.ID))
+	default:
+		JsonError(w, "Invalid feed", http.StatusBadRequest, nil)
+		return
+	}
 
 	var posts []Post
 
 	if tagsParam == "" {
 		// No filter => return all posts
-		posts, err = FetchAllPosts(offset)
+		posts, err = FetchAllPosts(cursor, offset, rank, scope)
 	} else {
 		rawTags := strings.Split(tagsParam, ",")
 		var tags []string
@@ -42,9 +65,9 @@ func GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if len(tags) == 0 {
-			posts, err = FetchAllPosts(offset)
+			posts, err = FetchAllPosts(cursor, offset, rank, scope)
 		} else {
-			posts, err = FetchPostsByTags(offset, tags)
+			posts, err = FetchPostsByTags(cursor, offset, tags, rank, scope)
 		}
 	}
 
@@ -53,18 +76,36 @@ func GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(posts)
+	var next string
+	if rank.Sort == "new" {
+		posts, next = paginate(posts, Conf.Limits.HomePosts, postCursor)
+	} else {
+		posts, next = paginateOffset(posts, Conf.Limits.HomePosts, offset)
+	}
+	if err := LoadPostDetails(posts, ViewerID(r)); err != nil {
+		JsonError(w, "Failed to load posts details", http.StatusInternalServerError, err)
+		return
+	}
+	WritePage(w, posts, next, legacy)
 }
 
-// Returns all posts (10 limit, offset)
-func FetchAllPosts(offset int) ([]Post, error) {
+// Returns all posts of the scope (10 limit + 1 to detect next page) after
+// the cursor or offset, in the given ranking order.
+func FetchAllPosts(cursor *Cursor, offset int, rank Ranking, scope PostScope) ([]Post, error) {
+	window, args := rank.Where()
+	keyset, keysetArgs := cursor.Where("p.created_at", "p.id")
+	args = append(args, keysetArgs...)
+	args = append(args, scope.Args...)
+	args = append(args, Conf.Limits.HomePosts+1, offset)
+
 	rows, err := DB.Query(`
-        SELECT p.id, p.user_id, p.title, p.content, p.image, p.created_at, u.username, u.profile_pic
+        SELECT p.id, p.user_id, p.title, p.content, p.image, p.created_at, u.username, u.profile_pic, u.is_bot
         FROM posts p
         JOIN users u ON p.user_id = u.id
-        ORDER BY p.created_at DESC
-        LIMIT ? OFFSET ?`, HomeLimit, offset)
+        LEFT JOIN post_scores s ON s.post_id = p.id
+        WHERE `+window+` AND `+keyset+` AND `+scope.Where+`
+        ORDER BY `+rank.OrderBy()+`
+        LIMIT ? OFFSET ?`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -73,36 +114,42 @@ func FetchAllPosts(offset int) ([]Post, error) {
 	return ScanRows(rows)
 }
 
-// Return only posts that have all given tags
-func FetchPostsByTags(offset int, tags []string) ([]Post, error) {
+// Return only posts of the scope that have all given tags
+func FetchPostsByTags(cursor *Cursor, offset int, tags []string, rank Ranking, scope PostScope) ([]Post, error) {
 	placeholders := make([]string, len(tags))
 	for i := range tags {
 		placeholders[i] = "?"
 	}
 	inClause := strings.Join(placeholders, ",")
 
-	args := make([]interface{}, 0, len(tags)+2)
+	window, windowArgs := rank.Where()
+	keyset, keysetArgs := cursor.Where("p.created_at", "p.id")
+	args := make([]interface{}, 0, len(tags)+len(windowArgs)+len(keysetArgs)+len(scope.Args)+3)
 	for _, t := range tags {
 		args = append(args, t)
 	}
+	args = append(args, windowArgs...)
+	args = append(args, keysetArgs...)
+	args = append(args, scope.Args...)
 	// Next param is the count for HAVING COUNT
 	args = append(args, len(tags))
-	// Append LIMIT (before-last param)
-	args = append(args, HomeLimit)
+	// Append LIMIT (before-last param), one extra row to detect next page
+	args = append(args, Conf.Limits.HomePosts+1)
 	// Last param is offset
 	args = append(args, offset)
 
 	query := fmt.Sprintf(`
-        SELECT p.id, p.user_id, p.title, p.content, p.image, p.created_at, u.username, u.profile_pic
+        SELECT p.id, p.user_id, p.title, p.content, p.image, p.created_at, u.username, u.profile_pic, u.is_bot
         FROM posts p
         JOIN users u ON p.user_id = u.id
         JOIN post_categories pc ON p.id = pc.post_id
         JOIN categories c ON pc.category_id = c.id
-        WHERE LOWER(c.name) IN (%s)
+        LEFT JOIN post_scores s ON s.post_id = p.id
+        WHERE LOWER(c.name) IN (%s) AND %s AND %s AND %s
         GROUP BY p.id
         HAVING COUNT(DISTINCT LOWER(c.name)) = ?
-        ORDER BY p.created_at DESC
-        LIMIT ? OFFSET ?`, inClause)
+        ORDER BY %s
+        LIMIT ? OFFSET ?`, inClause, window, keyset, scope.Where, rank.OrderBy())
 
 	rows, err := DB.Query(query, args...)
 	if err != nil {
@@ -113,32 +160,8 @@ func FetchPostsByTags(offset int, tags []string) ([]Post, error) {
 	return ScanRows(rows)
 }
 
-// Helper function to fetch categories for a post
-func FetchPostCategories(postID int) ([]Category, error) {
-	rows, err := DB.Query(`
-        SELECT c.id, c.name
-        FROM post_categories pc
-        JOIN categories c ON pc.category_id = c.id
-        WHERE pc.post_id = ?`,
-		postID,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var cats []Category
-	for rows.Next() {
-		var c Category
-		if err := rows.Scan(&c.ID, &c.Name); err != nil {
-			return nil, err
-		}
-		cats = append(cats, c)
-	}
-	return cats, rows.Err()
-}
-
 // Helper function that scan rows and return a bunch of posts.
+// Use LoadPostDetails to fill their categories and counters.
 func ScanRows(rows *sql.Rows) ([]Post, error) {
 	var posts []Post
 	for rows.Next() {
@@ -152,12 +175,17 @@ func ScanRows(rows *sql.Rows) ([]Post, error) {
 			&pa.CreatedAt,
 			&pa.Username,
 			&pa.ProfilePic,
+			&pa.IsBot,
 		); err != nil {
 			return nil, err
 		}
-		cats, _ := FetchPostCategories(pa.ID)
-		pa.Categories = cats
+		pa.ContentHTML = RenderMarkdown(pa.Content)
 		posts = append(posts, pa)
 	}
 	return posts, rows.Err()
 }
+
+// Cursor of a post in (created_at, id) order.
+func postCursor(p Post) Cursor {
+	return Cursor{CreatedAt: p.CreatedAt, ID: p.ID}
+}
@@ -3,11 +3,7 @@ package server
 import (
 	"bytes"
 	"html/template"
-	"log"
 	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
 )
 
 // Parse the html files and execute them after checking for errors.
@@ -46,16 +42,3 @@ func secureHeaders(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-
-// Listens for termination signals and ensures the DB is closed before exiting.
-func Shutdown() {
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-
-	<-stop
-	log.Println("shutting down server...")
-	if err := DB.Close(); err != nil {
-		log.Println("Error closing DB:", err)
-	}
-	os.Exit(0)
-}
@@ -0,0 +1,396 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Registers the format
+)
+
+const (
+	// Decoded RGBA takes 4 bytes per pixel, 25MP is ~100MB.
+	maxImagePixels = 25_000_000
+	// GIF frames are paletted (1 byte per pixel), limit all frames together.
+	maxGIFPixels = 100_000_000
+	jpegQuality  = 85
+)
+
+// Derived sizes, largest first: each one is scaled down from the previous.
+// Stored next to the original as <name>_<size><ext>.
+var imageSizes = []struct {
+	Name string
+	Box  int // Max width and height
+}{
+	{"medium", 1080}, // Post images
+	{"thumb", 320},   // Avatars
+}
+
+// Wrapped by every error caused by the uploaded image itself.
+var ErrImage = errors.New("invalid image")
+
+// Image re-encoded from an upload, without its metadata.
+type ProcessedImage struct {
+	Ext      string
+	Data     []byte
+	Variants map[string][]byte // By size name
+}
+
+// Sniff the real format of an upload, decode it within the pixel limits
+// and re-encode it with its derived sizes.
+// Re-encoding drops EXIF, comments and anything appended to the image.
+func ProcessImage(data []byte) (*ProcessedImage, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: use jpeg, png, gif or webp", ErrImage)
+	}
+	pixels := cfg.Width * cfg.Height
+	if pixels <= 0 {
+		return nil, fmt.Errorf("%w: empty image", ErrImage)
+	}
+	if pixels > maxImagePixels {
+		return nil, fmt.Errorf("%w: more than %d megapixels", ErrImage, maxImagePixels/1_000_000)
+	}
+
+	out := &ProcessedImage{Variants: make(map[string][]byte, len(imageSizes))}
+	var img image.Image
+	switch format {
+	case "gif":
+		frames, err := gifFrames(data)
+		if err != nil {
+			return nil, err
+		}
+		if pixels*frames > maxGIFPixels {
+			return nil, fmt.Errorf("%w: animation is too large", ErrImage)
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(g.Image) == 0 {
+			return nil, fmt.Errorf("%w: corrupted gif", ErrImage)
+		}
+		// Keeps the animation, derived sizes are still images of the first frame
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, g); err != nil {
+			return nil, err
+		}
+		out.Ext, out.Data = ".gif", buf.Bytes()
+
+		first := image.NewRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+		draw.Draw(first, g.Image[0].Bounds(), g.Image[0], g.Image[0].Bounds().Min, draw.Src)
+		img = first
+
+	case "jpeg", "png", "webp":
+		img, _, err = image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: corrupted %s", ErrImage, format)
+		}
+		switch format {
+		case "jpeg":
+			// The orientation tag is dropped with EXIF, apply it to the pixels
+			img = orient(img, jpegOrientation(data))
+			out.Ext = ".jpg"
+		case "png":
+			out.Ext = ".png"
+		case "webp":
+			// No webp encoder, keep transparency only when needed
+			out.Ext = ".png"
+			if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+				out.Ext = ".jpg"
+			}
+		}
+		if out.Data, err = encodeImage(out.Ext, img); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: use jpeg, png, gif or webp", ErrImage)
+	}
+
+	for _, size := range imageSizes {
+		resized := fitImage(img, size.Box)
+		if resized == nil {
+			// Already small enough
+			out.Variants[size.Name] = out.Data
+			continue
+		}
+		b, err := encodeImage(out.Ext, resized)
+		if err != nil {
+			return nil, err
+		}
+		out.Variants[size.Name] = b
+		img = resized
+	}
+	return out, nil
+}
+
+// Process an upload and save it with its derived sizes, unless the same
+// file was already uploaded. Return only the name of the original,
+// errors wrapping ErrImage are the client's.
+func SaveImg(imageB []byte) (string, error) {
+	sum := sha256.Sum256(imageB)
+	hash := hex.EncodeToString(sum[:])
+	mediaMu.Lock()
+	name, err := touchMedia(hash)
+	mediaMu.Unlock()
+	if err != sql.ErrNoRows {
+		return name, err
+	}
+
+	img, err := ProcessImage(imageB)
+	if err != nil {
+		return "", err
+	}
+	name = hash + img.Ext
+	total := len(img.Data)
+	for _, b := range img.Variants {
+		total += len(b)
+	}
+
+	mediaMu.Lock()
+	defer mediaMu.Unlock()
+	// Same file processed concurrently
+	if name, err := touchMedia(hash); err != sql.ErrNoRows {
+		return name, err
+	}
+
+	if err := Media.Put(name, img.Data); err != nil {
+		return "", err
+	}
+	for size, b := range img.Variants {
+		if err := Media.Put(ImageVariant(name, size), b); err != nil {
+			removeUpload(name)
+			return "", err
+		}
+	}
+	// Unreferenced until a post, draft or user uses it
+	if _, err := DB.Exec(`INSERT INTO media (hash, name, size) VALUES (?, ?, ?)`, hash, name, total); err != nil {
+		removeUpload(name)
+		return "", err
+	}
+	return name, nil
+}
+
+// Remove an uploaded image and its derived sizes.
+func removeUpload(name string) error {
+	if name == "" {
+		return nil
+	}
+	files := []string{name}
+	for _, size := range imageSizes {
+		files = append(files, ImageVariant(name, size.Name))
+	}
+	for _, f := range files {
+		if err := Media.Delete(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Name of a derived size of an uploaded image, "" for no image.
+func ImageVariant(name, size string) string {
+	if name == "" {
+		return ""
+	}
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "_" + size + ext
+}
+
+// Original of a derived size, false if it isn't one.
+func imageOriginal(name string) (string, bool) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for _, size := range imageSizes {
+		if original, ok := strings.CutSuffix(base, "_"+size.Name); ok {
+			return original + ext, true
+		}
+	}
+	return "", false
+}
+
+// Scale down to fit in a box x box square, nil if it already fits.
+func fitImage(img image.Image, box int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= box && h <= box {
+		return nil
+	}
+	if w >= h {
+		w, h = box, max(1, h*box/w)
+	} else {
+		w, h = max(1, w*box/h), box
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func encodeImage(ext string, img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch ext {
+	case ".jpg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case ".png":
+		err = png.Encode(&buf, img)
+	case ".gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = fmt.Errorf("no encoder for %s", ext)
+	}
+	return buf.Bytes(), err
+}
+
+// Number of frames of a GIF, counted from its blocks without decompressing them.
+func gifFrames(data []byte) (int, error) {
+	errCorrupted := fmt.Errorf("%w: corrupted gif", ErrImage)
+	// Header and logical screen descriptor
+	if len(data) < 13 {
+		return 0, errCorrupted
+	}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&7 + 1) // Global color table
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // Extension: introducer, label, sub-blocks
+			pos += 2
+		case 0x2C: // Image: descriptor, local color table, LZW code size, sub-blocks
+			if pos+10 > len(data) {
+				return 0, errCorrupted
+			}
+			frames++
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&7 + 1)
+			}
+			pos++
+		case 0x3B: // Trailer
+			return frames, nil
+		default:
+			return 0, errCorrupted
+		}
+		// Sub-blocks, ended by an empty one
+		for {
+			if pos >= len(data) {
+				return 0, errCorrupted
+			}
+			n := int(data[pos])
+			pos += n + 1
+			if n == 0 {
+				break
+			}
+		}
+	}
+	// Truncated before the trailer
+	return 0, errCorrupted
+}
+
+// EXIF orientation (1 to 8) of a JPEG, 1 when missing.
+func jpegOrientation(data []byte) int {
+	pos := 2 // SOI
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		// Metadata segments all come before the scan
+		if marker == 0xDA || size < 2 || pos+2+size > len(data) {
+			break
+		}
+		segment := data[pos+4 : pos+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + size
+	}
+	return 1
+}
+
+// Orientation tag of the first IFD of a TIFF header.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// Rotate and flip pixels as told by an EXIF orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	// 5 to 8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := x, y
+			switch orientation {
+			case 2: // Mirrored
+				sx = w - 1 - x
+			case 3: // Upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // Upside down mirrored
+				sy = h - 1 - y
+			case 5: // Transposed
+				sx, sy = y, x
+			case 6: // Rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // Transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // Rotated 90° counterclockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
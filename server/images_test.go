@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+// GIF of the given screen size made of empty frames, without any pixel data
+// to decode.
+func gifWithFrames(width, height, frames int) []byte {
+	var b bytes.Buffer
+	b.WriteString("GIF89a")
+	binary.Write(&b, binary.LittleEndian, [2]uint16{uint16(width), uint16(height)})
+	b.Write([]byte{0, 0, 0}) // No global color table
+	for i := 0; i < frames; i++ {
+		b.WriteByte(0x2C)
+		binary.Write(&b, binary.LittleEndian, [4]uint16{0, 0, uint16(width), uint16(height)})
+		b.Write([]byte{0, 2, 0}) // Flags, LZW code size, empty sub-block
+	}
+	b.WriteByte(0x3B)
+	return b.Bytes()
+}
+
+func TestProcessImageGIFLimits(t *testing.T) {
+	header := gifWithFrames(10, 10, 1)[:13]
+	tests := []struct {
+		name string
+		data []byte
+		want string // Substring of the error
+	}{
+		{"truncated header", []byte("GIF89a\x0a\x00"), "use jpeg, png, gif or webp"},
+		{"truncated frame", append(header, 0x2C, 0, 0), "corrupted gif"},
+		{"unknown block", append(header, 0x99), "corrupted gif"},
+		{"oversized screen", gifWithFrames(6000, 5000, 1), "more than 25 megapixels"},
+		{"too many frames", gifWithFrames(5000, 5000, 5), "animation is too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ProcessImage(tt.data)
+			if !errors.Is(err, ErrImage) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ProcessImage = %v, want an ErrImage with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGIFFrames(t *testing.T) {
+	for _, want := range []int{0, 1, 3} {
+		if got, err := gifFrames(gifWithFrames(10, 10, want)); err != nil || got != want {
+			t.Errorf("gifFrames of %d frames = %d, %v", want, got, err)
+		}
+	}
+	// Global color table longer than the file
+	if _, err := gifFrames([]byte("GIF89a\x0a\x00\x0a\x00\x87\x00\x00\x2C")); !errors.Is(err, ErrImage) {
+		t.Errorf("gifFrames of a truncated color table = %v, want ErrImage", err)
+	}
+	noTrailer := gifWithFrames(10, 10, 1)
+	if _, err := gifFrames(noTrailer[:len(noTrailer)-1]); !errors.Is(err, ErrImage) {
+		t.Errorf("gifFrames without a trailer = %v, want ErrImage", err)
+	}
+}
+
+// JPEG of a width x height image with an APP1 EXIF segment holding the
+// orientation, in the given byte order.
+func jpegWithOrientation(t *testing.T, width, height, orientation int, order binary.ByteOrder) []byte {
+	t.Helper()
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(&tiff, order, uint16(42))
+	binary.Write(&tiff, order, uint32(8)) // First IFD
+	binary.Write(&tiff, order, uint16(1)) // Entries
+	binary.Write(&tiff, order, [2]uint16{0x0112, 3})
+	binary.Write(&tiff, order, uint32(1))
+	binary.Write(&tiff, order, [2]uint16{uint16(orientation), 0})
+	binary.Write(&tiff, order, uint32(0)) // No next IFD
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var b bytes.Buffer
+	b.Write(img.Bytes()[:2]) // SOI
+	b.Write([]byte{0xFF, 0xE1})
+	binary.Write(&b, binary.BigEndian, uint16(len(segment)+2))
+	b.Write(segment)
+	b.Write(img.Bytes()[2:])
+	return b.Bytes()
+}
+
+// Orientations 5 to 8 turn the image a quarter.
+func TestProcessImageOrientation(t *testing.T) {
+	for o := 1; o <= 8; o++ {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			data := jpegWithOrientation(t, 40, 20, o, order)
+			if got := jpegOrientation(data); got != o {
+				t.Errorf("jpegOrientation(%v, %d) = %d", order, o, got)
+			}
+
+			img, err := ProcessImage(data)
+			if err != nil {
+				t.Fatalf("ProcessImage(orientation %d): %v", o, err)
+			}
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(img.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantW, wantH := 40, 20
+			if o >= 5 {
+				wantW, wantH = 20, 40
+			}
+			if cfg.Width != wantW || cfg.Height != wantH {
+				t.Errorf("orientation %d gives %dx%d, want %dx%d", o, cfg.Width, cfg.Height, wantW, wantH)
+			}
+		}
+	}
+	if got := jpegOrientation(jpegWithOrientation(t, 4, 4, 9, binary.BigEndian)); got != 1 {
+		t.Errorf("jpegOrientation of an invalid tag = %d, want 1", got)
+	}
+}
+
+// The top left pixel of the stored image ends where the orientation says.
+func TestOrient(t *testing.T) {
+	marked := color.RGBA{255, 0, 0, 255}
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, marked)
+
+	corners := map[int]image.Point{
+		1: {0, 0},
+		2: {2, 0},
+		3: {2, 1},
+		4: {0, 1},
+		5: {0, 0},
+		6: {1, 0},
+		7: {1, 2},
+		8: {0, 2},
+	}
+	for o, want := range corners {
+		got := orient(src, o)
+		if got.At(want.X, want.Y) != marked {
+			t.Errorf("orient(%d) moved the top left pixel away from %v", o, want)
+		}
+	}
+}
+
+func TestProcessImageWebP(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string // 1x1 images, base64
+		wantExt string
+	}{
+		{"lossy", "UklGRiIAAABXRUJQVlA4IBYAAAAwAQCdASoBAAEADsD+JaQAA3AAAAAA", ".jpg"},
+		{"alpha", "UklGRkoAAABXRUJQVlA4WAoAAAAQAAAAAAAAAAAAQUxQSAwAAAARBxAR/Q9ERP8DAABWUDggGAAAABQBAJ0BKgEAAQAAAP4AAA3AAP7mtQAAAA==", ".png"},
+	}
+	for _, tt := range tests {
+		data, err := base64.StdEncoding.DecodeString(tt.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := ProcessImage(data)
+		if err != nil {
+			t.Fatalf("ProcessImage(%s): %v", tt.name, err)
+		}
+		if img.Ext != tt.wantExt {
+			t.Errorf("%s webp stored as %s, want %s", tt.name, img.Ext, tt.wantExt)
+		}
+		if _, format, err := image.DecodeConfig(bytes.NewReader(img.Data)); err != nil || "."+format != strings.Replace(tt.wantExt, "jpg", "jpeg", 1) {
+			t.Errorf("%s webp encoded as %q, %v", tt.name, format, err)
+		}
+	}
+}
+
+// The format is sniffed, an image extension doesn't make a file an image.
+func TestProcessImageNotAnImage(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte("<?php echo 'hello'; ?>"),
+		[]byte("\x89PNG\r\n\x1a\n<script>"),
+		{},
+	} {
+		if _, err := ProcessImage(data); !errors.Is(err, ErrImage) {
+			t.Errorf("ProcessImage(%q) = %v, want ErrImage", data, err)
+		}
+	}
+}
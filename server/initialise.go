@@ -25,35 +25,28 @@ type Links struct {
 	ErrorPage string `json:"error"`
 }
 
-// Initialise server port, cloud-links and database (DB).
+// Initialise config, cloud-links, media store, link previews, database (DB), event subscribers and background jobs.
 func Initialise() bool {
-	initialiseEnv()
-	if initialisePort() {
+	if initialiseConfig() {
 		return false
 	}
 	initialiseLinks()
+	initialiseMedia()
+	initialiseLinkPreviews()
 	initialiseDB()
+	initialiseEvents()
+	initialiseJobs()
+	StartJobs()
+	StartLinkPreviews()
+	StartWebhooks()
 	return true
 }
 
-// Set environments variables.
-func initialiseEnv() {
-	// Only in localhost or local container
-	if os.Getenv("FLY_APP_NAME") == "" {
-		SetEnv()
-	}
-	// In all environments
-	Port = os.Getenv("PORT")
-	GithubClientSecret = os.Getenv("GITHUB_CLIENT_SECRET")
-	GoogleClientSecret = os.Getenv("GOOGLE_CLIENT_SECRET")
-	GithubClientID = os.Getenv("GITHUB_CLIENT_ID")
-	GoogleClientID = os.Getenv("GOOGLE_CLIENT_ID")
-}
-
-// Checks for the "-print-port" flag, for Makefile target
-func initialisePort() bool {
+// Load the config (see config.go), or print a free port for the
+// "-print-port" flag of the Makefile target.
+func initialiseConfig() (quit bool) {
 	printPort := flag.Bool("print-port", false, "Print a random available port and exit")
-	flag.Parse()
+	conf, err := LoadConfig(flag.CommandLine, os.Args[1:])
 
 	if *printPort {
 		listener, err := net.Listen("tcp", "127.0.0.1:0")
@@ -67,6 +60,12 @@ func initialisePort() bool {
 		return true
 	}
 
+	if err != nil {
+		log.Fatal("Invalid config: ", err)
+	}
+	Conf = conf
+	Port = conf.Port
+	initialiseLogger()
 	return false
 }
 
@@ -86,7 +85,7 @@ func initialiseLinks() {
 // create/open DB and create tables if they aren't already created.
 func initialiseDB() {
 	var err error
-	DB, err = sql.Open("sqlite3", "./database/forum.db")
+	DB, err = sql.Open("sqlite3", Conf.DBPath)
 	if err != nil {
 		log.Fatal("Failed to open SQLite database:", err)
 	}
@@ -108,4 +107,8 @@ func initialiseDB() {
 	if _, err := DB.Exec(string(content)); err != nil {
 		log.Fatal("Failed to create database tables:", err)
 	}
+
+	if err := migrateDB(); err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
 }
@@ -0,0 +1,428 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of background jobs.
+const (
+	JobScorePosts    = "posts.score"
+	JobPublishDrafts = "drafts.publish"
+	JobSweepMedia    = "media.sweep"
+	JobPurgeSessions = "sessions.purge"
+	JobPruneJobs     = "jobs.prune"
+)
+
+var jobStatuses = []string{"queued", "running", "succeeded", "failed"}
+
+const (
+	jobWorkers        = 2
+	jobPollEvery      = 5 * time.Second
+	jobBackoff        = 10 * time.Second // Doubled after each failed attempt
+	jobMaxBackoff     = time.Hour
+	jobMaxAttempts    = 5
+	jobTimeout        = 5 * time.Minute
+	jobErrorSize      = 500
+	keepSucceededJobs = 7 * 24 * time.Hour
+	keepFailedJobs    = 30 * 24 * time.Hour
+	// Num of jobs on each page of the admin list.
+	jobsLimit = 50
+)
+
+// Runs a job, an error retries it until its max attempts. ctx is done at
+// the Timeout of its kind, or on shutdown.
+type JobFunc func(ctx context.Context, payload json.RawMessage) error
+
+type JobKind struct {
+	Run         JobFunc
+	MaxAttempts int           // Default jobMaxAttempts
+	Timeout     time.Duration // Default jobTimeout
+	Every       time.Duration // Periodic when set, the next run is queued when one ends
+}
+
+// Options of EnqueueJob.
+type JobOptions struct {
+	RunAt     time.Time // Default now
+	UniqueKey string    // Not queued while a job with the key is queued or running
+}
+
+type Job struct {
+	ID          int             `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"` // queued, running, succeeded or failed
+	UniqueKey   *string         `json:"unique_key"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error"`
+	CreatedAt   time.Time       `json:"created_at"`
+	StartedAt   *time.Time      `json:"started_at"`
+	FinishedAt  *time.Time      `json:"finished_at"`
+}
+
+// Registered before StartJobs, read only afterwards.
+var jobKinds = map[string]JobKind{}
+
+// Wakes a worker when a job is queued.
+var jobWake = make(chan struct{}, 1)
+
+// Register the handler of a kind of job.
+func RegisterJob(kind string, k JobKind) {
+	if k.MaxAttempts == 0 {
+		k.MaxAttempts = jobMaxAttempts
+	}
+	if k.Timeout == 0 {
+		k.Timeout = jobTimeout
+	}
+	jobKinds[kind] = k
+}
+
+// Queue a job. The id is 0 when a job with the same unique key is pending.
+func EnqueueJob(kind string, payload any, opts JobOptions) (int64, error) {
+	k, ok := jobKinds[kind]
+	if !ok {
+		return 0, fmt.Errorf("unknown job kind %q", kind)
+	}
+	data := []byte("{}")
+	if payload != nil {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return 0, err
+		}
+	}
+	runAt := opts.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+	var uniqueKey any
+	if opts.UniqueKey != "" {
+		uniqueKey = opts.UniqueKey
+	}
+
+	res, err := DB.Exec(`
+        INSERT OR IGNORE INTO jobs (kind, payload, unique_key, max_attempts, run_at)
+        VALUES (?, ?, ?, ?, ?)`,
+		kind, string(data), uniqueKey, k.MaxAttempts, runAt.UTC().Format(sqlTimeLayout))
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, nil
+	}
+	wakeJobs()
+	return res.LastInsertId()
+}
+
+func wakeJobs() {
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+}
+
+// Register the forum's jobs.
+func initialiseJobs() {
+	RegisterJob(JobScorePosts, JobKind{Run: periodicJob(ScorePosts), MaxAttempts: 1, Every: scoreInterval})
+	RegisterJob(JobPublishDrafts, JobKind{Run: periodicJob(PublishScheduledDrafts), MaxAttempts: 1, Every: draftsCheckEvery})
+	RegisterJob(JobSweepMedia, JobKind{Run: periodicJob(SweepMedia), MaxAttempts: 1, Every: mediaSweepEvery})
+	RegisterJob(JobPurgeSessions, JobKind{Run: periodicJob(PurgeSessions), MaxAttempts: 1, Every: time.Hour})
+	RegisterJob(JobPruneJobs, JobKind{Run: periodicJob(PruneJobs), MaxAttempts: 1, Every: 24 * time.Hour})
+}
+
+func periodicJob(fn func(context.Context) error) JobFunc {
+	return func(ctx context.Context, _ json.RawMessage) error { return fn(ctx) }
+}
+
+// Start the workers. Jobs are canceled on shutdown, those left running by a
+// stopped server are queued again, and periodic jobs get their first run
+// if they have none pending.
+func StartJobs() {
+	now := time.Now().UTC().Format(sqlTimeLayout)
+	if _, err := DB.Exec(`UPDATE jobs SET status = 'queued', run_at = ? WHERE status = 'running'`, now); err != nil {
+		slog.Error("Failed to requeue interrupted jobs", "error", err)
+	}
+
+	kinds := make([]string, 0, len(jobKinds))
+	for kind := range jobKinds {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	for _, kind := range kinds {
+		if jobKinds[kind].Every == 0 {
+			continue
+		}
+		if _, err := EnqueueJob(kind, nil, JobOptions{UniqueKey: periodicKey(kind)}); err != nil {
+			slog.Error("Failed to schedule job", "kind", kind, "error", err)
+		}
+	}
+
+	for range jobWorkers {
+		goBackground(func() {
+			for !stopping() {
+				ran, err := runNextJob()
+				if err != nil {
+					slog.Error("Failed to run jobs", "error", err)
+				}
+				if ran {
+					continue
+				}
+				select {
+				case <-jobWake:
+				case <-time.After(jobPollEvery):
+				case <-shutdownCtx.Done():
+				}
+			}
+		})
+	}
+}
+
+func periodicKey(kind string) string {
+	return "every:" + kind
+}
+
+// Claim and run the next due job, false when none is due.
+func runNextJob() (bool, error) {
+	now := time.Now().UTC().Format(sqlTimeLayout)
+	var job Job
+	var payload string
+	err := DB.QueryRow(`
+        UPDATE jobs SET status = 'running', attempts = attempts + 1, started_at = ?
+        WHERE id = (
+            SELECT id FROM jobs
+            WHERE status = 'queued' AND run_at <= ?
+            ORDER BY run_at, id
+            LIMIT 1
+        )
+        RETURNING id, kind, payload, attempts, max_attempts`, now, now,
+	).Scan(&job.ID, &job.Kind, &payload, &job.Attempts, &job.MaxAttempts)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	job.Payload = json.RawMessage(payload)
+
+	k, ok := jobKinds[job.Kind]
+	var jobErr error
+	if !ok {
+		jobErr = fmt.Errorf("unknown job kind %q", job.Kind)
+		job.MaxAttempts = job.Attempts // Not retried
+	} else {
+		jobErr = runJob(k, job)
+	}
+	if jobErr != nil && stopping() {
+		// Interrupted by the shutdown, doesn't count as an attempt
+		_, err := DB.Exec(`
+            UPDATE jobs SET status = 'queued', attempts = attempts - 1, run_at = ?
+            WHERE id = ?`, now, job.ID)
+		return true, err
+	}
+	return true, finishJob(k, job, jobErr)
+}
+
+// Run a job within its timeout, panics are errors. It is canceled on
+// shutdown, before the DB is closed.
+func runJob(k JobKind, job Job) (err error) {
+	ctx, cancel := context.WithTimeout(shutdownCtx, k.Timeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			slog.Error("Job panicked", "job_id", job.ID, "kind", job.Kind, "panic", p, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return k.Run(ctx, job.Payload)
+}
+
+// Store the outcome of a job, failures are retried with backoff.
+func finishJob(k JobKind, job Job, jobErr error) error {
+	now := time.Now().UTC()
+	switch {
+	case jobErr == nil:
+		_, err := DB.Exec(`
+            UPDATE jobs SET status = 'succeeded', last_error = '', finished_at = ?
+            WHERE id = ?`, now.Format(sqlTimeLayout), job.ID)
+		if err != nil {
+			return err
+		}
+	case job.Attempts < job.MaxAttempts:
+		slog.Warn("Job failed, retrying", "job_id", job.ID, "kind", job.Kind, "error", jobErr)
+		_, err := DB.Exec(`
+            UPDATE jobs SET status = 'queued', last_error = ?, run_at = ?
+            WHERE id = ?`,
+			truncateError(jobErr), now.Add(jobRetryDelay(job.Attempts)).Format(sqlTimeLayout), job.ID)
+		return err
+	default:
+		slog.Error("Job failed", "job_id", job.ID, "kind", job.Kind, "error", jobErr)
+		_, err := DB.Exec(`
+            UPDATE jobs SET status = 'failed', last_error = ?, finished_at = ?
+            WHERE id = ?`, truncateError(jobErr), now.Format(sqlTimeLayout), job.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if k.Every > 0 {
+		_, err := EnqueueJob(job.Kind, nil, JobOptions{RunAt: now.Add(k.Every), UniqueKey: periodicKey(job.Kind)})
+		return err
+	}
+	return nil
+}
+
+// Delay before the next attempt: 10s, 20s, 40s... up to jobMaxBackoff.
+func jobRetryDelay(attempts int) time.Duration {
+	delay := jobBackoff
+	for i := 1; i < attempts && delay < jobMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, jobMaxBackoff)
+}
+
+func truncateError(err error) string {
+	msg := err.Error()
+	if len(msg) > jobErrorSize {
+		msg = msg[:jobErrorSize]
+	}
+	return msg
+}
+
+// Delete expired sessions, they can't be used anymore.
+func PurgeSessions(ctx context.Context) error {
+	_, err := DB.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < ?`, time.Now())
+	return err
+}
+
+// Delete finished jobs once they are old enough.
+func PruneJobs(ctx context.Context) error {
+	now := time.Now().UTC()
+	_, err := DB.ExecContext(ctx, `
+        DELETE FROM jobs
+        WHERE (status = 'succeeded' AND finished_at < ?)
+        OR (status = 'failed' AND finished_at < ?)`,
+		now.Add(-keepSucceededJobs).Format(sqlTimeLayout), now.Add(-keepFailedJobs).Format(sqlTimeLayout))
+	return err
+}
+
+// List jobs, newest first, by status and kind.
+func GetJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	if requireAdmin(w, r) {
+		return
+	}
+
+	offsetParam := r.URL.Query().Get("offset")
+	if offsetParam == "" {
+		offsetParam = "0"
+	}
+	offset, err := strconv.Atoi(offsetParam)
+	if err != nil {
+		JsonError(w, "Bad request", http.StatusBadRequest, err)
+		return
+	}
+	filter, args := "", []any{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter += " AND status = ?"
+		args = append(args, status)
+	}
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		filter += " AND kind = ?"
+		args = append(args, kind)
+	}
+	args = append(args, jobsLimit, offset)
+
+	rows, err := DB.Query(`
+        SELECT id, kind, payload, status, unique_key, attempts, max_attempts, run_at,
+               last_error, created_at, started_at, finished_at
+        FROM jobs
+        WHERE 1 = 1`+filter+`
+        ORDER BY created_at DESC, id DESC
+        LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		JsonError(w, "Failed to fetch jobs", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		var j Job
+		var payload string
+		var uniqueKey sql.NullString
+		var started, finished sql.NullTime
+		err := rows.Scan(&j.ID, &j.Kind, &payload, &j.Status, &uniqueKey, &j.Attempts, &j.MaxAttempts, &j.RunAt,
+			&j.LastError, &j.CreatedAt, &started, &finished)
+		if err != nil {
+			JsonError(w, "Failed to fetch jobs", http.StatusInternalServerError, err)
+			return
+		}
+		j.Payload = json.RawMessage(payload)
+		if uniqueKey.Valid {
+			j.UniqueKey = &uniqueKey.String
+		}
+		if started.Valid {
+			j.StartedAt = &started.Time
+		}
+		if finished.Valid {
+			j.FinishedAt = &finished.Time
+		}
+		jobs = append(jobs, j)
+	}
+	if err := rows.Err(); err != nil {
+		JsonError(w, "Failed to fetch jobs", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// Queue a failed job again, with all its attempts.
+func RetryJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	if requireAdmin(w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		JsonError(w, "Invalid job id", http.StatusBadRequest, err)
+		return
+	}
+
+	now := time.Now().UTC().Format(sqlTimeLayout)
+	res, err := DB.Exec(`
+        UPDATE jobs SET status = 'queued', attempts = 0, run_at = ?, finished_at = NULL
+        WHERE id = ? AND status = 'failed'`, now, id)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE") {
+		JsonError(w, "A job with the same key is already pending", http.StatusConflict, nil)
+		return
+	}
+	if err != nil {
+		JsonError(w, "Failed to retry job", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		JsonError(w, "No failed job with this id", http.StatusNotFound, nil)
+		return
+	}
+	wakeJobs()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"job_id": id})
+}
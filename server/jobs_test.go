@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// The timeout of a kind reaches the job.
+func TestRunJobTimeout(t *testing.T) {
+	k := JobKind{
+		Run: periodicJob(func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+				return nil
+			}
+		}),
+		Timeout: 20 * time.Millisecond,
+	}
+	start := time.Now()
+	err := runJob(k, Job{ID: 1, Kind: "test"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("runJob = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("runJob returned after %v, want about the timeout", elapsed)
+	}
+}
+
+// Periodic jobs stop their queries once canceled.
+func TestPeriodicJobsCanceled(t *testing.T) {
+	testDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	jobs := map[string]func(context.Context) error{
+		JobScorePosts:    ScorePosts,
+		JobPublishDrafts: PublishScheduledDrafts,
+		JobSweepMedia:    SweepMedia,
+		JobPurgeSessions: PurgeSessions,
+		JobPruneJobs:     PruneJobs,
+	}
+	for kind, fn := range jobs {
+		if err := fn(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s with a canceled ctx = %v, want Canceled", kind, err)
+		}
+		if err := fn(context.Background()); err != nil {
+			t.Errorf("%s = %v", kind, err)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// Links unfurled per post or message.
+	maxPreviewLinks = 3
+	maxPreviewURL   = 2048
+	// Whole fetch, redirects included.
+	previewTimeout      = 5 * time.Second
+	previewMaxRedirects = 3
+	// Metadata is in the head, the rest of the page isn't read.
+	previewMaxBody = 512 << 10
+	// Cached previews are fetched again after previewTTL, failures after previewRetry.
+	previewTTL   = 7 * 24 * time.Hour
+	previewRetry = time.Hour
+	// Parallel fetches, links waiting beyond the queue are skipped until next read.
+	previewWorkers   = 4
+	previewQueueSize = 100
+)
+
+// Card shown under a post or message for a link of its content.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	SiteName    string `json:"site_name"`
+}
+
+// Fetches link previews, set from LINK_PREVIEW_ALLOW_PRIVATE by Initialise.
+var Previews = NewUnfurler(false)
+
+var (
+	previewQueue = make(chan string, previewQueueSize)
+	// Links queued or being fetched
+	previewPending   = make(map[string]bool)
+	previewPendingMu sync.Mutex
+)
+
+// Candidate links in text, the end of a sentence or of a Markdown link is trimmed.
+var linkRegex = regexp.MustCompile(`https?://[^\s<>"'\x60\\]+`)
+
+var ErrPreviewBlocked = errors.New("address not allowed")
+
+// Ranges never fetched: loopback, private, link-local (cloud metadata),
+// CGNAT, multicast, reserved and NAT64 (which can wrap any of them).
+var blockedPrefixes = func() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, s := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15",
+		"198.51.100.0/24", "203.0.113.0/24", "224.0.0.0/4", "240.0.0.0/4",
+		"::/128", "::1/128", "64:ff9b::/96", "64:ff9b:1::/48", "100::/64", "2001:db8::/32",
+		"fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		prefixes = append(prefixes, netip.MustParsePrefix(s))
+	}
+	return prefixes
+}()
+
+// HTTP client for untrusted links. Addresses are checked when connecting,
+// after DNS resolution, so names resolving (or rebinding) to internal
+// addresses are refused too.
+type Unfurler struct {
+	client *http.Client
+	// Allow internal addresses and any port, for local stand-ins only.
+	allowPrivate bool
+}
+
+func NewUnfurler(allowPrivate bool) *Unfurler {
+	u := &Unfurler{allowPrivate: allowPrivate}
+	dialer := &net.Dialer{
+		Timeout: previewTimeout,
+		Control: u.checkAddress,
+	}
+	u.client = &http.Client{
+		Timeout: previewTimeout,
+		Transport: &http.Transport{
+			// A proxy would be dialed instead of the checked addresses
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   previewTimeout,
+			ResponseHeaderTimeout: previewTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > previewMaxRedirects {
+				return errors.New("too many redirects")
+			}
+			return validPreviewURL(req.URL)
+		},
+	}
+	return u
+}
+
+// Refuse connections to internal addresses, and ports other than http(s).
+func (u *Unfurler) checkAddress(network, address string, _ syscall.RawConn) error {
+	if u.allowPrivate {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if port := addrPort.Port(); port != 80 && port != 443 {
+		return fmt.Errorf("%w: port %d", ErrPreviewBlocked, port)
+	}
+	ip := addrPort.Addr().Unmap()
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("%w: %s", ErrPreviewBlocked, ip)
+		}
+	}
+	return nil
+}
+
+func validPreviewURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" || u.User != nil {
+		return errors.New("invalid host")
+	}
+	return nil
+}
+
+// Fetch a page and read its OpenGraph / Twitter card metadata.
+// A page without a title has no preview (nil, nil).
+func (u *Unfurler) Fetch(rawURL string) (*LinkPreview, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := validPreviewURL(target); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), previewTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "ForumLinkPreview/1.0")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("not a page: %q", mediaType)
+	}
+
+	meta := readPageMeta(io.LimitReader(resp.Body, previewMaxBody))
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := strings.TrimSpace(meta[k]); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	p := &LinkPreview{
+		URL:         rawURL,
+		Title:       truncateText(first("og:title", "twitter:title", "title"), 200),
+		Description: truncateText(first("og:description", "twitter:description", "description"), 500),
+		SiteName:    truncateText(first("og:site_name"), 100),
+	}
+	if p.Title == "" {
+		return nil, nil
+	}
+	// Relative to the page, after redirects
+	if img := first("og:image", "og:image:url", "twitter:image", "twitter:image:src"); img != "" {
+		if ref, err := resp.Request.URL.Parse(img); err == nil && validPreviewURL(ref) == nil {
+			if s := ref.String(); len(s) <= maxPreviewURL && !strings.ContainsAny(s, `"'<>\`) {
+				p.Image = s
+			}
+		}
+	}
+	return p, nil
+}
+
+// Metadata of the head of a page: <meta> by property or name, and <title>.
+func readPageMeta(r io.Reader) map[string]string {
+	meta := make(map[string]string)
+	z := xhtml.NewTokenizer(r)
+	inTitle := false
+	for {
+		switch z.Next() {
+		case xhtml.ErrorToken:
+			// End of the page, or of what was read
+			return meta
+		case xhtml.TextToken:
+			if inTitle && meta["title"] == "" {
+				meta["title"] = string(z.Text())
+			}
+		case xhtml.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Title:
+				inTitle = false
+			case atom.Head:
+				return meta
+			}
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Title:
+				inTitle = true
+			case atom.Body:
+				return meta
+			case atom.Meta:
+				var key, content string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch string(k) {
+					case "property", "name":
+						if key == "" {
+							key = strings.ToLower(string(v))
+						}
+					case "content":
+						content = string(v)
+					}
+				}
+				// First one wins, like crawlers
+				if key != "" && meta[key] == "" {
+					meta[key] = content
+				}
+			}
+		}
+	}
+}
+
+// Collapse whitespace, cut to max runes and escape, like other user content.
+func truncateText(s string, max int) string {
+	s = strings.Join(strings.Fields(strings.ToValidUTF8(s, "")), " ")
+	if utf8.RuneCountInString(s) > max {
+		s = string([]rune(s)[:max-1]) + "…"
+	}
+	return html.EscapeString(s)
+}
+
+// Distinct http(s) links of a text, in order, up to maxPreviewLinks.
+func ExtractLinks(text string) []string {
+	var links []string
+	seen := make(map[string]bool)
+	for _, link := range linkRegex.FindAllString(text, -1) {
+		link = strings.TrimRight(link, ".,;:!?*_~")
+		// Closing parenthesis of a Markdown link or of the sentence
+		for strings.HasSuffix(link, ")") && strings.Count(link, "(") < strings.Count(link, ")") {
+			link = strings.TrimSuffix(link, ")")
+		}
+		link = strings.TrimRight(link, ".,;:!?*_~")
+		if len(link) > maxPreviewURL || seen[link] {
+			continue
+		}
+		if u, err := url.Parse(link); err != nil || validPreviewURL(u) != nil {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+		if len(links) == maxPreviewLinks {
+			break
+		}
+	}
+	return links
+}
+
+// Cached previews of links, by URL. Links never fetched or stale are queued,
+// their previews show up on later reads.
+func LoadLinkPreviews(links []string) (map[string]LinkPreview, error) {
+	previews := make(map[string]LinkPreview)
+	if len(links) == 0 {
+		return previews, nil
+	}
+	args := make([]any, len(links))
+	for i, link := range links {
+		args[i] = link
+	}
+	rows, err := DB.Query(`
+        SELECT url, title, description, image, site_name, fetched_at
+        FROM link_previews
+        WHERE url IN (`+Placeholders(len(args))+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fresh := make(map[string]bool)
+	for rows.Next() {
+		var p LinkPreview
+		var fetchedAt time.Time
+		if err := rows.Scan(&p.URL, &p.Title, &p.Description, &p.Image, &p.SiteName, &fetchedAt); err != nil {
+			return nil, err
+		}
+		ttl := previewTTL
+		if p.Title == "" {
+			ttl = previewRetry
+		} else {
+			// A stale preview is still shown while refreshed
+			previews[p.URL] = p
+		}
+		fresh[p.URL] = time.Since(fetchedAt) < ttl
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, link := range links {
+		if !fresh[link] {
+			queuePreview(link)
+		}
+	}
+	return previews, nil
+}
+
+// Previews of the links of a text, in order of appearance.
+func PreviewsOf(text string, previews map[string]LinkPreview) []LinkPreview {
+	list := []LinkPreview{}
+	for _, link := range ExtractLinks(text) {
+		if p, ok := previews[link]; ok {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// Queue the links of a new post or message for unfurling.
+func UnfurlLinks(text string) {
+	if _, err := LoadLinkPreviews(ExtractLinks(text)); err != nil {
+		slog.Error("Failed to queue link previews", "error", err)
+	}
+}
+
+// Unfurl the links of new posts.
+func subscribeLinkPreviews() {
+	SubscribeAsync(func(e PostCreated) { UnfurlLinks(e.Content) })
+}
+
+// Queue a link unless already pending, dropped when the queue is full.
+func queuePreview(link string) {
+	previewPendingMu.Lock()
+	defer previewPendingMu.Unlock()
+	if previewPending[link] {
+		return
+	}
+	select {
+	case previewQueue <- link:
+		previewPending[link] = true
+	default:
+	}
+}
+
+func StartLinkPreviews() {
+	for i := 0; i < previewWorkers; i++ {
+		goBackground(func() {
+			for {
+				var link string
+				select {
+				case link = <-previewQueue:
+				case <-shutdownCtx.Done():
+					return // Queued links are unfurled again when next seen
+				}
+				if err := unfurl(link); err != nil {
+					slog.Error("Failed to save link preview", "error", err)
+				}
+				previewPendingMu.Lock()
+				delete(previewPending, link)
+				previewPendingMu.Unlock()
+			}
+		})
+	}
+}
+
+// Fetch a link and cache the result, failures as an empty preview.
+func unfurl(link string) error {
+	p, err := Previews.Fetch(link)
+	if err != nil || p == nil {
+		p = &LinkPreview{URL: link}
+	}
+	_, err = DB.Exec(`
+        INSERT INTO link_previews (url, title, description, image, site_name, fetched_at)
+        VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+        ON CONFLICT (url) DO UPDATE SET
+            title = excluded.title,
+            description = excluded.description,
+            image = excluded.image,
+            site_name = excluded.site_name,
+            fetched_at = excluded.fetched_at`,
+		p.URL, p.Title, p.Description, p.Image, p.SiteName,
+	)
+	return err
+}
+
+// Internal addresses can only be unfurled with link_previews.allow_private,
+// e.g. to test against a local server.
+func initialiseLinkPreviews() {
+	if Conf.LinkPreviews.AllowPrivate {
+		slog.Warn("Link previews may fetch private addresses")
+		Previews = NewUnfurler(true)
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+)
+
+// Header carrying the request ID, a valid incoming one is kept (e.g. from
+// a proxy) so the logs of both sides can be matched.
+const requestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Set the default logger from the log settings. The standard "log"
+// package writes through it too.
+func initialiseLogger() {
+	var level slog.Level
+	level.UnmarshalText([]byte(Conf.Log.Level))
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if Conf.Log.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+type requestLogKey struct{}
+
+// What is logged of a request, also the ResponseWriter recording its
+// status and size.
+type requestLog struct {
+	http.ResponseWriter
+	id     string
+	logger *slog.Logger
+	route  string
+	userID int
+	status int
+	bytes  int64
+}
+
+func (rl *requestLog) WriteHeader(status int) {
+	if rl.status == 0 {
+		rl.status = status
+	}
+	rl.ResponseWriter.WriteHeader(status)
+}
+
+func (rl *requestLog) Write(b []byte) (int, error) {
+	if rl.status == 0 {
+		rl.status = http.StatusOK
+	}
+	n, err := rl.ResponseWriter.Write(b)
+	rl.bytes += int64(n)
+	return n, err
+}
+
+// For http.ResponseController.
+func (rl *requestLog) Unwrap() http.ResponseWriter {
+	return rl.ResponseWriter
+}
+
+// WebSocket upgrades take over the connection.
+func (rl *requestLog) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(rl.ResponseWriter).Hijack()
+	if err == nil && rl.status == 0 {
+		rl.status = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+func (rl *requestLog) Flush() {
+	http.NewResponseController(rl.ResponseWriter).Flush()
+}
+
+// Give each request an ID, echoed in the X-Request-ID header and added to
+// its log lines, and write an access log line once it is served.
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		rl := &requestLog{
+			ResponseWriter: w,
+			id:             id,
+			logger:         slog.Default().With("request_id", id),
+		}
+		r = r.WithContext(context.WithValue(r.Context(), requestLogKey{}, rl))
+		next.ServeHTTP(rl, r)
+
+		// The v1 router sets its own route template
+		if rl.route == "" {
+			rl.route = r.Pattern
+		}
+		status := rl.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// user_id is on the logger once GetUser found the user
+		rl.logger.Info("request",
+			"method", r.Method,
+			"route", rl.route,
+			"path", r.URL.Path,
+			"status", status,
+			"latency", time.Since(start),
+			"bytes", rl.bytes,
+		)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func requestLogOf(ctx context.Context) *requestLog {
+	rl, _ := ctx.Value(requestLogKey{}).(*requestLog)
+	return rl
+}
+
+// Logger of a request, with its request ID.
+func Logger(r *http.Request) *slog.Logger {
+	if rl := requestLogOf(r.Context()); rl != nil {
+		return rl.logger
+	}
+	return slog.Default()
+}
+
+// Logger of the request a ResponseWriter answers, for helpers like
+// JsonError that only get the writer.
+func writerLogger(w http.ResponseWriter) *slog.Logger {
+	for w != nil {
+		if rl, ok := w.(*requestLog); ok {
+			return rl.logger
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	return slog.Default()
+}
+
+// Record the user of a request for the access log, and add it to the
+// request's log lines.
+func logUser(r *http.Request, userID int) {
+	if rl := requestLogOf(r.Context()); rl != nil && rl.userID != userID {
+		rl.userID = userID
+		rl.logger = slog.Default().With("request_id", rl.id, "user_id", userID)
+	}
+}
+
+// Record the route template of a request for the access log.
+func logRoute(r *http.Request, route string) {
+	if rl := requestLogOf(r.Context()); rl != nil {
+		rl.route = route
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"regexp"
+	"sync"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+)
+
+// Max rendered snippets kept in memory.
+const markdownCacheSize = 2000
+
+var (
+	// CommonMark + fenced code highlighting (CSS classes, see css/highlight.css).
+	// Raw HTML in the source is dropped by goldmark (no html.WithUnsafe).
+	markdown = goldmark.New(
+		goldmark.WithExtensions(
+			highlighting.NewHighlighting(
+				highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+			),
+		),
+	)
+
+	// Allowlist sanitizer applied on every rendered output.
+	sanitizer = newSanitizer()
+
+	mdCache = newMarkdownCache(markdownCacheSize)
+)
+
+// User generated content policy, plus highlighting classes.
+func newSanitizer() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^[a-z0-9 _-]+$`)).OnElements("pre", "code", "span")
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	p.RequireNoReferrerOnLinks(true)
+	return p
+}
+
+// Render Markdown source to sanitized HTML, results are cached.
+func RenderMarkdown(src string) string {
+	key := sha256.Sum256([]byte(src))
+	if html, ok := mdCache.Get(key); ok {
+		return html
+	}
+
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(src), &buf); err != nil {
+		// Should not happen, fall back to escaped text.
+		buf.Reset()
+		buf.WriteString("<p>" + bluemonday.StrictPolicy().Sanitize(src) + "</p>")
+	}
+	html := sanitizer.Sanitize(buf.String())
+
+	mdCache.Put(key, html)
+	return html
+}
+
+// Small LRU cache of rendered Markdown keyed by the source hash.
+type markdownCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // front = most recently used
+	items map[[32]byte]*list.Element
+}
+
+type mdEntry struct {
+	key  [32]byte
+	html string
+}
+
+// Markdown cache constructor.
+func newMarkdownCache(size int) *markdownCache {
+	return &markdownCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[[32]byte]*list.Element),
+	}
+}
+
+func (c *markdownCache) Get(key [32]byte) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*mdEntry).html, true
+}
+
+func (c *markdownCache) Put(key [32]byte, html string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&mdEntry{key: key, html: html})
+	// Evict least recently used
+	if c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*mdEntry).key)
+	}
+}
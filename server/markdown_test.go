@@ -0,0 +1,129 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+// Rendered posts and comments are inserted unescaped, nothing able to run
+// script may get through.
+func TestRenderMarkdownSanitizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    []string // Substrings of the output
+		notWant []string // Case-insensitive
+	}{
+		{
+			name:    "script tag",
+			src:     "Hello <script>alert(1)</script>",
+			want:    []string{"Hello"}, // The inline tags are dropped, their text is inert
+			notWant: []string{"<script"},
+		},
+		{
+			name:    "script block",
+			src:     "<script>\nalert(1)\n</script>",
+			notWant: []string{"<script", "alert"},
+		},
+		{
+			name:    "javascript link",
+			src:     "[click](javascript:alert(1))",
+			want:    []string{"click"},
+			notWant: []string{"javascript:", "href"},
+		},
+		{
+			name:    "mixed case javascript link",
+			src:     "[click](JaVaScRiPt:alert(1))",
+			notWant: []string{"javascript:", "href"},
+		},
+		{
+			name:    "javascript autolink",
+			src:     "<javascript:alert(1)>",
+			notWant: []string{"href"},
+		},
+		{
+			name:    "event handler attribute",
+			src:     `<a href="https://example.com" onclick="alert(1)">y</a>`,
+			want:    []string{"y"},
+			notWant: []string{"onclick", "alert"},
+		},
+		{
+			name:    "raw html image",
+			src:     `<img src="https://evil.example.com/x.png" onerror="alert(1)">`,
+			notWant: []string{"<img", "evil.example.com", "onerror"},
+		},
+		{
+			name:    "javascript image",
+			src:     "![x](javascript:alert(1))",
+			notWant: []string{"javascript:"},
+		},
+		{
+			name:    "style attribute",
+			src:     `<span style="position:fixed">x</span>`,
+			notWant: []string{"style"},
+		},
+		{
+			name: "markdown image",
+			src:  "![cat](https://example.com/cat.png)",
+			want: []string{`<img src="https://example.com/cat.png" alt="cat">`},
+		},
+		{
+			name: "external link",
+			src:  "[site](https://example.com)",
+			want: []string{`href="https://example.com"`, `target="_blank"`, "noreferrer", "nofollow"},
+		},
+		{
+			name: "highlighted code",
+			src:  "```go\nfunc main() {}\n```",
+			want: []string{`<pre class="chroma">`, `<span class="kd">func</span>`, `<span class="nf">main</span>`},
+		},
+		{
+			name:    "code keeps its text escaped",
+			src:     "`<script>alert(1)</script>`",
+			want:    []string{"<code>&lt;script&gt;alert(1)&lt;/script&gt;</code>"},
+			notWant: []string{"<script"},
+		},
+		{
+			name:    "class outside code",
+			src:     `<p class="chroma">x</p>`,
+			notWant: []string{"class"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RenderMarkdown(tt.src)
+			for _, s := range tt.want {
+				if !strings.Contains(got, s) {
+					t.Errorf("RenderMarkdown(%q) = %q, want it to contain %q", tt.src, got, s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(strings.ToLower(got), strings.ToLower(s)) {
+					t.Errorf("RenderMarkdown(%q) = %q, must not contain %q", tt.src, got, s)
+				}
+			}
+			// Cached results are the same
+			if again := RenderMarkdown(tt.src); again != got {
+				t.Errorf("cached RenderMarkdown(%q) = %q, want %q", tt.src, again, got)
+			}
+		})
+	}
+}
+
+func TestMarkdownCacheEviction(t *testing.T) {
+	c := newMarkdownCache(2)
+	key := func(b byte) [32]byte { return [32]byte{b} }
+	c.Put(key(1), "one")
+	c.Put(key(2), "two")
+	c.Get(key(1)) // Most recently used
+	c.Put(key(3), "three")
+
+	if _, ok := c.Get(key(2)); ok {
+		t.Error("least recently used entry not evicted")
+	}
+	for _, k := range []byte{1, 3} {
+		if _, ok := c.Get(key(k)); !ok {
+			t.Errorf("entry %d evicted", k)
+		}
+	}
+}
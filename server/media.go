@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Folder of the local store, also where uploads were kept before MediaStore.
+const uploadsDir = "./static/uploads"
+
+// Max duration of a write or delete on a remote store.
+const mediaTimeout = 30 * time.Second
+
+// Where uploads are kept, set from MEDIA_STORE by Initialise.
+var Media MediaStore = LocalStore{Dir: uploadsDir}
+
+var ErrMediaNotFound = errors.New("media not found")
+
+// Storage of uploaded files, addressed by flat names (e.g. "<uuid>.jpg").
+type MediaStore interface {
+	Put(name string, data []byte) error
+	// ErrMediaNotFound when missing.
+	Get(name string) (io.ReadSeekCloser, error)
+	// No error when missing.
+	Delete(name string) error
+	// Where clients can fetch the file.
+	URL(name string) string
+}
+
+// Names are single path elements, never paths.
+func validMediaName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// Files in a local folder, served by UploadsHandler.
+type LocalStore struct {
+	Dir string
+}
+
+func (s LocalStore) Put(name string, data []byte) error {
+	if !validMediaName(name) {
+		return fmt.Errorf("invalid media name %q", name)
+	}
+	return os.WriteFile(filepath.Join(s.Dir, name), data, 0o644)
+}
+
+func (s LocalStore) Get(name string) (io.ReadSeekCloser, error) {
+	if !validMediaName(name) {
+		return nil, ErrMediaNotFound
+	}
+	f, err := os.Open(filepath.Join(s.Dir, name))
+	if os.IsNotExist(err) {
+		return nil, ErrMediaNotFound
+	}
+	return f, err
+}
+
+func (s LocalStore) Delete(name string) error {
+	if !validMediaName(name) {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(s.Dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Whether the file exists, without opening it.
+func (s LocalStore) Has(name string) bool {
+	if !validMediaName(name) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(s.Dir, name))
+	return err == nil
+}
+
+func (s LocalStore) URL(name string) string {
+	return "/uploads/" + url.PathEscape(name)
+}
+
+// Objects in an S3-compatible bucket (AWS, MinIO, R2...).
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	// Public address of the bucket (or its CDN) to redirect clients to,
+	// "" to serve the objects through the app.
+	publicURL string
+}
+
+// Connection settings of an S3Store.
+type S3Config struct {
+	Endpoint  string `toml:"endpoint"` // host[:port]
+	Bucket    string `toml:"bucket"`
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+	Region    string `toml:"region"`
+	UseSSL    bool   `toml:"use_ssl"`
+	PublicURL string `toml:"public_url"`
+}
+
+// Connect to the bucket, it's created when missing.
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaTimeout)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &S3Store{
+		client:    client,
+		bucket:    cfg.Bucket,
+		publicURL: strings.TrimSuffix(cfg.PublicURL, "/"),
+	}, nil
+}
+
+func (s *S3Store) Put(name string, data []byte) error {
+	if !validMediaName(name) {
+		return fmt.Errorf("invalid media name %q", name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mediaTimeout)
+	defer cancel()
+	_, err := s.client.PutObject(ctx, s.bucket, name, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: mime.TypeByExtension(filepath.Ext(name)),
+	})
+	return err
+}
+
+func (s *S3Store) Get(name string) (io.ReadSeekCloser, error) {
+	if !validMediaName(name) {
+		return nil, ErrMediaNotFound
+	}
+	// The object is read lazily, after Get returns
+	obj, err := s.client.GetObject(context.Background(), s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrMediaNotFound
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *S3Store) Delete(name string) error {
+	if !validMediaName(name) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mediaTimeout)
+	defer cancel()
+	// Deleting a missing object isn't an error in S3
+	return s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Store) URL(name string) string {
+	if s.publicURL == "" {
+		return "/uploads/" + url.PathEscape(name)
+	}
+	return s.publicURL + "/" + url.PathEscape(name)
+}
+
+// Writes to Current, while the files left on disk in Legacy stay readable,
+// to switch stores without moving existing uploads first.
+type FallbackStore struct {
+	Current MediaStore
+	Legacy  LocalStore
+}
+
+func (s FallbackStore) Put(name string, data []byte) error {
+	return s.Current.Put(name, data)
+}
+
+func (s FallbackStore) Get(name string) (io.ReadSeekCloser, error) {
+	f, err := s.Current.Get(name)
+	if err == ErrMediaNotFound {
+		return s.Legacy.Get(name)
+	}
+	return f, err
+}
+
+func (s FallbackStore) Delete(name string) error {
+	if err := s.Current.Delete(name); err != nil {
+		return err
+	}
+	return s.Legacy.Delete(name)
+}
+
+func (s FallbackStore) URL(name string) string {
+	// A stat only, URLs are resolved for every image of a listing
+	if s.Legacy.Has(name) {
+		return s.Legacy.URL(name)
+	}
+	return s.Current.URL(name)
+}
+
+// Select the media store from the config ("local" by default, or "s3").
+func initialiseMedia() {
+	local := LocalStore{Dir: uploadsDir}
+	if Conf.Media.Store != "s3" {
+		Media = local
+		return
+	}
+	s3, err := NewS3Store(Conf.Media.S3)
+	if err != nil {
+		log.Fatal("Failed to connect to the media bucket: ", err)
+	}
+	// Uploads made before the switch (and the default avatar) stay on disk
+	Media = FallbackStore{Current: s3, Legacy: local}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// S3 store of the bucket given by the S3_TEST_* env vars, e.g. a local
+// MinIO (see the README), the test is skipped without S3_TEST_ENDPOINT.
+func testS3Store(t *testing.T, publicURL string) *S3Store {
+	t.Helper()
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT not set")
+	}
+	bucket := os.Getenv("S3_TEST_BUCKET")
+	if bucket == "" {
+		bucket = "forum-test"
+	}
+	s, err := NewS3Store(S3Config{
+		Endpoint:  endpoint,
+		Bucket:    bucket,
+		AccessKey: os.Getenv("S3_TEST_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_TEST_SECRET_KEY"),
+		Region:    os.Getenv("S3_TEST_REGION"),
+		UseSSL:    os.Getenv("S3_TEST_USE_SSL") == "true",
+		PublicURL: publicURL,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	return s
+}
+
+func readMedia(t *testing.T, s MediaStore, name string) []byte {
+	t.Helper()
+	f, err := s.Get(name)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("reading %q: %v", name, err)
+	}
+	return data
+}
+
+// Put, Get, Delete and URL on any store.
+func testMediaStore(t *testing.T, s MediaStore) {
+	name := "test-" + strings.ReplaceAll(t.Name(), "/", "-") + ".txt"
+	data := []byte("hello media")
+
+	if err := s.Put(name, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	t.Cleanup(func() { s.Delete(name) })
+
+	if got := readMedia(t, s, name); !bytes.Equal(got, data) {
+		t.Errorf("Get = %q, want %q", got, data)
+	}
+
+	// Served files are seeked by http.ServeContent
+	f, err := s.Get(name)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if _, err := f.Seek(6, io.SeekStart); err != nil {
+		t.Errorf("Seek: %v", err)
+	} else if rest, _ := io.ReadAll(f); string(rest) != "media" {
+		t.Errorf("read after Seek = %q, want %q", rest, "media")
+	}
+	f.Close()
+
+	if err := s.Delete(name); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(name); !errors.Is(err, ErrMediaNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrMediaNotFound", err)
+	}
+	if err := s.Delete(name); err != nil {
+		t.Errorf("Delete of a missing file: %v", err)
+	}
+
+	if err := s.Put("../escape.txt", data); err == nil {
+		t.Error("Put accepted a path as name")
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	s := LocalStore{Dir: t.TempDir()}
+	testMediaStore(t, s)
+
+	if got, want := s.URL("a b.png"), "/uploads/a%20b.png"; got != want {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	s := testS3Store(t, "")
+	testMediaStore(t, s)
+
+	// Served through the app without a public address
+	if got, want := s.URL("a.png"), "/uploads/a.png"; got != want {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+	public := testS3Store(t, "https://cdn.example.com/")
+	if got, want := public.URL("a.png"), "https://cdn.example.com/a.png"; got != want {
+		t.Errorf("public URL = %q, want %q", got, want)
+	}
+}
+
+// Files left in the legacy store stay readable, new ones go to Current.
+func testFallbackStore(t *testing.T, current MediaStore) {
+	legacy := LocalStore{Dir: t.TempDir()}
+	s := FallbackStore{Current: current, Legacy: legacy}
+
+	if err := legacy.Put("old.txt", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMedia(t, s, "old.txt"); string(got) != "old" {
+		t.Errorf("Get of a legacy file = %q, want %q", got, "old")
+	}
+	if got, want := s.URL("old.txt"), legacy.URL("old.txt"); got != want {
+		t.Errorf("URL of a legacy file = %q, want %q", got, want)
+	}
+
+	name := "new-" + strings.ReplaceAll(t.Name(), "/", "-") + ".txt"
+	if err := s.Put(name, []byte("new")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	t.Cleanup(func() { current.Delete(name) })
+	if legacy.Has(name) {
+		t.Error("Put wrote to the legacy store")
+	}
+	if got := readMedia(t, current, name); string(got) != "new" {
+		t.Errorf("Get from Current = %q, want %q", got, "new")
+	}
+	if got, want := s.URL(name), current.URL(name); got != want {
+		t.Errorf("URL of a new file = %q, want %q", got, want)
+	}
+
+	// Deleted from both
+	if err := s.Delete("old.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get("old.txt"); !errors.Is(err, ErrMediaNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrMediaNotFound", err)
+	}
+}
+
+func TestFallbackStore(t *testing.T) {
+	testFallbackStore(t, LocalStore{Dir: t.TempDir()})
+}
+
+func TestS3FallbackStore(t *testing.T) {
+	testFallbackStore(t, testS3Store(t, "https://cdn.example.com"))
+}
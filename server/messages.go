@@ -2,15 +2,18 @@ package server
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
+	"html"
 	"net/http"
 )
 
-const messagesLimit = 10
-
 // Returns all messages exchanged between the logged-in user and a selected user.
 func GetMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
 	currentUser, err := GetUser(r)
 	if err != nil {
 		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
@@ -23,14 +26,11 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse offset/limit from query
-	offsetStr := r.URL.Query().Get("offset")
-
-	var offset int
-	if offsetStr == "" {
-		offset = 0
-	} else {
-		fmt.Sscanf(offsetStr, "%d", &offset) // handle error if needed
+	// Cursor points to the oldest loaded message, offset kept for old clients
+	cursor, offset, legacy, err := ParsePaging(r)
+	if err != nil {
+		JsonError(w, "Invalid offset or cursor", http.StatusBadRequest, err)
+		return
 	}
 
 	selectedUser, err := GetUserByUsername(selectedUsername)
@@ -38,10 +38,24 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 		JsonError(w, "User not found", http.StatusNotFound, err)
 		return
 	}
+	blocked, err := IsBlocked(currentUser.ID, selectedUser.ID)
+	if err != nil {
+		JsonError(w, "Database error", http.StatusInternalServerError, err)
+		return
+	}
+	if blocked {
+		JsonError(w, "You can't message this user", http.StatusForbidden, ErrBlocked)
+		return
+	}
 
 	// We want the *newest* messages first, so we ORDER BY created_at DESC
 	// Then we LIMIT & OFFSET. Because we want them in ascending order
 	// in the UI, we'll reverse them after scanning.
+	keyset, keysetArgs := cursor.Where("m.created_at", "m.id")
+	args := []any{currentUser.ID, selectedUser.ID, selectedUser.ID, currentUser.ID}
+	args = append(args, keysetArgs...)
+	args = append(args, Conf.Limits.Messages+1, offset)
+
 	query := `
         SELECT m.id,
                u1.username AS sender,
@@ -53,13 +67,11 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
         JOIN users u2 ON m.receiver_id = u2.id
         WHERE ((m.sender_id = ? AND m.receiver_id = ?)
             OR (m.sender_id = ? AND m.receiver_id = ?))
-        ORDER BY m.id DESC
+          AND ` + keyset + `
+        ORDER BY m.created_at DESC, m.id DESC
         LIMIT ? OFFSET ?
     `
-	rows, err := DB.Query(query,
-		currentUser.ID, selectedUser.ID,
-		selectedUser.ID, currentUser.ID,
-		messagesLimit, offset)
+	rows, err := DB.Query(query, args...)
 	if err != nil {
 		JsonError(w, "Database error", http.StatusInternalServerError, err)
 		return
@@ -76,6 +88,11 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 		reverseOrder = append(reverseOrder, msg)
 	}
 
+	// next_cursor points to the oldest message of this page
+	reverseOrder, next := paginate(reverseOrder, Conf.Limits.Messages, func(m Message) Cursor {
+		return Cursor{CreatedAt: m.CreatedAt, ID: m.ID}
+	})
+
 	// Reverse them so the earliest is first, the newest is last
 	// i.e. ascending order by created_at
 	var messages []Message
@@ -83,8 +100,21 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 		messages = append(messages, reverseOrder[i])
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	// Link previews (cached, missing ones are fetched in background)
+	var links []string
+	for _, msg := range messages {
+		links = append(links, ExtractLinks(html.UnescapeString(msg.Content))...)
+	}
+	previews, err := LoadLinkPreviews(links)
+	if err != nil {
+		JsonError(w, "Database error", http.StatusInternalServerError, err)
+		return
+	}
+	for i := range messages {
+		messages[i].Previews = PreviewsOf(html.UnescapeString(messages[i].Content), previews)
+	}
+
+	WritePage(w, messages, next, legacy)
 }
 
 // Fetches a user by their username
@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -76,16 +77,24 @@ func MessageWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Deliver sent messages to their receiver.
+func subscribeMessages() {
+	Subscribe(func(e MessageSent) {
+		BroadcastMessage(e.SenderID, e.ReceiverID, e.Content, e.Previews)
+	})
+}
+
 // Broadcast message to the receiver if they're online
-func BroadcastMessage(senderID int, receiverID int, content string) {
+func BroadcastMessage(senderID int, receiverID int, content string, previews []LinkPreview) {
 	connMutex.Lock()
 	receiverConns, online := connections[receiverID]
 	connMutex.Unlock()
 
 	if online {
-		msg := map[string]string{
-			"sender":  GetUsername(senderID),
-			"content": content,
+		msg := map[string]any{
+			"sender":   GetUsername(senderID),
+			"content":  content,
+			"previews": previews,
 		}
 
 		msgJSON, _ := json.Marshal(msg)
@@ -99,6 +108,10 @@ func BroadcastMessage(senderID int, receiverID int, content string) {
 
 // BroadcastTyping sends a typing notification to the receiver
 func BroadcastTyping(senderID int, receiverID int, isTyping bool) {
+	if blocked, err := IsBlocked(senderID, receiverID); err != nil || blocked {
+		return
+	}
+
 	connMutex.Lock()
 	receiverConns, online := connections[receiverID]
 	senderUsername := GetUsername(senderID)
@@ -121,6 +134,11 @@ func BroadcastTyping(senderID int, receiverID int, isTyping bool) {
 
 // Uses WebSocket for real-time messaging
 func SendMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
 	user, err := GetUser(r)
 	if err != nil {
 		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
@@ -150,22 +168,47 @@ func SendMessage(w http.ResponseWriter, r *http.Request) {
 		JsonError(w, "User not found", http.StatusNotFound, err)
 		return
 	}
+	blocked, err := IsBlocked(user.ID, receiver.ID)
+	if err != nil {
+		JsonError(w, "Failed to save message", http.StatusInternalServerError, err)
+		return
+	}
+	if blocked {
+		JsonError(w, "You can't message this user", http.StatusForbidden, ErrBlocked)
+		return
+	}
 
 	// Store message in DB
-	_, err = DB.Exec(`INSERT INTO messages (sender_id, receiver_id, content) VALUES (?, ?, ?)`,
+	res, err := DB.Exec(`INSERT INTO messages (sender_id, receiver_id, content) VALUES (?, ?, ?)`,
 		user.ID, receiver.ID, msgPayload.Content)
 	if err != nil {
 		JsonError(w, "Failed to save message", http.StatusInternalServerError, err)
 		return
 	}
+	messageID, _ := res.LastInsertId()
 
-	// Notify receiver if online
-	BroadcastMessage(user.ID, receiver.ID, msgPayload.Content)
+	// Only links already unfurled have a preview yet, content is escaped
+	text := html.UnescapeString(msgPayload.Content)
+	cached, err := LoadLinkPreviews(ExtractLinks(text))
+	if err != nil {
+		Logger(r).Error("Failed to load link previews", "error", err)
+	}
+	previews := PreviewsOf(text, cached)
+
+	Publish(MessageSent{
+		MessageID:  messageID,
+		SenderID:   user.ID,
+		ReceiverID: receiver.ID,
+		Content:    msgPayload.Content,
+		Previews:   previews,
+		CreatedAt:  time.Now().UTC(),
+	})
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"msg":     "Message sent successfully",
-		"content": msgPayload.Content,
+	json.NewEncoder(w).Encode(map[string]any{
+		"msg":      "Message sent successfully",
+		"content":  msgPayload.Content,
+		"previews": previews,
 	})
 }
 
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Schema change that schema.sql ("IF NOT EXISTS") can't apply to an existing database.
+type Migration struct {
+	Name string
+	Up   func(tx *sql.Tx) error
+}
+
+// Run once each, in order. Never edit or reorder an applied migration.
+var migrations = []Migration{
+	{"notifications_post_type", migrateNotificationsPostType},
+	{"users_is_admin", migrateUsersIsAdmin},
+	{"post_media", migratePostMedia},
+	{"users_is_bot", migrateUsersIsBot},
+}
+
+// Apply pending migrations, each in its own transaction.
+func migrateDB() error {
+	for _, m := range migrations {
+		var applied bool
+		err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE name = ?)`, m.Name).Scan(&applied)
+		if err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+
+		tx, err := DB.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.Up(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (name) VALUES (?)`, m.Name); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Allow the "post" notification type, a CHECK constraint can only change
+// by rebuilding the table.
+func migrateNotificationsPostType(tx *sql.Tx) error {
+	var schema string
+	err := tx.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'notifications'`).Scan(&schema)
+	if err != nil {
+		return err
+	}
+	// Created from the current schema.sql
+	if strings.Contains(schema, "'post'") {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+        CREATE TABLE notifications_new (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            actor_id INTEGER NOT NULL,
+            post_id INTEGER DEFAULT NULL,
+            type TEXT NOT NULL CHECK (type IN ('like', 'dislike', 'comment', 'post')),
+            read_status INTEGER DEFAULT 0,
+            created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+            FOREIGN KEY (actor_id) REFERENCES users (id) ON DELETE CASCADE,
+            FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+        );
+
+        INSERT INTO notifications_new (id, user_id, actor_id, post_id, type, read_status, created_at)
+        SELECT id, user_id, actor_id, post_id, type, read_status, created_at FROM notifications;
+
+        DROP TABLE notifications;
+
+        ALTER TABLE notifications_new RENAME TO notifications;`)
+	return err
+}
+
+// Add the admin flag, granted by hand:
+// UPDATE users SET is_admin = 1 WHERE username = '...';
+func migrateUsersIsAdmin(tx *sql.Tx) error {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM pragma_table_info('users') WHERE name = 'is_admin')`).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE users ADD COLUMN is_admin INTEGER NOT NULL DEFAULT 0`)
+	return err
+}
+
+// Move single post and draft images into galleries. Their alt text
+// is unknown, new images require one.
+func migratePostMedia(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        INSERT OR IGNORE INTO post_media (post_id, position, name, alt)
+        SELECT id, 0, image, '' FROM posts WHERE image != ''`)
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM pragma_table_info('post_drafts') WHERE name = 'media')`).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if _, err := tx.Exec(`ALTER TABLE post_drafts ADD COLUMN media TEXT NOT NULL DEFAULT '[]'`); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`
+        UPDATE post_drafts
+        SET media = json_array(json_object('name', image, 'alt', '')), image = ''
+        WHERE image != ''`)
+	return err
+}
+
+// Add the bot flag, set by users on their own account.
+func migrateUsersIsBot(tx *sql.Tx) error {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM pragma_table_info('users') WHERE name = 'is_bot')`).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE users ADD COLUMN is_bot INTEGER NOT NULL DEFAULT 0`)
+	return err
+}
@@ -4,14 +4,13 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"io"
 	"net/http"
-	"os"
-	"regexp"
-
-	"github.com/gofrs/uuid"
+	"strings"
+	"time"
 )
 
 const (
@@ -20,9 +19,36 @@ const (
 	maxTitleSize      = 700
 	maxContentSize    = 10000
 	maxCategoriesSize = 1000
-	maxImageSize      = 20 * 1024 * 1024 // 20MB (20 << 20)
+	maxImagesSize     = 50 * 1024 * 1024 // All images of a post
+	maxPostImages     = 10
+	maxAltSize        = 300
+	maxAltsSize       = 4000
+	maxPollSize       = 4000
 )
 
+// Values read from the new post multipart form.
+type PostForm struct {
+	Title       string // HTML escaped by Validate
+	Content     string // Markdown source
+	Categories  []string
+	Images      [][]byte    // Uploaded, in gallery order
+	Media       []PostMedia // Saved images, in gallery order
+	Alts        []string    // One per image, HTML escaped by Validate
+	Poll        *PollInput  // Optional
+	PublishAt   *time.Time  // Drafts only, optional
+	RemoveImage bool        // Drafts only
+	RemovePoll  bool        // Drafts only
+	Unschedule  bool        // Drafts only, clears PublishAt
+
+	sent        map[string]bool // Names of the parts sent
+	categoryIDs []int64         // Filled by Validate
+}
+
+// Whether the form had a part of that name, autosaves only send what changed.
+func (f *PostForm) Sent(name string) bool {
+	return f.sent[name]
+}
+
 // Handle adding a new post to DB.
 func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -30,7 +56,7 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	safeTitle, safeContent, categories, imageB, quit := LimitRequestBody(w, r)
+	form, quit := LimitRequestBody(w, r)
 	if quit {
 		return
 	}
@@ -41,55 +67,123 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var imagePath string
-	// Upload image to the server.
-	if len(imageB) > 0 {
-		if isSVG(imageB) {
-			JsonError(w, "svg images aren't supported", http.StatusUnauthorized, nil)
+	// Upload images to the server.
+	for i, image := range form.Images {
+		name, err := SaveImg(image)
+		if errors.Is(err, ErrImage) {
+			JsonError(w, fmt.Sprintf("Image %d: %v", i+1, err), http.StatusBadRequest, err)
 			return
 		}
-		imagePath, err = SaveImg(imageB)
 		if err != nil {
 			JsonError(w, "Failed to create post", http.StatusInternalServerError, err)
 			return
 		}
+		form.Media = append(form.Media, PostMedia{Name: name})
+	}
+
+	postID, err := PublishPost(user.ID, form)
+	if err != nil {
+		JsonError(w, "Failed to create post", http.StatusInternalServerError, err)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int64{"post_id": postID})
+}
 
-	// Insert post
-	res, err := DB.Exec(`
+// Insert a validated post with its categories and poll.
+// Used by new posts and published drafts.
+func PublishPost(userID int, form *PostForm) (int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	postID, err := insertPost(tx, userID, form)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	Publish(PostCreated{PostID: postID, AuthorID: userID, Content: form.Content})
+	return postID, nil
+}
+
+func insertPost(tx *sql.Tx, userID int, form *PostForm) (int64, error) {
+	var cover string
+	if len(form.Media) > 0 {
+		cover = form.Media[0].Name
+	}
+	res, err := tx.Exec(`
 	INSERT INTO posts (user_id, title, content, image)
 	VALUES (?, ?, ?, ?)`,
-		user.ID, safeTitle, safeContent, imagePath,
+		userID, form.Title, form.Content, cover,
 	)
 	if err != nil {
-		JsonError(w, "Failed to create post", http.StatusInternalServerError, err)
-		return
+		return 0, err
 	}
 	postID, err := res.LastInsertId()
 	if err != nil {
-		JsonError(w, "Failed to retrieve post ID", http.StatusInternalServerError, err)
-		return
+		return 0, err
 	}
 
 	// Insert categories in join table.
-	if quit := InsertCategories(w, postID, categories); quit {
-		return
+	for _, categoryID := range form.categoryIDs {
+		_, err = tx.Exec(`
+            INSERT INTO post_categories (post_id, category_id) 
+            VALUES (?, ?) 
+        `, postID, categoryID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to link category: %w", err)
+		}
 	}
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte("Post created successfully"))
+
+	// Gallery, in the uploaded order.
+	for i, m := range form.Media {
+		_, err = tx.Exec(`
+            INSERT INTO post_media (post_id, position, name, alt)
+            VALUES (?, ?, ?, ?)`,
+			postID, i, m.Name, form.Alts[i],
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to attach image: %w", err)
+		}
+	}
+
+	// Attach the optional poll.
+	if form.Poll != nil {
+		if err := InsertPoll(tx, postID, form.Poll); err != nil {
+			return 0, fmt.Errorf("failed to create poll: %w", err)
+		}
+	}
+	return postID, nil
 }
 
-// Check form values and Limit their readers one by one.
-func LimitRequestBody(w http.ResponseWriter, r *http.Request) (string, string, []string, []byte, bool) {
+// Read the new post form and check it's ready to be published.
+func LimitRequestBody(w http.ResponseWriter, r *http.Request) (*PostForm, bool) {
+	form, quit := ReadPostForm(w, r)
+	if quit {
+		return nil, true
+	}
+	if err := form.Validate(); err != nil {
+		JsonError(w, err.Error(), http.StatusBadRequest, err)
+		return nil, true
+	}
+	return form, false
+}
+
+// Limit the form values readers one by one, values are not validated.
+func ReadPostForm(w http.ResponseWriter, r *http.Request) (*PostForm, bool) {
 	mr, err := r.MultipartReader()
 	if err != nil {
 		JsonError(w, "Invalid form values", http.StatusBadRequest, err)
-		return "", "", nil, nil, true
+		return nil, true
 	}
 
-	var titleB, contentB, catJson, imageB []byte
-	var categories []string
-
+	form := PostForm{sent: make(map[string]bool)}
+	var imagesSize int
 	for {
 		part, err := mr.NextPart()
 		if err == io.EOF {
@@ -97,33 +191,36 @@ func LimitRequestBody(w http.ResponseWriter, r *http.Request) (string, string, [
 		}
 		if err != nil {
 			JsonError(w, "Error reading form part", http.StatusInternalServerError, err)
-			return "", "", nil, nil, true
+			return nil, true
 		}
 
+		form.sent[part.FormName()] = true
 		switch part.FormName() {
 		case "title":
-			titleB, err = LimitRead(part, maxTitleSize)
+			titleB, err := LimitRead(part, maxTitleSize)
 			if err != nil {
 				JsonError(w, "Title is too big", http.StatusBadRequest, err)
-				return "", "", nil, nil, true
+				return nil, true
 			}
+			form.Title = string(titleB)
 		case "content":
-			contentB, err = LimitRead(part, maxContentSize)
+			contentB, err := LimitRead(part, maxContentSize)
 			if err != nil {
 				JsonError(w, fmt.Sprintf("Content exceeded max length of %d characters", maxContentSize), http.StatusBadRequest, err)
-				return "", "", nil, nil, true
+				return nil, true
 			}
+			form.Content = string(contentB)
 		case "categories":
-			catJson, err = LimitRead(part, maxCategoriesSize)
+			catJson, err := LimitRead(part, maxCategoriesSize)
 			if err != nil {
 				JsonError(w, fmt.Sprintf("Categories exceed max length of %d", maxCategoriesSize), http.StatusBadRequest, err)
-				return "", "", nil, nil, true
+				return nil, true
 			}
 			if len(catJson) > 0 {
-				err = json.Unmarshal([]byte(catJson), &categories)
+				err = json.Unmarshal([]byte(catJson), &form.Categories)
 				if err != nil {
 					JsonError(w, "Invalid categories format", http.StatusBadRequest, err)
-					return "", "", nil, nil, true
+					return nil, true
 				}
 			}
 		case "image":
@@ -131,37 +228,128 @@ func LimitRequestBody(w http.ResponseWriter, r *http.Request) (string, string, [
 			contentType := part.Header.Get("Content-Type")
 			if !(len(contentType) > 6 && contentType[:6] == "image/") {
 				JsonError(w, "Invalid image content type", http.StatusBadRequest, fmt.Errorf("content type: %s", contentType))
-				return "", "", nil, nil, true
+				return nil, true
+			}
+
+			if len(form.Images) == maxPostImages {
+				JsonError(w, fmt.Sprintf("You can add up to %d images", maxPostImages), http.StatusBadRequest, nil)
+				return nil, true
 			}
 
 			// Read the image data
-			imageB, err = LimitRead(part, maxImageSize)
+			image, err := LimitRead(part, Conf.Limits.MaxImageSize)
 			if err != nil {
-				JsonError(w, "Image exceeded max size of 20mb.", http.StatusBadRequest, err)
-				return "", "", nil, nil, true
+				JsonError(w, "Image exceeded max size of "+formatSize(Conf.Limits.MaxImageSize)+".", http.StatusBadRequest, err)
+				return nil, true
 			}
+			imagesSize += len(image)
+			if imagesSize > maxImagesSize {
+				JsonError(w, "Images exceeded max total size of "+formatSize(maxImagesSize)+".", http.StatusBadRequest, nil)
+				return nil, true
+			}
+			form.Images = append(form.Images, image)
+		case "alts":
+			altsJson, err := LimitRead(part, maxAltsSize)
+			if err != nil {
+				JsonError(w, "Alt texts are too big", http.StatusBadRequest, err)
+				return nil, true
+			}
+			if len(altsJson) > 0 {
+				if err := json.Unmarshal(altsJson, &form.Alts); err != nil {
+					JsonError(w, "Invalid alt texts format, expected an array", http.StatusBadRequest, err)
+					return nil, true
+				}
+			}
+		case "poll":
+			pollJson, err := LimitRead(part, maxPollSize)
+			if err != nil {
+				JsonError(w, "Poll is too big", http.StatusBadRequest, err)
+				return nil, true
+			}
+			if len(pollJson) > 0 {
+				form.Poll = &PollInput{}
+				if err := json.Unmarshal(pollJson, form.Poll); err != nil {
+					JsonError(w, "Invalid poll format", http.StatusBadRequest, err)
+					return nil, true
+				}
+			}
+		case "publish_at":
+			value, err := LimitRead(part, 64)
+			if err != nil {
+				JsonError(w, "Invalid publish time", http.StatusBadRequest, err)
+				return nil, true
+			}
+			if len(value) > 0 {
+				t, err := time.Parse(time.RFC3339, string(value))
+				if err != nil {
+					JsonError(w, "Invalid publish time, expected RFC3339", http.StatusBadRequest, err)
+					return nil, true
+				}
+				form.PublishAt = &t
+			}
+		case "remove_image":
+			value, _ := LimitRead(part, 8)
+			form.RemoveImage = string(value) == "true"
+		case "remove_poll":
+			value, _ := LimitRead(part, 8)
+			form.RemovePoll = string(value) == "true"
+		case "unschedule":
+			value, _ := LimitRead(part, 8)
+			form.Unschedule = string(value) == "true"
 		}
 	}
-	title, content := string(titleB), string(contentB)
+	return &form, false
+}
 
-	if title == "" || content == "" {
-		JsonError(w, "Title and content are required", http.StatusBadRequest, nil)
-		return "", "", nil, nil, true
+// Check the form values, escape the title and alt texts and resolve the categories.
+// Returned errors are meant for the user.
+func (f *PostForm) Validate() error {
+	if f.Title == "" || f.Content == "" {
+		return fmt.Errorf("Title and content are required")
 	}
-	if len(title) < 4 {
-		JsonError(w, "Title is too short", http.StatusBadRequest, nil)
-		return "", "", nil, nil, true
+	if len(f.Title) < 4 {
+		return fmt.Errorf("Title is too short")
+	}
+	if len(f.Content) < 6 {
+		return fmt.Errorf("Post content is too short")
+	}
+	if f.Poll != nil {
+		if err := f.Poll.Validate(); err != nil {
+			return err
+		}
 	}
-	if len(content) < 6 {
-		JsonError(w, "Post content is too short", http.StatusBadRequest, nil)
-		return "", "", nil, nil, true
+
+	// Every image needs its alt text, in the same order.
+	images := len(f.Images)
+	if images == 0 {
+		images = len(f.Media)
+	}
+	if images > maxPostImages {
+		return fmt.Errorf("You can add up to %d images", maxPostImages)
+	}
+	if len(f.Alts) != images {
+		return fmt.Errorf("Each image needs an alt text")
+	}
+	for i, alt := range f.Alts {
+		alt = strings.TrimSpace(alt)
+		if alt == "" {
+			return fmt.Errorf("Image %d needs an alt text", i+1)
+		}
+		if len(alt) > maxAltSize {
+			return fmt.Errorf("Alt text of image %d exceeds %d characters", i+1, maxAltSize)
+		}
+		f.Alts[i] = html.EscapeString(alt)
 	}
 
-	// remove consecutive more than three consecutive new lines to just two.
-	re := regexp.MustCompile(`(\r\n|\r|\n){3,}`)
-	content = re.ReplaceAllString(content, "\n\n")
+	ids, err := CategoryIDs(f.Categories)
+	if err != nil {
+		return err
+	}
+	f.categoryIDs = ids
 
-	return html.EscapeString(title), html.EscapeString(content), categories, imageB, false
+	// Content is Markdown source, sanitized when rendered (RenderMarkdown).
+	f.Title = html.EscapeString(f.Title)
+	return nil
 }
 
 // LimitRead reads the entire stream from `part` and limits the size to maxSize bytes.
@@ -179,21 +367,34 @@ func LimitRead(part io.Reader, maxSize int) ([]byte, error) {
 
 	// If we read more than maxSize bytes, the data is too large.
 	if n > int64(maxSize) {
-		return nil, fmt.Errorf("data exceeds max allowed size of 1mb")
+		return nil, fmt.Errorf("data exceeds max allowed size of %s", formatSize(maxSize))
 	}
 
 	// Return the full data (up to maxSize).
 	return buf.Bytes(), nil
 }
 
-// Check if the categories in the post payload are present in categories Table.
-// And Insert them into post_categories join table
-func InsertCategories(w http.ResponseWriter, postID int64, categories []string) bool {
+// Size limit for messages, e.g. "20mb".
+func formatSize(n int) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dmb", n>>20)
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fmb", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%dkb", n>>10)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// Check if the categories in the post payload are present in categories Table,
+// and return their IDs to insert into post_categories join table.
+func CategoryIDs(categories []string) ([]int64, error) {
 	if len(categories) > 3 {
-		JsonError(w, "You can select only up to 3 categories", http.StatusBadRequest, nil)
-		return true
+		return nil, fmt.Errorf("You can select only up to 3 categories")
 	}
 
+	var ids []int64
 	for _, category := range categories {
 		var categoryID int64
 
@@ -206,54 +407,11 @@ func InsertCategories(w http.ResponseWriter, postID int64, categories []string)
 
 		// If the category does not exist
 		if err == sql.ErrNoRows {
-			JsonError(w, fmt.Sprintf("Category %s not found.", category), http.StatusBadRequest, err)
-			return true
+			return nil, fmt.Errorf("Category %s not found.", category)
 		} else if err != nil {
-			JsonError(w, "Failed to find category", http.StatusInternalServerError, err)
-			return true
-		}
-
-		// Insert into post_categories join table
-		_, err = DB.Exec(`
-            INSERT INTO post_categories (post_id, category_id) 
-            VALUES (?, ?) 
-        `, postID, categoryID)
-		if err != nil {
-			JsonError(w, "failed to link category.", http.StatusInternalServerError, err)
-			return true
+			return nil, fmt.Errorf("Failed to find category")
 		}
+		ids = append(ids, categoryID)
 	}
-	return false
-}
-
-// Save image and return uuid path to insert in DB.
-// Return only Image name
-func SaveImg(imageB []byte) (string, error) {
-	imguuid, err := uuid.NewV4()
-	if err != nil {
-		return "", err
-	}
-	imgSavingPath := "./static/uploads/" + imguuid.String() + ".jpg"
-
-	err = os.WriteFile(imgSavingPath, imageB, 0o644)
-	if err != nil {
-		return "", err
-	}
-
-	imgServingPath := imguuid.String() + ".jpg"
-
-	return imgServingPath, nil
-}
-
-// Check if an image is svg type.
-func isSVG(imageB []byte) bool {
-	// Remove unnecessary leading characters
-	trimmed := bytes.TrimLeft(imageB, " \t\n\r\xef\xbb\xbf")
-	if len(trimmed) < 4 {
-		return false // Can't be an SVG
-	}
-	// Case-insensitive comparison
-	lower := bytes.ToLower(trimmed)
-	return bytes.HasPrefix(lower, []byte("<?xml")) ||
-		bytes.Contains(lower, []byte("<svg"))
+	return ids, nil
 }
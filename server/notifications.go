@@ -4,11 +4,15 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 )
 
+// Num of notifications on each scroll load.
+const notifLimit = 15
+
 // Get user's corresponding notifications.
 func GetNotifications(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -22,13 +26,19 @@ func GetNotifications(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get offset from query parameter (default 0 if invalid or missing)
-	offsetParam := r.URL.Query().Get("offset")
-	offset, err := strconv.Atoi(offsetParam)
-	if err != nil || offset < 0 {
-		offset = 0
+	// Cursor (keyset) pagination, "offset" is kept for older clients.
+	cursor, offset, legacy, err := ParsePaging(r)
+	if err != nil {
+		JsonError(w, "Invalid cursor or offset", http.StatusBadRequest, err)
+		return
 	}
-	const limit = 15 // Fetch 15 notifications at a time
+
+	keyset, args := cursor.Where("n.created_at", "n.id")
+	visible, visibleArgs := VisibleTo(user.ID, "n.actor_id")
+	args = append([]any{user.ID}, args...)
+	args = append(args, visibleArgs...)
+	// Fetch one extra row to know if there is a next page.
+	args = append(args, notifLimit+1, offset)
 
 	rows, err := DB.Query(`
         SELECT 
@@ -41,10 +51,10 @@ func GetNotifications(w http.ResponseWriter, r *http.Request) {
 			COALESCE(n.read_status, 0) AS read_status
         FROM notifications n
         JOIN users a ON n.actor_id = a.id
-        WHERE n.user_id = ?
-        ORDER BY n.created_at DESC
+        WHERE n.user_id = ? AND `+keyset+` AND `+visible+`
+        ORDER BY n.created_at DESC, n.id DESC
         LIMIT ? OFFSET ?
-    `, user.ID, limit, offset)
+    `, args...)
 	if err != nil {
 		JsonError(w, "Failed to query notifications", http.StatusInternalServerError, err)
 		return
@@ -97,14 +107,10 @@ func GetNotifications(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(notifs) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte("[]"))
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(notifs)
+	notifs, next := paginate(notifs, notifLimit, func(n Notification) Cursor {
+		return Cursor{CreatedAt: n.CreatedAt, ID: n.ID}
+	})
+	WritePage(w, notifs, next, legacy)
 }
 
 // Helper to build the "message" string
@@ -116,13 +122,42 @@ func buildNotification(notifType string) string {
 		return "disliked your post"
 	case "comment":
 		return "commented on your post"
+	case "post":
+		return "published a new post"
 	default:
 		return "reacted on your comment"
 	}
 }
 
+// Notify post owners of comments and reactions, and followers of new posts.
+// Reactions are notified in order, a toggle replaces the previous one.
+func subscribeNotifications() {
+	Subscribe(func(e CommentCreated) {
+		if e.PostOwnerID == e.Author.ID {
+			return
+		}
+		if err := InsertNotification(e.PostOwnerID, e.Author.ID, &e.PostID, "comment"); err != nil {
+			slog.Error("Failed to insert notification", "error", err)
+		}
+	})
+	Subscribe(func(e ReactionAdded) {
+		if e.OwnerID == e.User.ID || e.Target != "post" {
+			return
+		}
+		if err := InsertNotification(e.OwnerID, e.User.ID, &e.TargetID, e.Reaction); err != nil {
+			slog.Error("Failed to insert notification", "error", err)
+		}
+	})
+	SubscribeAsync(func(e PostCreated) { NotifyFollowers(e.AuthorID, e.PostID) })
+}
+
 // Insert a row in "notifications" for like/dislike on a post or comment
 func InsertNotification(ownerID, actorID int, postID *int, reactionType string) error {
+	// Nothing from users the owner muted or blocked
+	if hidden, err := IsHidden(ownerID, actorID); err != nil || hidden {
+		return err
+	}
+
 	if reactionType == "like" || reactionType == "dislike" {
 		// Delete any existing like/dislike notification for this (owner, actor, post)
 
@@ -187,6 +222,7 @@ func InsertNotification(ownerID, actorID int, postID *int, reactionType string)
 		CreatedAt:       time.Now(),
 	}
 	NotifyUser(notification) // Send real-time WS update
+	PushUnreadCount(ownerID)
 
 	return nil
 }
@@ -238,6 +274,7 @@ func MarkNotificationAsRead(w http.ResponseWriter, r *http.Request) {
 		JsonError(w, "Failed to mark notification as read", http.StatusInternalServerError, err)
 		return
 	}
+	PushUnreadCount(user.ID)
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"success": true, "message": "Notification marked as read"}`))
@@ -257,12 +294,7 @@ func GetUnreadNotificationCount(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Query the database for unread notifications count
-	var count int
-	err = DB.QueryRow(`
-		SELECT COUNT(*) 
-		FROM notifications 
-		WHERE user_id = ? AND (read_status = 0 OR read_status IS NULL)
-	`, user.ID).Scan(&count)
+	count, err := CountUnreadNotifications(user.ID)
 	if err != nil {
 		JsonError(w, "Failed to query notification count", http.StatusInternalServerError, err)
 		return
@@ -324,6 +356,7 @@ func DeleteNotification(w http.ResponseWriter, r *http.Request) {
 		JsonError(w, "Failed to delete notification", http.StatusInternalServerError, err)
 		return
 	}
+	PushUnreadCount(user.ID)
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Notification deleted successfully"))
@@ -348,7 +381,21 @@ func DeleteAllNotifications(w http.ResponseWriter, r *http.Request) {
 		JsonError(w, "Failed to delete notifications", http.StatusInternalServerError, err)
 		return
 	}
+	PushUnreadCount(user.ID)
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("All notifications deleted successfully"))
 }
+
+// Count the unread notifications of a user.
+func CountUnreadNotifications(userID int) (int, error) {
+	var count int
+	visible, args := VisibleTo(userID, "actor_id")
+	args = append([]any{userID}, args...)
+	err := DB.QueryRow(`
+		SELECT COUNT(*) 
+		FROM notifications 
+		WHERE user_id = ? AND (read_status = 0 OR read_status IS NULL) AND `+visible+`
+	`, args...).Scan(&count)
+	return count, err
+}
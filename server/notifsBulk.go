@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Max IDs accepted in a single bulk request.
+const maxBulkNotifs = 500
+
+// Allowed notification types (matches the notifications table CHECK).
+var NotifTypes = map[string]bool{
+	"like":    true,
+	"dislike": true,
+	"comment": true,
+	"post":    true,
+}
+
+// Expected JSON for bulk notification actions.
+// Select by IDs, by filter (type and/or before) or everything with All.
+type NotifBulkPayload struct {
+	IDs    []int  `json:"ids"`
+	Type   string `json:"type"`
+	Before string `json:"before"` // RFC3339 timestamp
+	All    bool   `json:"all"`
+}
+
+// Mark a list/filter of notifications as read (all=true for mark-all-read).
+func MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	bulkNotifications(w, r, http.MethodPost, `UPDATE notifications SET read_status = 1`)
+}
+
+// Mark a list/filter of notifications as unread.
+func MarkNotificationsUnread(w http.ResponseWriter, r *http.Request) {
+	bulkNotifications(w, r, http.MethodPost, `UPDATE notifications SET read_status = 0`)
+}
+
+// Delete a list/filter of notifications.
+func DeleteNotifications(w http.ResponseWriter, r *http.Request) {
+	bulkNotifications(w, r, http.MethodDelete, `DELETE FROM notifications`)
+}
+
+// Run a bulk statement restricted to the user's own notifications.
+func bulkNotifications(w http.ResponseWriter, r *http.Request, method, statement string) {
+	if r.Method != method {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	// Limit the size of the request body to 16 KB
+	r.Body = http.MaxBytesReader(w, r.Body, 16000)
+
+	var payload NotifBulkPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		JsonError(w, "Invalid request body", http.StatusBadRequest, err)
+		return
+	}
+
+	where, args, msg := notifBulkFilter(payload)
+	if msg != "" {
+		JsonError(w, msg, http.StatusBadRequest, nil)
+		return
+	}
+	args = append([]any{user.ID}, args...)
+
+	res, err := DB.Exec(statement+` WHERE user_id = ?`+where, args...)
+	if err != nil {
+		JsonError(w, "Failed to update notifications", http.StatusInternalServerError, err)
+		return
+	}
+	affected, _ := res.RowsAffected()
+	PushUnreadCount(user.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{
+		"affected": affected,
+	})
+}
+
+// Build the extra WHERE conditions of a bulk payload.
+// Returns a user facing message when the payload is invalid.
+func notifBulkFilter(payload NotifBulkPayload) (string, []any, string) {
+	var conds []string
+	var args []any
+
+	if len(payload.IDs) > maxBulkNotifs {
+		return "", nil, "Too many notifications selected"
+	}
+	if len(payload.IDs) > 0 {
+		placeholders := make([]string, len(payload.IDs))
+		for i, id := range payload.IDs {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		conds = append(conds, "id IN ("+strings.Join(placeholders, ",")+")")
+	}
+
+	if payload.Type != "" {
+		if !NotifTypes[payload.Type] {
+			return "", nil, "Invalid notification type"
+		}
+		conds = append(conds, "type = ?")
+		args = append(args, payload.Type)
+	}
+
+	if payload.Before != "" {
+		before, err := time.Parse(time.RFC3339, payload.Before)
+		if err != nil {
+			return "", nil, "Invalid before timestamp"
+		}
+		conds = append(conds, "created_at < ?")
+		args = append(args, before.UTC().Format(sqlTimeLayout))
+	}
+
+	// Nothing selected, refuse to touch everything by accident.
+	if len(conds) == 0 && !payload.All {
+		return "", nil, "No notifications selected"
+	}
+	if len(conds) == 0 {
+		return "", nil, ""
+	}
+	return " AND " + strings.Join(conds, " AND "), args, ""
+}
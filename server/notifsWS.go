@@ -1,9 +1,8 @@
 package server
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
-	"os"
 	"sync"
 
 	"github.com/gorilla/websocket"
@@ -16,10 +15,9 @@ var (
 
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
-		// Check if we are running on Fly.io (deployed environment)
-		if os.Getenv("FLY_APP_NAME") != "" {
-			origin := "https://dwi.fly.dev"
-			return r.Header.Get("Origin") == origin
+		// Only the site itself once its address is set
+		if Conf.SiteURL != "" {
+			return r.Header.Get("Origin") == Conf.SiteURL
 		}
 		// Allow all origins when running locally
 		return true
@@ -36,7 +34,7 @@ func NotificationSocket(w http.ResponseWriter, r *http.Request) {
 
 	conn, err := upgrader.Upgrade(w, r, nil) // Upgrade HTTP to WebSocket
 	if err != nil {
-		fmt.Println("WebSocket upgrade failed:", err)
+		Logger(r).Warn("WebSocket upgrade failed", "error", err)
 		return
 	}
 
@@ -77,7 +75,7 @@ func NotifyUserWithData(userID int, data interface{}) {
 	for conn := range clients[userID] {
 		err := conn.WriteJSON(data)
 		if err != nil {
-			fmt.Println("Error sending WebSocket message:", err)
+			slog.Error("Error sending WebSocket message", "error", err)
 			conn.Close()
 			delete(clients[userID], conn)
 		}
@@ -94,3 +92,16 @@ func NotifyUser(notification Notification) {
 func NotifyUserOfDeletion(deletion NotificationDeletion) {
 	NotifyUserWithData(deletion.UserID, deletion)
 }
+
+// Push the fresh unread count so every open tab can update its badge.
+func PushUnreadCount(userID int) {
+	count, err := CountUnreadNotifications(userID)
+	if err != nil {
+		slog.Error("Failed to count unread notifications", "error", err)
+		return
+	}
+	NotifyUserWithData(userID, UnreadCountEvent{
+		Action: "unread_count",
+		Count:  count,
+	})
+}
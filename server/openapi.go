@@ -0,0 +1,471 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Max JSON request body read for validation, handlers have their own limits.
+const maxAPIBody = 1 << 20
+
+// OpenAPI 3.0 document of the v1 API, built from apiRoutes.
+type OpenAPI struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       OpenAPIInfo                      `json:"info"`
+	Servers    []OpenAPIServer                  `json:"servers"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components OpenAPIComponents                `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Scheme      string `json:"scheme,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Tags        []string              `json:"tags"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	TokenScope  string                `json:"x-token-scope,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"` // "path" or "query"
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// JSON Schema subset of OpenAPI 3.0, also used to validate requests.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	MaxLength            int                `json:"maxLength,omitempty"`
+	MaxItems             int                `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+// Schema helpers for the routes table.
+func strSchema() *Schema  { return &Schema{Type: "string"} }
+func boolSchema() *Schema { return &Schema{Type: "boolean"} }
+func idSchema() *Schema   { return &Schema{Type: "integer", Minimum: intPtr(1)} }
+func intPtr(n int) *int   { return &n }
+
+func enumSchema(values ...string) *Schema { return &Schema{Type: "string", Enum: values} }
+func arraySchema(items *Schema, max int) *Schema {
+	return &Schema{Type: "array", Items: items, MaxItems: max}
+}
+
+// Object with its required properties listed first, marked by a "*" suffix.
+func objSchema(props ...any) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i+1 < len(props); i += 2 {
+		name := props[i].(string)
+		if n, ok := strings.CutSuffix(name, "*"); ok {
+			name = n
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = props[i+1].(*Schema)
+	}
+	return s
+}
+
+// Built once, the routes table doesn't change.
+var apiSpec = sync.OnceValue(buildOpenAPI)
+
+func buildOpenAPI() *OpenAPI {
+	spec := &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{
+			Title:   "dwi API",
+			Version: "1.0.0",
+			Description: "Successful responses are wrapped as {\"data\": ...}, errors as " +
+				"{\"error\": {\"code\", \"message\", \"details\"}}. Paginated lists are " +
+				"{\"items\", \"next_cursor\"} pages, pass next_cursor back as cursor.",
+		},
+		Servers: []OpenAPIServer{{URL: apiPrefix}},
+		Paths:   make(map[string]map[string]*Operation),
+		Components: OpenAPIComponents{
+			Schemas: map[string]*Schema{
+				"Error": objSchema(
+					"error*", objSchema(
+						"code*", strSchema(),
+						"message*", strSchema(),
+						"details", arraySchema(objSchema("field*", strSchema(), "problem*", strSchema()), 0),
+					),
+				),
+			},
+			SecuritySchemes: map[string]SecurityScheme{
+				"session": {Type: "apiKey", In: "cookie", Name: "session_token"},
+				"token":   {Type: "http", Scheme: "bearer", Description: "Personal access token, limited to the x-token-scope of each operation"},
+			},
+		},
+	}
+
+	for _, route := range apiRoutes {
+		if route.Path == "" {
+			continue
+		}
+		op := &Operation{
+			OperationID: route.Name,
+			Summary:     route.Summary,
+			Tags:        []string{route.Tag},
+			Parameters:  route.Params,
+			Responses:   make(map[string]Response),
+		}
+		if route.Auth {
+			op.Security = []map[string][]string{{"session": {}}}
+			if route.Scope != "" {
+				op.Security = append(op.Security, map[string][]string{"token": {}})
+			}
+		}
+		op.TokenScope = route.Scope
+		switch {
+		case route.Body != nil:
+			op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{
+				"application/json": {Schema: route.Body},
+			}}
+		case route.Form != nil:
+			op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{
+				"multipart/form-data": {Schema: route.Form},
+			}}
+		}
+
+		var response any = APIMessage{}
+		if route.Response != nil {
+			response = route.Response
+		}
+		data := schemaFor(reflect.TypeOf(response), spec.Components.Schemas)
+		if route.Paged {
+			data = objSchema("items*", data, "next_cursor", strSchema())
+		}
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		op.Responses[strconv.Itoa(status)] = Response{
+			Description: http.StatusText(status),
+			Content:     map[string]MediaType{"application/json": {Schema: objSchema("data*", data)}},
+		}
+		op.Responses["default"] = Response{
+			Description: "Error",
+			Content:     map[string]MediaType{"application/json": {Schema: &Schema{Ref: "#/components/schemas/Error"}}},
+		}
+
+		if spec.Paths[route.Path] == nil {
+			spec.Paths[route.Path] = make(map[string]*Operation)
+		}
+		spec.Paths[route.Path][strings.ToLower(route.Method)] = op
+	}
+	return spec
+}
+
+// Schema of a response type, named structs become components.
+func schemaFor(t reflect.Type, components map[string]*Schema) *Schema {
+	if t == reflect.TypeOf(time.Time{}) {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := schemaFor(t.Elem(), components)
+		if s.Ref != "" {
+			return &Schema{AllOf: []*Schema{s}, Nullable: true}
+		}
+		s.Nullable = true
+		return s
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: schemaFor(t.Elem(), components)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaFor(t.Elem(), components)}
+	case reflect.String:
+		return strSchema()
+	case reflect.Bool:
+		return boolSchema()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Struct:
+		ref := &Schema{Ref: "#/components/schemas/" + t.Name()}
+		if t.Name() != "" {
+			if _, ok := components[t.Name()]; ok {
+				return ref
+			}
+			// Placeholder first, for recursive types
+			components[t.Name()] = &Schema{}
+		}
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s.Properties[name] = schemaFor(f.Type, components)
+			if !strings.Contains(opts, "omitempty") {
+				s.Required = append(s.Required, name)
+			}
+		}
+		if t.Name() == "" {
+			return s
+		}
+		*components[t.Name()] = *s
+		return ref
+	}
+	// Interfaces: any JSON value
+	return &Schema{}
+}
+
+// Problem with a request, reported in error details.
+type APIProblem struct {
+	Field   string `json:"field"`
+	Problem string `json:"problem"`
+}
+
+// Check a request against its operation in the spec. JSON bodies are read
+// and put back for the handler.
+func validateRequest(r *http.Request, op *Operation) (problems []APIProblem, status int) {
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		value := query.Get(p.Name)
+		if value == "" {
+			if p.Required {
+				problems = append(problems, APIProblem{p.Name, "is required"})
+			}
+			continue
+		}
+		if problem := checkParam(p.Schema, value); problem != "" {
+			problems = append(problems, APIProblem{p.Name, problem})
+		}
+	}
+
+	if op.RequestBody == nil {
+		return problems, http.StatusBadRequest
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	for expected, content := range op.RequestBody.Content {
+		if expected == "multipart/form-data" {
+			// Streamed by the handlers, only the type is checked
+			if mediaType != expected {
+				return append(problems, APIProblem{"body", "must be multipart/form-data"}), http.StatusUnsupportedMediaType
+			}
+			continue
+		}
+		if mediaType != "" && mediaType != expected {
+			return append(problems, APIProblem{"body", "must be " + expected}), http.StatusUnsupportedMediaType
+		}
+		raw, err := io.ReadAll(io.LimitReader(r.Body, maxAPIBody+1))
+		r.Body.Close()
+		if err != nil || len(raw) > maxAPIBody {
+			return append(problems, APIProblem{"body", "is too large"}), http.StatusRequestEntityTooLarge
+		}
+		r.Body = io.NopCloser(bytes.NewReader(raw))
+
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var body any
+		if err := dec.Decode(&body); err != nil {
+			return append(problems, APIProblem{"body", "is not valid JSON"}), http.StatusBadRequest
+		}
+		problems = append(problems, checkValue(content.Schema, body, "body")...)
+	}
+	return problems, http.StatusBadRequest
+}
+
+// Check a query or path parameter, "" if valid.
+func checkParam(s *Schema, value string) string {
+	switch s.Type {
+	case "integer":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "must be an integer"
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Sprintf("must be at least %d", *s.Minimum)
+		}
+	case "boolean":
+		if value != "true" && value != "false" {
+			return "must be true or false"
+		}
+	case "string":
+		return checkString(s, value)
+	}
+	return ""
+}
+
+func checkString(s *Schema, value string) string {
+	if len(s.Enum) > 0 && !contains(s.Enum, value) {
+		return "must be one of " + strings.Join(s.Enum, ", ")
+	}
+	if s.MaxLength > 0 && len([]rune(value)) > s.MaxLength {
+		return fmt.Sprintf("must be at most %d characters", s.MaxLength)
+	}
+	if s.Format == "date-time" {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return "must be an RFC 3339 date-time"
+		}
+	}
+	return ""
+}
+
+// Check a decoded JSON value, field is its path for error details.
+func checkValue(s *Schema, v any, field string) []APIProblem {
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return []APIProblem{{field, "must not be null"}}
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return []APIProblem{{field, "must be an object"}}
+		}
+		var problems []APIProblem
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, APIProblem{field + "." + name, "is required"})
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.Properties[name]; ok {
+				problems = append(problems, checkValue(prop, obj[name], field+"."+name)...)
+			}
+		}
+		return problems
+
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return []APIProblem{{field, "must be an array"}}
+		}
+		if s.MaxItems > 0 && len(arr) > s.MaxItems {
+			return []APIProblem{{field, fmt.Sprintf("must have at most %d items", s.MaxItems)}}
+		}
+		var problems []APIProblem
+		for i, item := range arr {
+			problems = append(problems, checkValue(s.Items, item, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+		return problems
+
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return []APIProblem{{field, "must be an integer"}}
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return []APIProblem{{field, "must be an integer"}}
+		}
+		if s.Minimum != nil && i < int64(*s.Minimum) {
+			return []APIProblem{{field, fmt.Sprintf("must be at least %d", *s.Minimum)}}
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []APIProblem{{field, "must be true or false"}}
+		}
+
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return []APIProblem{{field, "must be a string"}}
+		}
+		if problem := checkString(s, str); problem != "" {
+			return []APIProblem{{field, problem}}
+		}
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Serve the OpenAPI document of the v1 API.
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiSpec())
+}
@@ -0,0 +1,406 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxPollQuestion = 300
+	maxPollOption   = 100
+	minPollOptions  = 2
+	maxPollOptions  = 10
+)
+
+// Poll sent along with the new post form ("poll" part, JSON).
+type PollInput struct {
+	Question  string     `json:"question"`
+	Options   []string   `json:"options"`
+	Multiple  bool       `json:"multiple"`
+	Anonymous bool       `json:"anonymous"`
+	ClosesAt  *time.Time `json:"closes_at"` // Optional, RFC3339
+}
+
+// Poll with its results.
+type Poll struct {
+	ID        int          `json:"id"`
+	PostID    int          `json:"post_id"`
+	Question  string       `json:"question"`
+	Multiple  bool         `json:"multiple"`
+	Anonymous bool         `json:"anonymous"`
+	ClosesAt  *time.Time   `json:"closes_at"`
+	Closed    bool         `json:"closed"`
+	Voters    int          `json:"voters"`
+	Options   []PollOption `json:"options"`
+	UserVotes []int        `json:"user_votes,omitempty"` // Option IDs picked by the viewer
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+type PollOption struct {
+	ID     int      `json:"id"`
+	Label  string   `json:"label"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters,omitempty"` // Usernames, public polls only
+}
+
+// Sent to the post viewers after each vote.
+type PollResultsEvent struct {
+	Action string `json:"action"` // "poll_results"
+	PostID int    `json:"post_id"`
+	Poll   *Poll  `json:"poll"`
+}
+
+// Trim, check and escape the poll values.
+func (p *PollInput) Validate() error {
+	p.Question = strings.TrimSpace(p.Question)
+	if p.Question == "" {
+		return fmt.Errorf("Poll question is required")
+	}
+	if len(p.Question) > maxPollQuestion {
+		return fmt.Errorf("Poll question exceeded max length of %d characters", maxPollQuestion)
+	}
+	if len(p.Options) < minPollOptions || len(p.Options) > maxPollOptions {
+		return fmt.Errorf("A poll needs between %d and %d options", minPollOptions, maxPollOptions)
+	}
+
+	seen := make(map[string]bool)
+	for i, opt := range p.Options {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			return fmt.Errorf("Poll options can't be empty")
+		}
+		if len(opt) > maxPollOption {
+			return fmt.Errorf("Poll option exceeded max length of %d characters", maxPollOption)
+		}
+		if seen[strings.ToLower(opt)] {
+			return fmt.Errorf("Poll options must be unique")
+		}
+		seen[strings.ToLower(opt)] = true
+		p.Options[i] = html.EscapeString(opt)
+	}
+	p.Question = html.EscapeString(p.Question)
+
+	if p.ClosesAt != nil && !p.ClosesAt.After(time.Now()) {
+		return fmt.Errorf("Poll closing time must be in the future")
+	}
+	return nil
+}
+
+// Insert a validated poll and its options, within the post transaction.
+func InsertPoll(tx *sql.Tx, postID int64, p *PollInput) error {
+	var closesAt any
+	if p.ClosesAt != nil {
+		closesAt = p.ClosesAt.UTC().Format(sqlTimeLayout)
+	}
+	res, err := tx.Exec(`
+        INSERT INTO polls (post_id, question, multiple, anonymous, closes_at)
+        VALUES (?, ?, ?, ?, ?)`,
+		postID, p.Question, p.Multiple, p.Anonymous, closesAt,
+	)
+	if err != nil {
+		return err
+	}
+	pollID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for i, label := range p.Options {
+		_, err := tx.Exec(`
+            INSERT INTO poll_options (poll_id, label, position)
+            VALUES (?, ?, ?)`,
+			pollID, label, i,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load the poll of a post with its results, nil if the post has none.
+// viewerID is 0 for guests.
+func LoadPoll(postID, viewerID int) (*Poll, error) {
+	var p Poll
+	var closesAt sql.NullTime
+	err := DB.QueryRow(`
+        SELECT id, post_id, question, multiple, anonymous, closes_at, created_at
+        FROM polls
+        WHERE post_id = ?`,
+		postID,
+	).Scan(&p.ID, &p.PostID, &p.Question, &p.Multiple, &p.Anonymous, &closesAt, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if closesAt.Valid {
+		p.ClosesAt = &closesAt.Time
+		p.Closed = !time.Now().Before(closesAt.Time)
+	}
+
+	// Options with their vote counts
+	rows, err := DB.Query(`
+        SELECT o.id, o.label, COUNT(v.user_id)
+        FROM poll_options o
+        LEFT JOIN poll_votes v ON v.option_id = o.id
+        WHERE o.poll_id = ?
+        GROUP BY o.id
+        ORDER BY o.position`,
+		p.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]int) // option ID -> index
+	for rows.Next() {
+		var o PollOption
+		if err := rows.Scan(&o.ID, &o.Label, &o.Votes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		byID[o.ID] = len(p.Options)
+		p.Options = append(p.Options, o)
+	}
+	rows.Close()
+
+	err = DB.QueryRow(`SELECT COUNT(DISTINCT user_id) FROM poll_votes WHERE poll_id = ?`, p.ID).Scan(&p.Voters)
+	if err != nil {
+		return nil, err
+	}
+
+	// Who voted what, public polls only
+	if !p.Anonymous {
+		visible, args := VisibleTo(viewerID, "v.user_id")
+		args = append([]any{p.ID}, args...)
+		rows, err := DB.Query(`
+            SELECT v.option_id, u.username
+            FROM poll_votes v
+            JOIN users u ON u.id = v.user_id
+            WHERE v.poll_id = ? AND `+visible+`
+            ORDER BY v.created_at`,
+			args...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var optionID int
+			var username string
+			if err := rows.Scan(&optionID, &username); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			i := byID[optionID]
+			p.Options[i].Voters = append(p.Options[i].Voters, username)
+		}
+		rows.Close()
+	}
+
+	if viewerID == 0 {
+		return &p, nil
+	}
+	rows, err = DB.Query(`SELECT option_id FROM poll_votes WHERE poll_id = ? AND user_id = ?`, p.ID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var optionID int
+		if err := rows.Scan(&optionID); err != nil {
+			return nil, err
+		}
+		p.UserVotes = append(p.UserVotes, optionID)
+	}
+	return &p, rows.Err()
+}
+
+// Serve the poll of a post.
+func GetPoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.URL.Query().Get("post_id"))
+	if err != nil {
+		JsonError(w, "Invalid post_id", http.StatusBadRequest, err)
+		return
+	}
+
+	// Hidden like the post itself
+	var authorID int
+	err = DB.QueryRow(`SELECT user_id FROM posts WHERE id = ?`, postID).Scan(&authorID)
+	if err != nil && err != sql.ErrNoRows {
+		JsonError(w, "Failed to load poll", http.StatusInternalServerError, err)
+		return
+	}
+	hidden, err := IsHidden(ViewerID(r), authorID)
+	if err != nil {
+		JsonError(w, "Failed to load poll", http.StatusInternalServerError, err)
+		return
+	}
+
+	poll, err := LoadPoll(postID, ViewerID(r))
+	if err != nil {
+		JsonError(w, "Failed to load poll", http.StatusInternalServerError, err)
+		return
+	}
+	if poll == nil || hidden {
+		JsonError(w, "Poll not found", http.StatusNotFound, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(poll)
+}
+
+// Handle a vote, one vote per user and poll.
+func VotePoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Login to vote", http.StatusUnauthorized, err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 4000)
+
+	var payload struct {
+		PollID    int   `json:"poll_id"`
+		OptionIDs []int `json:"option_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		JsonError(w, "Invalid payload", http.StatusBadRequest, err)
+		return
+	}
+
+	var postID, authorID, optionCount int
+	var multiple bool
+	var closesAt sql.NullTime
+	err = DB.QueryRow(`
+        SELECT pl.post_id, p.user_id, pl.multiple, pl.closes_at,
+            (SELECT COUNT(*) FROM poll_options WHERE poll_id = pl.id)
+        FROM polls pl
+        JOIN posts p ON p.id = pl.post_id
+        WHERE pl.id = ?`,
+		payload.PollID,
+	).Scan(&postID, &authorID, &multiple, &closesAt, &optionCount)
+	if err == sql.ErrNoRows {
+		JsonError(w, "Poll not found", http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		JsonError(w, "Failed to find poll", http.StatusInternalServerError, err)
+		return
+	}
+	if closesAt.Valid && !time.Now().Before(closesAt.Time) {
+		JsonError(w, "This poll is closed", http.StatusForbidden, nil)
+		return
+	}
+	blocked, err := IsBlocked(user.ID, authorID)
+	if err != nil {
+		JsonError(w, "Failed to find poll", http.StatusInternalServerError, err)
+		return
+	}
+	if blocked {
+		JsonError(w, "You can't vote on this poll", http.StatusForbidden, ErrBlocked)
+		return
+	}
+
+	// Drop duplicated options
+	seen := make(map[int]bool)
+	var options []any
+	for _, id := range payload.OptionIDs {
+		if !seen[id] {
+			seen[id] = true
+			options = append(options, id)
+		}
+	}
+	if len(options) == 0 || (!multiple && len(options) > 1) {
+		JsonError(w, "Pick one option", http.StatusBadRequest, nil)
+		return
+	}
+	if len(options) > optionCount {
+		JsonError(w, "Invalid poll option", http.StatusBadRequest, nil)
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		JsonError(w, "Failed to vote", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Insert only if the user has not voted yet, in one statement to avoid races.
+	args := append([]any{user.ID, payload.PollID}, options...)
+	args = append(args, payload.PollID, user.ID)
+	res, err := tx.Exec(`
+        INSERT INTO poll_votes (poll_id, option_id, user_id)
+        SELECT poll_id, id, ?
+        FROM poll_options
+        WHERE poll_id = ? AND id IN (`+Placeholders(len(options))+`)
+        AND NOT EXISTS (SELECT 1 FROM poll_votes WHERE poll_id = ? AND user_id = ?)`,
+		args...,
+	)
+	if err != nil {
+		JsonError(w, "Failed to vote", http.StatusInternalServerError, err)
+		return
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		JsonError(w, "Failed to vote", http.StatusInternalServerError, err)
+		return
+	}
+	if n != int64(len(options)) {
+		var voted bool
+		err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM poll_votes WHERE poll_id = ? AND user_id = ?)`, payload.PollID, user.ID).Scan(&voted)
+		if err == nil && n == 0 && voted {
+			JsonError(w, "You already voted", http.StatusConflict, nil)
+			return
+		}
+		JsonError(w, "Invalid poll option", http.StatusBadRequest, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		JsonError(w, "Failed to vote", http.StatusInternalServerError, err)
+		return
+	}
+
+	poll, err := LoadPoll(postID, user.ID)
+	if err != nil {
+		JsonError(w, "Failed to load poll", http.StatusInternalServerError, err)
+		return
+	}
+	BroadcastPollResults(poll)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(poll)
+}
+
+// Push fresh results to everyone viewing the post.
+// Voters are left out, their visibility depends on each viewer.
+func BroadcastPollResults(poll *Poll) {
+	results := *poll
+	results.UserVotes = nil // Viewer specific
+	results.Options = make([]PollOption, len(poll.Options))
+	for i, o := range poll.Options {
+		o.Voters = nil
+		results.Options[i] = o
+	}
+	BroadcastToPost(poll.PostID, PollResultsEvent{
+		Action: "poll_results",
+		PostID: poll.PostID,
+		Poll:   &results,
+	})
+}
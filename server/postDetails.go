@@ -0,0 +1,153 @@
+package server
+
+import (
+	"strings"
+)
+
+// Fill image sizes, galleries, link previews, categories, counters, the viewer's reaction and bookmarks of a list of posts
+// using one query each instead of one per post.
+// viewerID is 0 for guests.
+func LoadPostDetails(posts []Post, viewerID int) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	// Index posts by ID to dispatch batched rows.
+	byID := make(map[int]*Post, len(posts))
+	ids := make([]any, len(posts))
+	var links []string
+	for i := range posts {
+		byID[posts[i].ID] = &posts[i]
+		ids[i] = posts[i].ID
+
+		p := &posts[i]
+		p.ImageThumb = ImageVariant(p.Image, "thumb")
+		p.ImageMedium = ImageVariant(p.Image, "medium")
+		p.ProfilePicThumb = ImageVariant(p.ProfilePic, "thumb")
+		p.Media = []PostMedia{}
+		links = append(links, ExtractLinks(p.Content)...)
+	}
+	in := Placeholders(len(ids))
+
+	// Link previews (cached, missing ones are fetched in background)
+	previews, err := LoadLinkPreviews(links)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Previews = PreviewsOf(posts[i].Content, previews)
+	}
+
+	// Categories
+	rows, err := DB.Query(`
+        SELECT pc.post_id, c.id, c.name
+        FROM post_categories pc
+        JOIN categories c ON pc.category_id = c.id
+        WHERE pc.post_id IN (`+in+`)`, ids...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var postID int
+		var c Category
+		if err := rows.Scan(&postID, &c.ID, &c.Name); err != nil {
+			rows.Close()
+			return err
+		}
+		byID[postID].Categories = append(byID[postID].Categories, c)
+	}
+	rows.Close()
+
+	// Galleries
+	rows, err = DB.Query(`
+        SELECT post_id, position, name, alt
+        FROM post_media
+        WHERE post_id IN (`+in+`)
+        ORDER BY post_id, position`, ids...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var postID int
+		var m PostMedia
+		if err := rows.Scan(&postID, &m.Position, &m.Name, &m.Alt); err != nil {
+			rows.Close()
+			return err
+		}
+		m.Thumb = ImageVariant(m.Name, "thumb")
+		m.Medium = ImageVariant(m.Name, "medium")
+		byID[postID].Media = append(byID[postID].Media, m)
+	}
+	rows.Close()
+
+	// Counters (maintained by post_stats triggers)
+	rows, err = DB.Query(`
+        SELECT post_id, likes, dislikes, comments
+        FROM post_stats
+        WHERE post_id IN (`+in+`)`, ids...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var postID, likes, dislikes, comments int
+		if err := rows.Scan(&postID, &likes, &dislikes, &comments); err != nil {
+			rows.Close()
+			return err
+		}
+		p := byID[postID]
+		p.Likes, p.Dislikes, p.CommentsCount = likes, dislikes, comments
+	}
+	rows.Close()
+
+	if viewerID == 0 {
+		return nil
+	}
+
+	// Viewer's own reactions
+	args := append([]any{viewerID}, ids...)
+	rows, err = DB.Query(`
+        SELECT post_id, reaction_type
+        FROM post_reactions
+        WHERE user_id = ? AND post_id IN (`+in+`)`, args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var postID int
+		var reaction string
+		if err := rows.Scan(&postID, &reaction); err != nil {
+			rows.Close()
+			return err
+		}
+		byID[postID].UserReaction = reaction
+	}
+	rows.Close()
+
+	// Viewer's bookmarks
+	rows, err = DB.Query(`
+        SELECT post_id, note
+        FROM bookmarks
+        WHERE user_id = ? AND post_id IN (`+in+`)`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postID int
+		var note string
+		if err := rows.Scan(&postID, &note); err != nil {
+			return err
+		}
+		byID[postID].Bookmarked = true
+		byID[postID].BookmarkNote = note
+	}
+	return rows.Err()
+}
+
+// Return "?,?,?" for n parameters of an IN clause.
+func Placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat("?,", n-1) + "?"
+}
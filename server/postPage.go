@@ -0,0 +1,204 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Max posts listed in sitemap.xml (limit of the protocol).
+const sitemapLimit = 50000
+
+// Length of descriptions in shared links.
+const pageDescriptionSize = 200
+
+// Server-rendered parts of home.html: metadata of shared links,
+// and the post crawlers and guests see before the SPA takes over.
+type PageData struct {
+	Title       string // Without the site name
+	Description string
+	URL         string // Canonical address
+	Image       string
+	Type        string // og:type
+	Post        *PostView
+}
+
+// Post rendered in home.html. Post is also embedded as JSON for the SPA.
+type PostView struct {
+	Post     *Post
+	Title    string
+	Content  template.HTML
+	Images   []PageImage
+	Comments []CommentView
+}
+
+type PageImage struct {
+	URL string
+	Alt string
+}
+
+type CommentView struct {
+	Username  string
+	IsBot     bool
+	CreatedAt time.Time
+	Content   template.HTML
+}
+
+// Serve /post/{id} with its metadata, content and first comments.
+// /post?post_id={id} links from before are redirected.
+func PostPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		ErrorHandler(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed), "Only GET method is allowed!", nil)
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/post/"))
+	if err != nil || id <= 0 {
+		ErrorHandler(w, http.StatusNotFound, "Page not found", "The post you are looking for doesn't exist.", nil)
+		return
+	}
+
+	viewerID := ViewerID(r)
+	post, err := LoadPost(id, viewerID)
+	if err == sql.ErrNoRows {
+		ErrorHandler(w, http.StatusNotFound, "Post not found", "The post you are looking for doesn't exist.", nil)
+		return
+	}
+	if err != nil {
+		ErrorHandler(w, http.StatusInternalServerError, "Something seems wrong, try again later!", "Internal Server Error!", err)
+		return
+	}
+
+	comments, err := FetchComments(id, viewerID, nil, 0)
+	if err != nil {
+		ErrorHandler(w, http.StatusInternalServerError, "Something seems wrong, try again later!", "Internal Server Error!", err)
+		return
+	}
+	comments, _ = paginate(comments, commentsLimit, func(c Comment) Cursor {
+		return Cursor{CreatedAt: c.CreatedAt, ID: c.ID}
+	})
+
+	site := siteURL(r)
+	view := &PostView{
+		Post:  post,
+		Title: html.UnescapeString(post.Title),
+		// Sanitized by RenderMarkdown
+		Content: template.HTML(post.ContentHTML),
+	}
+	for _, m := range post.Media {
+		view.Images = append(view.Images, PageImage{URL: Media.URL(m.Medium), Alt: html.UnescapeString(m.Alt)})
+	}
+	for _, c := range comments {
+		view.Comments = append(view.Comments, CommentView{
+			Username:  c.Username,
+			IsBot:     c.IsBot,
+			CreatedAt: c.CreatedAt,
+			Content:   template.HTML(c.ContentHTML),
+		})
+	}
+
+	data := PageData{
+		Title:       view.Title,
+		Description: summarize(post.ContentHTML, pageDescriptionSize),
+		URL:         postURL(site, post.ID),
+		Type:        "article",
+		Post:        view,
+	}
+	if post.Image != "" {
+		data.Image = uploadURL(site, post.ImageMedium)
+	}
+	ParseAndExecute(w, data, "static/templates/home.html")
+}
+
+// Plain text of rendered HTML, cut to max runes.
+func summarize(rendered string, max int) string {
+	text := html.UnescapeString(bluemonday.StrictPolicy().Sanitize(rendered))
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) > max {
+		text = string([]rune(text)[:max-1]) + "…"
+	}
+	return text
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// List the home page and the public posts, newest first.
+func SitemapHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	site := siteURL(r)
+	set := sitemapURLSet{URLs: []sitemapURL{{Loc: site + "/"}}}
+
+	// Posts as seen by a guest
+	scope := VisiblePosts(0)
+	rows, err := DB.Query(`
+        SELECT p.id, p.created_at
+        FROM posts p
+        WHERE `+scope.Where+`
+        ORDER BY p.created_at DESC, p.id DESC
+        LIMIT ?`, append(scope.Args, sitemapLimit-1)...)
+	if err != nil {
+		JsonError(w, "Failed to build sitemap", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var createdAt time.Time
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			JsonError(w, "Failed to build sitemap", http.StatusInternalServerError, err)
+			return
+		}
+		set.URLs = append(set.URLs, sitemapURL{Loc: postURL(site, id), LastMod: createdAt.UTC().Format("2006-01-02")})
+	}
+	if err := rows.Err(); err != nil {
+		JsonError(w, "Failed to build sitemap", http.StatusInternalServerError, err)
+		return
+	}
+
+	body, err := encodeXML(set)
+	if err != nil {
+		JsonError(w, "Failed to build sitemap", http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(body)
+}
+
+// Keep crawlers on pages, out of the API and auth flows.
+func RobotsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, `User-agent: *
+Disallow: /api/
+Disallow: /ws/
+Disallow: /auth/
+Disallow: /cooldown
+Allow: /
+
+Sitemap: %s/sitemap.xml
+`, siteURL(r))
+}
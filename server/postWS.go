@@ -0,0 +1,78 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Connections of users (or guests) currently viewing a post.
+var (
+	postViewers = make(map[int]map[*websocket.Conn]bool) // postID -> Set of WebSocket connections
+	viewersMu   sync.Mutex                               // Protects concurrent access
+)
+
+// WebSocket for live updates of a single post page, guests allowed.
+func PostSocket(w http.ResponseWriter, r *http.Request) {
+	postID, err := strconv.Atoi(r.URL.Query().Get("post_id"))
+	if err != nil {
+		JsonError(w, "Invalid post_id", http.StatusBadRequest, err)
+		return
+	}
+
+	var exists bool
+	err = DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)`, postID).Scan(&exists)
+	if err != nil || !exists {
+		JsonError(w, "Post not found", http.StatusNotFound, err)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil) // Upgrade HTTP to WebSocket
+	if err != nil {
+		Logger(r).Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+
+	viewersMu.Lock()
+	if postViewers[postID] == nil {
+		postViewers[postID] = make(map[*websocket.Conn]bool)
+	}
+	postViewers[postID][conn] = true
+	viewersMu.Unlock()
+
+	defer func() {
+		viewersMu.Lock()
+		delete(postViewers[postID], conn)
+		if len(postViewers[postID]) == 0 {
+			delete(postViewers, postID)
+		}
+		viewersMu.Unlock()
+		conn.Close()
+	}()
+
+	// Keep connection open
+	for {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			break // Client disconnected
+		}
+	}
+}
+
+// Send data to every viewer of a post.
+func BroadcastToPost(postID int, data interface{}) {
+	viewersMu.Lock()
+	defer viewersMu.Unlock()
+
+	for conn := range postViewers[postID] {
+		err := conn.WriteJSON(data)
+		if err != nil {
+			slog.Error("Error sending WebSocket message", "error", err)
+			conn.Close()
+			delete(postViewers[postID], conn)
+		}
+	}
+}
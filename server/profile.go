@@ -3,9 +3,8 @@ package server
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
+	"log/slog"
 	"net/http"
-	"strconv"
 )
 
 // UserProfile holds the profile information
@@ -15,7 +14,14 @@ type UserProfile struct {
 	LastName   string `json:"last_name"`
 	Gender     string `json:"gender"`
 	ProfilePic string `json:"profile_pic"`
-	Age        int    `json:"age"`
+	// Derived sizes of the profile pic
+	ProfilePicThumb  string `json:"profile_pic_thumb"`
+	ProfilePicMedium string `json:"profile_pic_medium"`
+	Age              int    `json:"age"`
+	Followers        int    `json:"followers"`
+	Following        int    `json:"following"`
+	IsFollowed       bool   `json:"is_followed"` // By the viewer
+	IsBot            bool   `json:"is_bot"`
 }
 
 // fetches user profile information
@@ -26,7 +32,7 @@ func GetProfileInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Ensure the requester is authenticated
-	_, err := GetUser(r)
+	viewer, err := GetUser(r)
 	if err != nil {
 		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
 		return
@@ -40,11 +46,12 @@ func GetProfileInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var profile UserProfile
+	var userID int
 	err = DB.QueryRow(`
-        SELECT username, first_name, last_name, gender, profile_pic, age
+        SELECT id, username, first_name, last_name, gender, profile_pic, age, is_bot
         FROM users 
         WHERE username = ?`, username).Scan(
-		&profile.Username, &profile.FirstName, &profile.LastName, &profile.Gender, &profile.ProfilePic, &profile.Age,
+		&userID, &profile.Username, &profile.FirstName, &profile.LastName, &profile.Gender, &profile.ProfilePic, &profile.Age, &profile.IsBot,
 	)
 
 	// Handle errors properly
@@ -56,6 +63,24 @@ func GetProfileInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	hidden, err := IsHidden(viewer.ID, userID)
+	if err != nil {
+		JsonError(w, "Database error", http.StatusInternalServerError, err)
+		return
+	}
+	if hidden {
+		JsonError(w, "User not found", http.StatusNotFound, nil)
+		return
+	}
+	profile.ProfilePicThumb = ImageVariant(profile.ProfilePic, "thumb")
+	profile.ProfilePicMedium = ImageVariant(profile.ProfilePic, "medium")
+
+	profile.Followers, profile.Following, profile.IsFollowed, err = FollowCounts(userID, viewer.ID)
+	if err != nil {
+		JsonError(w, "Database error", http.StatusInternalServerError, err)
+		return
+	}
+
 	// Return profile info as JSON
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(profile)
@@ -103,22 +128,24 @@ func GetUserPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Read "offset" query param (default 0 if missing)
-	offsetParam := r.URL.Query().Get("offset")
-	offset, err := strconv.Atoi(offsetParam)
+	cursor, offset, legacy, err := ParsePaging(r)
 	if err != nil {
-		offset = 0
+		JsonError(w, "Bad request", http.StatusBadRequest, err)
+		return
 	}
+	keyset, args := cursor.Where("p.created_at", "p.id")
+	args = append([]any{user.ID}, args...)
+	args = append(args, Conf.Limits.ProfileItems+1, offset)
 
 	rows, err := DB.Query(`
-      	SELECT p.id, p.user_id, p.title, p.content, p.image, p.created_at, u.username, u.profile_pic
+      	SELECT p.id, p.user_id, p.title, p.content, p.image, p.created_at, u.username, u.profile_pic, u.is_bot
       	FROM posts p
       	JOIN users u ON p.user_id = u.id
-      	WHERE p.user_id = ?
-      	ORDER BY p.id DESC
+      	WHERE p.user_id = ? AND `+keyset+`
+      	ORDER BY p.created_at DESC, p.id DESC
       	LIMIT ?
       	OFFSET ?
-    `, user.ID, ProfileLimit, offset) // ProfileLImit = 6 (activity.go)
+    `, args...)
 	if err != nil {
 		JsonError(w, "Failed to get user's posts", http.StatusInternalServerError, err)
 		return
@@ -133,8 +160,12 @@ func GetUserPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(posts)
+	posts, next := paginate(posts, Conf.Limits.ProfileItems, postCursor)
+	if err := LoadPostDetails(posts, user.ID); err != nil {
+		JsonError(w, "Failed to load posts details", http.StatusInternalServerError, err)
+		return
+	}
+	WritePage(w, posts, next, legacy)
 }
 
 // ********** User's helper functions ********** //
@@ -144,7 +175,7 @@ func GetUsername(userID int) string {
 	var username string
 	err := DB.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username)
 	if err != nil {
-		fmt.Println("Error fetching username:", err)
+		slog.Error("Error fetching username", "error", err)
 		return "JohnDoe" // Fallback value
 	}
 	return username
@@ -155,7 +186,7 @@ func GetUserProfilePic(userID int) string {
 	var profilePic string // Allows NULL handling
 	err := DB.QueryRow("SELECT profile_pic FROM users WHERE id = ?", userID).Scan(&profilePic)
 	if err != nil {
-		fmt.Println("Error fetching profile picture:", err)
+		slog.Error("Error fetching profile picture", "error", err)
 		return "avatar.webp" // Default profile picture
 	}
 	return profilePic
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// How often post scores are recomputed.
+const scoreInterval = time.Minute
+
+// Seconds for a post to need 10x more votes to stay on top (hot decay).
+const hotDecay = 45000
+
+// Sorting of post lists ("new", "hot", "top", "controversial").
+type Ranking struct {
+	Sort   string
+	Window string // Only for "top": day, week, month, year, all
+}
+
+// Time windows accepted by the "top" sort.
+var topWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+	"all":   0,
+}
+
+// Read "sort" and "t" query params, defaults to newest posts.
+func ParseRanking(r *http.Request) (Ranking, bool) {
+	rk := Ranking{
+		Sort:   r.URL.Query().Get("sort"),
+		Window: r.URL.Query().Get("t"),
+	}
+	if rk.Sort == "" {
+		rk.Sort = "new"
+	}
+	if rk.Window == "" {
+		rk.Window = "all"
+	}
+	switch rk.Sort {
+	case "new", "hot", "controversial":
+		return rk, true
+	case "top":
+		_, ok := topWindows[rk.Window]
+		return rk, ok
+	}
+	return rk, false
+}
+
+// ORDER BY clause, post_scores must be joined as "s".
+func (rk Ranking) OrderBy() string {
+	switch rk.Sort {
+	case "hot":
+		// Posts not scored yet rank with a zero score.
+		return fmt.Sprintf(`COALESCE(s.hot, strftime('%%s', p.created_at) / %d.0) DESC, p.id DESC`, hotDecay)
+	case "top":
+		return `COALESCE(s.score, 0) DESC, p.created_at DESC, p.id DESC`
+	case "controversial":
+		return `COALESCE(s.controversial, 0) DESC, p.created_at DESC, p.id DESC`
+	default:
+		return `p.created_at DESC, p.id DESC`
+	}
+}
+
+// Extra WHERE condition for the "top" time window.
+func (rk Ranking) Where() (string, []any) {
+	window := topWindows[rk.Window]
+	if rk.Sort != "top" || window == 0 {
+		return "1 = 1", nil
+	}
+	since := time.Now().Add(-window).UTC().Format(sqlTimeLayout)
+	return "p.created_at >= ?", []any{since}
+}
+
+// Read post counters and store each post's scores.
+func ScorePosts(ctx context.Context) error {
+	rows, err := DB.QueryContext(ctx, `
+        SELECT p.id, strftime('%s', p.created_at),
+            COALESCE(st.likes, 0), COALESCE(st.dislikes, 0), COALESCE(st.comments, 0)
+        FROM posts p
+        LEFT JOIN post_stats st ON st.post_id = p.id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type stats struct {
+		postID, created, likes, dislikes, comments int64
+	}
+	var all []stats
+	for rows.Next() {
+		var s stats
+		if err := rows.Scan(&s.postID, &s.created, &s.likes, &s.dislikes, &s.comments); err != nil {
+			return err
+		}
+		all = append(all, s)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO post_scores (post_id, score, hot, controversial, updated_at)
+        VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+        ON CONFLICT(post_id) DO UPDATE SET
+            score = excluded.score,
+            hot = excluded.hot,
+            controversial = excluded.controversial,
+            updated_at = excluded.updated_at`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, s := range all {
+		score := s.likes - s.dislikes
+		hot := hotScore(score, s.comments, s.created)
+		contro := controversialScore(s.likes, s.dislikes)
+		if _, err := stmt.ExecContext(ctx, s.postID, score, hot, contro); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Reddit-like hot score: log of the votes plus creation time,
+// so newer posts need fewer votes to rank higher.
+// Each comment counts as half a vote.
+func hotScore(score, comments, created int64) float64 {
+	s := float64(score) + float64(comments)/2
+	order := math.Log10(math.Max(math.Abs(s), 1))
+	sign := 0.0
+	if s > 0 {
+		sign = 1
+	} else if s < 0 {
+		sign = -1
+	}
+	return sign*order + float64(created)/hotDecay
+}
+
+// High when likes and dislikes are both many and close to each other.
+func controversialScore(likes, dislikes int64) float64 {
+	if likes <= 0 || dislikes <= 0 {
+		return 0
+	}
+	magnitude := float64(likes + dislikes)
+	balance := float64(min(likes, dislikes)) / float64(max(likes, dislikes))
+	return math.Pow(magnitude, balance)
+}
@@ -1,8 +1,8 @@
 package server
 
 import (
+	"database/sql"
 	"encoding/json"
-	"fmt"
 	"net/http"
 )
 
@@ -26,14 +26,19 @@ func AddReaction(w http.ResponseWriter, r *http.Request) {
 
 	// Decide if it’s a post or comment from query param
 	typeParam := r.URL.Query().Get("type")
-	var tableName, idColumn string
+	var targetTable, tableName, idColumn string
 	switch typeParam {
 	case "comment":
+		targetTable = "comments"
 		tableName = "comment_reactions"
 		idColumn = "comment_id"
 	case "post":
+		targetTable = "posts"
 		tableName = "post_reactions"
 		idColumn = "post_id"
+	default:
+		JsonError(w, "Invalid type", http.StatusBadRequest, nil)
+		return
 	}
 
 	var payload struct {
@@ -51,29 +56,24 @@ func AddReaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if post or comment exists
-	var exists bool
-	table := typeParam + "s" // Ensures it becomes "posts" or "comments"
-	query := fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM %s WHERE id = ?)`, table)
-	err = DB.QueryRow(query, payload.ID).Scan(&exists)
-	if err != nil {
-		JsonError(w, "Failed to verify post or comment existence", http.StatusInternalServerError, err)
+	// Owner of the post or comment, for notifications and blocks
+	var ownerID int
+	err = DB.QueryRow(`SELECT user_id FROM `+targetTable+` WHERE id = ?`, payload.ID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		JsonError(w, "Post or comment does not exist", http.StatusBadRequest, nil)
+		return
+	} else if err != nil {
+		JsonError(w, "Failed to find post or comment owner", http.StatusInternalServerError, err)
 		return
 	}
-	if !exists {
-		JsonError(w, "Post or comment does not exist", http.StatusBadRequest, nil)
+	blocked, err := IsBlocked(user.ID, ownerID)
+	if err != nil {
+		JsonError(w, "Failed to find post or comment owner", http.StatusInternalServerError, err)
 		return
 	}
-
-	// For Notifaction insertion
-	var ownerID int
-	if typeParam == "post" {
-		// e.g. select user who created the post
-		err = DB.QueryRow(`SELECT user_id FROM posts WHERE id = ?`, payload.ID).Scan(&ownerID)
-		if err != nil {
-			JsonError(w, "Failed to find post owner", http.StatusInternalServerError, err)
-			return
-		}
+	if blocked {
+		JsonError(w, "You can't react to this "+typeParam, http.StatusForbidden, ErrBlocked)
+		return
 	}
 	/////////////////////////////
 
@@ -93,12 +93,13 @@ func AddReaction(w http.ResponseWriter, r *http.Request) {
 			JsonError(w, "Failed to add/update reaction", http.StatusInternalServerError, err)
 			return
 		}
-		if ownerID != user.ID && typeParam == "post" {
-			err = InsertNotification(ownerID, user.ID, &payload.ID, payload.ReactionType)
-			if err != nil {
-				fmt.Println("Failed to insert notification:", err)
-			}
-		}
+		Publish(ReactionAdded{
+			Target:   typeParam,
+			TargetID: payload.ID,
+			OwnerID:  ownerID,
+			Reaction: payload.ReactionType,
+			User:     *user,
+		})
 	} else {
 		// If the same, remove it => "un-toggle"
 		queryDelete := `DELETE FROM ` + tableName + ` WHERE ` + idColumn + ` = ? AND user_id = ?`
@@ -115,6 +116,11 @@ func AddReaction(w http.ResponseWriter, r *http.Request) {
 
 // Get a post/comments reactions.
 func GetReactions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
 	typeParam := "post"
 	ID := r.URL.Query().Get("post_id")
 	if ID == "" {
@@ -2,6 +2,7 @@ package server
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -15,61 +16,31 @@ func Routes() http.Handler {
 
 	// General routes
 	mux.HandleFunc("/", HomeHandler)
+	mux.HandleFunc("/post", HomeHandler) // Redirects to /post/{id}
+	mux.HandleFunc("/post/", PostPageHandler)
+	mux.HandleFunc("/sitemap.xml", SitemapHandler)
+	mux.HandleFunc("/robots.txt", RobotsHandler)
 	mux.HandleFunc("/css/", FilesHandler)
 	mux.HandleFunc("/js/", FilesHandler)
 	mux.HandleFunc("/img/", FilesHandler)
-	mux.HandleFunc("/uploads/", FilesHandler)
-	mux.HandleFunc("/api/check-session", CheckSession)
+	mux.HandleFunc("/uploads/", UploadsHandler)
 
-	// Routes for posts
-	mux.HandleFunc("/api/get-posts", GetPostsHandler)
-	mux.HandleFunc("/api/get-categories", GetCategoriesHandler)
-	mux.HandleFunc("/api/get-reactions", GetReactions)
-	mux.HandleFunc("/api/get-singlePost", SinglePostHandler)
-	mux.HandleFunc("/api/get-comments", GetComments)
-	mux.HandleFunc("/api/comments-count", GetCommentsCount)
+	// JSON API, /api/v1 and the deprecated /api aliases
+	RegisterAPI(mux, rl)
+	mux.HandleFunc("/feeds/", FeedHandler)
 
-	// Routes for users data
-	mux.HandleFunc("/api/user-liked-posts", LikedPosts)
-	mux.HandleFunc("/api/user-commented-posts", UserCommentedPosts)
-	mux.HandleFunc("/api/user-post-comments", GetUserPostComments)
-	mux.HandleFunc("/api/check-user", CheckUserHandler)
-	mux.HandleFunc("/api/get-profile-info", GetProfileInfo)
-	mux.HandleFunc("/api/get-user-posts", GetUserPosts)
-	mux.Handle("/api/update-profile-pic", rl.Middleware(http.HandlerFunc(UpdateProfilePic)))
-
-	// Routes for notifications
-	mux.Handle("/api/delete-notification", rl.Middleware(http.HandlerFunc(DeleteNotification)))
-	mux.Handle("/api/delete-all-notifications", rl.Middleware(http.HandlerFunc(DeleteAllNotifications)))
-	mux.Handle("/api/mark-notification-read", rl.Middleware(http.HandlerFunc(MarkNotificationAsRead)))
-	mux.HandleFunc("/api/get-unread-notification-count", GetUnreadNotificationCount)
-	mux.HandleFunc("/api/get-notifications", GetNotifications)
+	// Websockets
+	mux.HandleFunc("/ws/post", PostSocket)
 	mux.HandleFunc("/ws/notifications", NotificationSocket)
-
-	// Routes for chat messaging
 	mux.HandleFunc("/ws/online-users", OnlineUsersWS)
-	mux.HandleFunc("/api/get-messages", GetMessages)
 	mux.HandleFunc("/ws/messages", MessageWebSocket)
-	mux.Handle("/api/send-message", rl.Middleware(http.HandlerFunc(SendMessage)))
-	mux.Handle("/api/update-online-users", rl.Middleware(http.HandlerFunc(UpdateOnlineUsers)))
-
-	// Routes for Auth & Content Creation
-	mux.Handle("/api/login", rl.Middleware(http.HandlerFunc(LoginHandler)))
-	mux.Handle("/api/signup", rl.Middleware(http.HandlerFunc(SignUpHandler)))
-	mux.Handle("/api/logout", rl.Middleware(http.HandlerFunc(LogoutHandler)))
-	mux.Handle("/api/create-post", rl.Middleware(http.HandlerFunc(CreatePostHandler)))
-	mux.Handle("/api/add-categories", rl.Middleware(http.HandlerFunc(AddCategoriesHandler)))
-	mux.Handle("/api/add-reaction", rl.Middleware(http.HandlerFunc(AddReaction)))
-	mux.Handle("/api/add-comment", rl.Middleware(http.HandlerFunc(AddComment)))
 
 	// Routes for social login.
 	mux.HandleFunc("/auth/google", GoogleLoginHandler)
 	mux.HandleFunc("/auth/github", GithubLoginHandler)
 	mux.HandleFunc("/auth/callback", SocialCallbackHandler)
-	mux.HandleFunc("/api/social-signup", SocialSignupHandler)
-	mux.HandleFunc("/api/social-check", CheckOAuth)
 
-	return secureHeaders(mux)
+	return requestLogger(secureHeaders(mux))
 }
 
 // Home (spa) handler.
@@ -78,5 +49,16 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 		JsonError(w, http.StatusText(http.StatusMethodNotAllowed), 405, nil)
 		return
 	}
-	ParseAndExecute(w, "", "static/templates/home.html")
+	// Old address of post pages
+	if r.URL.Path == "/post" {
+		if id, err := strconv.Atoi(r.URL.Query().Get("post_id")); err == nil {
+			http.Redirect(w, r, "/post/"+strconv.Itoa(id), http.StatusMovedPermanently)
+			return
+		}
+	}
+	data := PageData{Type: "website"}
+	if r.URL.Path == "/" {
+		data.URL = siteURL(r) + "/"
+	}
+	ParseAndExecute(w, data, "static/templates/home.html")
 }
@@ -22,3 +22,33 @@ func FilesHandler(w http.ResponseWriter, r *http.Request) {
 
 	http.ServeContent(w, r, filePath, time.Now(), bytes.NewReader(filesBytes))
 }
+
+// Handle serving uploads from the media store.
+func UploadsHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/uploads/")
+
+	f, err := Media.Get(name)
+	// Uploads older than the derived sizes only have their original
+	if err == ErrMediaNotFound {
+		if original, ok := imageOriginal(name); ok {
+			name = original
+			f, err = Media.Get(name)
+		}
+	}
+	if err == ErrMediaNotFound {
+		JsonError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		JsonError(w, "Failed to read file", http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+
+	// Public buckets serve their files directly
+	if u := Media.URL(name); !strings.HasPrefix(u, "/uploads/") {
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	http.ServeContent(w, r, name, time.Now(), f)
+}
@@ -4,21 +4,43 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
 var (
-	Port       string
-	Router     http.Handler
-	httpServer *http.Server
+	Port   string
+	Router http.Handler
+
+	// Set once serving, read by Shutdown.
+	httpServer   *http.Server
+	httpServerMu sync.Mutex
 )
 
+// Publish the server to Shutdown, false when already shutting down.
+// Shutdown stops background work before reading it, so a server set
+// here is always shut down.
+func setHTTPServer(s *http.Server) bool {
+	httpServerMu.Lock()
+	defer httpServerMu.Unlock()
+	if stopping() {
+		return false
+	}
+	httpServer = s
+	return true
+}
+
 // Starts the HTTP server with TLS
 func Server(handler http.Handler) {
+	if stopping() {
+		<-shutdownDone
+		return
+	}
 	listener, err := net.Listen("tcp", ":"+Port)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
@@ -26,21 +48,21 @@ func Server(handler http.Handler) {
 
 	Port = strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
 
-	// If running on Fly.io, start an HTTP-only server
-	if os.Getenv("FLY_APP_NAME") != "" {
+	// Behind a proxy ending TLS (Fly.io), start an HTTP-only server
+	if !Conf.TLS.Enabled {
 		startHTTPOnly(handler, listener)
 		return
 	}
 
-	cert, err := tls.LoadX509KeyPair("tls/server.crt", "tls/server.key")
+	cert, err := tls.LoadX509KeyPair(Conf.TLS.Cert, Conf.TLS.Key)
 	if err != nil {
 		log.Fatalf("Failed to load key pair: %v", err)
 	}
 
 	caCertPool := x509.NewCertPool()
-	caCert, err := os.ReadFile("tls/ca.crt")
+	caCert, err := os.ReadFile(Conf.TLS.CA)
 	if err != nil {
-		log.Println("Warning: CA certificate not found, continuing without extra CA trust.")
+		slog.Warn("CA certificate not found, continuing without extra CA trust", "path", Conf.TLS.CA)
 	} else {
 		caCertPool.AppendCertsFromPEM(caCert)
 	}
@@ -61,7 +83,7 @@ func Server(handler http.Handler) {
 		ClientAuth:   tls.NoClientCert,        // Mutual TLS is not needed
 	}
 
-	httpServer = &http.Server{
+	srv := &http.Server{
 		Handler:           handler,
 		ReadHeaderTimeout: 5 * time.Second,  // Headers must arrive within 5s
 		ReadTimeout:       5 * time.Second,  // Prevent slow-client attacks
@@ -70,24 +92,41 @@ func Server(handler http.Handler) {
 		TLSConfig:         tlsConfig,        // Bind the TLS configuration
 	}
 
-	log.Printf("Starting server on https://127.0.0.1:%s", Port)
+	if !setHTTPServer(srv) {
+		listener.Close()
+		<-shutdownDone
+		return
+	}
+	slog.Info("Starting server", "addr", "https://127.0.0.1:"+Port)
 
-	if err := httpServer.Serve(tls.NewListener(listener, tlsConfig)); err != nil && err != http.ErrServerClosed {
-		log.Printf("Server error: %v", err)
+	if err := srv.Serve(tls.NewListener(listener, tlsConfig)); err != http.ErrServerClosed {
+		slog.Error("Server error", "error", err)
+		return
 	}
+	<-shutdownDone // Closed by Shutdown once drained
 }
 
 // Starts an HTTP server without TLS (for Fly.io)
 func startHTTPOnly(handler http.Handler, listener net.Listener) {
-	httpServer = &http.Server{
+	srv := &http.Server{
 		Handler:      handler,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 		IdleTimeout:  15 * time.Second,
 	}
+	if !setHTTPServer(srv) {
+		listener.Close()
+		<-shutdownDone
+		return
+	}
 
-	log.Printf("Running on Fly.io. Starting HTTP server on http://0.0.0.0:%s", Port)
-	if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
-		log.Printf("Server error: %v", err)
+	slog.Info("TLS disabled, starting HTTP server", "addr", "http://0.0.0.0:"+Port)
+	if Conf.SiteURL == "" {
+		slog.Warn("site_url not set, absolute links use http and the requested host")
+	}
+	if err := srv.Serve(listener); err != http.ErrServerClosed {
+		slog.Error("Server error", "error", err)
+		return
 	}
+	<-shutdownDone // Closed by Shutdown once drained
 }
@@ -27,11 +27,27 @@ func CheckSession(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, `{"loggedIn": false}`)
 		return
 	}
-	fmt.Fprintf(w, `{"loggedIn": true, "username": %q, "profile_pic": %q}`, user.Username, user.ProfilePic)
+	fmt.Fprintf(w, `{"loggedIn": true, "username": %q, "profile_pic": %q, "is_bot": %t}`, user.Username, user.ProfilePic, user.IsBot)
 }
 
-// Get the user from the current session using cookies.
+// Get the user from the current session using cookies, or from a
+// personal access token ("Authorization: Bearer") with the scope of the route.
 func GetUser(r *http.Request) (*User, error) {
+	user, err := lookupUser(r)
+	if err == nil {
+		logUser(r, user.ID)
+	}
+	return user, err
+}
+
+func lookupUser(r *http.Request) (*User, error) {
+	if user, ok := r.Context().Value(tokenUserContextKey{}).(*User); ok {
+		return user, nil
+	}
+	if token, ok := BearerToken(r); ok {
+		return userFromToken(token, RouteScope(r))
+	}
+
 	cookie, err := r.Cookie("session_token")
 	if err != nil {
 		return nil, fmt.Errorf("no session token provided")
@@ -56,7 +72,7 @@ func GetUser(r *http.Request) (*User, error) {
 
 	// Fetch the user associated with the session from DB
 	var user User
-	err = DB.QueryRow(`SELECT id, email, username, profile_pic FROM users WHERE id = ?`, session.UserID).Scan(&user.ID, &user.Email, &user.Username, &user.ProfilePic)
+	err = DB.QueryRow(`SELECT id, email, username, profile_pic, is_admin, is_bot FROM users WHERE id = ?`, session.UserID).Scan(&user.ID, &user.Email, &user.Username, &user.ProfilePic, &user.IsAdmin, &user.IsBot)
 	if err != nil {
 		return nil, fmt.Errorf("user not found")
 	}
@@ -64,6 +80,14 @@ func GetUser(r *http.Request) (*User, error) {
 	return &user, nil
 }
 
+// ID of the logged in user, 0 for guests.
+func ViewerID(r *http.Request) int {
+	if user, err := GetUser(r); err == nil {
+		return user.ID
+	}
+	return 0
+}
+
 // Create session token (cookie) and insert it into DB.
 func CreateSession(w http.ResponseWriter, user *User) error {
 	tokenuuid, err := uuid.NewV4()
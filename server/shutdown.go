@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Time given to requests and background work to finish, within the
+// grace period of Fly.io (kill_timeout in fly.toml).
+const shutdownTimeout = 8 * time.Second
+
+var (
+	// Canceled on shutdown, background loops stop taking new work.
+	shutdownCtx, stopBackground = context.WithCancel(context.Background())
+	// Background goroutines the shutdown waits for.
+	background sync.WaitGroup
+	// Closed once the DB is closed, Server returns then.
+	shutdownDone = make(chan struct{})
+)
+
+// Run fn in a background goroutine the shutdown waits for.
+func goBackground(fn func()) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		fn()
+	}()
+}
+
+// Whether the server is shutting down.
+func stopping() bool {
+	return shutdownCtx.Err() != nil
+}
+
+// Listens for termination signals (SIGINT from Fly.io or Ctrl+C, SIGTERM
+// from Docker), then stops accepting connections, closes the WebSockets,
+// waits for in-flight requests and background work, and closes the DB.
+// A second signal exits straight away.
+func Shutdown() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	<-stop
+	signal.Stop(stop)
+	slog.Info("Shutting down server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	stopBackground()
+
+	// Nil before serving, Server no longer sets it once stopping
+	httpServerMu.Lock()
+	srv := httpServer
+	httpServerMu.Unlock()
+	drained := make(chan error, 1)
+	go func() {
+		if srv == nil {
+			drained <- nil
+			return
+		}
+		drained <- srv.Shutdown(ctx)
+	}()
+	closeSockets()
+	if err := <-drained; err != nil {
+		slog.Error("Error draining requests", "error", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("Background work still running, closing anyway")
+	}
+
+	if err := DB.Close(); err != nil {
+		slog.Error("Error closing DB", "error", err)
+	}
+	close(shutdownDone)
+}
+
+// Send a "going away" close frame to every WebSocket, and close them.
+// Their handlers then unregister them as on any disconnect.
+func closeSockets() {
+	var conns []*websocket.Conn
+	collect := func(mu *sync.Mutex, registry map[int]map[*websocket.Conn]bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, set := range registry {
+			for conn := range set {
+				conns = append(conns, conn)
+			}
+		}
+	}
+	collect(&connMutex, connections)
+	collect(&mutex, clients)
+	collect(&viewersMu, postViewers)
+	collect(&mu, onlineUsers)
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	deadline := time.Now().Add(time.Second)
+	for _, conn := range conns {
+		conn.WriteControl(websocket.CloseMessage, msg, deadline)
+		conn.Close()
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -117,7 +118,7 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 			// Read the image data (limit size)
 			profilePic, err = LimitRead(part, maxPicSize)
 			if err != nil {
-				JsonError(w, "Profile picture too large (1mb max)", http.StatusBadRequest, err)
+				JsonError(w, "Profile picture too large ("+formatSize(maxPicSize)+" max)", http.StatusBadRequest, err)
 				return
 			}
 		}
@@ -159,13 +160,12 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 
 	var profilePicPath string
 	if len(profilePic) > 0 {
-		// For safety, also check if it's an SVG
-		if isSVG(profilePic) {
-			JsonError(w, "svg images aren't supported", http.StatusUnauthorized, nil)
-			return
-		}
 		// Save the profile picture to disk
 		profilePicPath, err = SaveImg(profilePic)
+		if errors.Is(err, ErrImage) {
+			JsonError(w, err.Error(), http.StatusBadRequest, err)
+			return
+		}
 		if err != nil {
 			JsonError(w, "Failed to save profile image", http.StatusInternalServerError, err)
 			return
@@ -182,7 +182,7 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 	(email, username, password, first_name, last_name, age, gender, profile_pic)
 	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err = DB.Exec(insertUser,
+	res, err := DB.Exec(insertUser,
 		user.Email,
 		user.Username,
 		hashedPassword,
@@ -196,6 +196,9 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 		JsonError(w, "unexpected error, try again later", http.StatusInternalServerError, err)
 		return
 	}
+	if userID, err := res.LastInsertId(); err == nil {
+		Publish(UserRegistered{UserID: int(userID), Username: user.Username})
+	}
 
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("User created successfully"))
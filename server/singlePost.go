@@ -1,9 +1,9 @@
 package server
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
-	"strconv"
 )
 
 // Serve the Post json
@@ -18,37 +18,66 @@ func SinglePostHandler(w http.ResponseWriter, r *http.Request) {
 		JsonError(w, "Missing post_id", http.StatusBadRequest, nil)
 		return
 	}
-	var post Post
+	post, err := LoadPost(postID, ViewerID(r))
+	if err == sql.ErrNoRows {
+		JsonError(w, "Post not found", http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		JsonError(w, "Failed to load post", http.StatusInternalServerError, err)
+		return
+	}
 
-	// SELECT the columns that match post ID
+	// Return JSON
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(post)
+}
+
+// Post with its details and poll, sql.ErrNoRows if missing or hidden to the viewer.
+func LoadPost(postID any, viewerID int) (*Post, error) {
+	var post Post
 	err := DB.QueryRow(`
-        SELECT p.id, p.title, p.content, u.username, p.image, p.created_at, u.profile_pic
+        SELECT p.id, p.user_id, p.title, p.content, u.username, p.image, p.created_at, u.profile_pic, u.is_bot
         FROM posts p
         JOIN users u ON p.user_id = u.id
         WHERE p.id = ?`,
 		postID,
 	).Scan(
 		&post.ID,
+		&post.UserID,
 		&post.Title,
 		&post.Content,
 		&post.Username,
 		&post.Image,
 		&post.CreatedAt,
 		&post.ProfilePic,
+		&post.IsBot,
 	)
 	if err != nil {
-		JsonError(w, "Post not found or DB error: "+err.Error(), http.StatusNotFound, err)
-		return
+		return nil, err
 	}
 
-	// Fetch post categories/tags
-	pid, _ := strconv.Atoi(postID)
-	cats, err := FetchPostCategories(pid)
-	if err == nil {
-		post.Categories = cats
+	// Authors blocked or muted by the viewer don't exist for them
+	hidden, err := IsHidden(viewerID, post.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if hidden {
+		return nil, sql.ErrNoRows
 	}
 
-	// Return JSON
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(post)
+	post.ContentHTML = RenderMarkdown(post.Content)
+
+	// Fetch post categories/tags, counters and viewer's reaction
+	single := []Post{post}
+	if err := LoadPostDetails(single, viewerID); err != nil {
+		return nil, err
+	}
+	post = single[0]
+
+	post.Poll, err = LoadPoll(post.ID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
 }
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Unreferenced uploads are kept this long, for the post, draft or
+	// profile being saved with them.
+	mediaGrace      = 24 * time.Hour
+	mediaSweepEvery = time.Hour
+	// Num of users on each page of the storage report.
+	storageReportLimit = 50
+)
+
+// Serialises dedup lookups with sweeps, so a reused file isn't deleted.
+var mediaMu sync.Mutex
+
+// Storage usage, for admins.
+type StorageReport struct {
+	Files             int           `json:"files"`
+	Bytes             int64         `json:"bytes"`
+	UnreferencedFiles int           `json:"unreferenced_files"`
+	UnreferencedBytes int64         `json:"unreferenced_bytes"`
+	Users             []UserStorage `json:"users"`
+}
+
+// Uploads used by a user's posts, galleries, drafts and profile pic.
+type UserStorage struct {
+	Username string `json:"username"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// Name of an already uploaded file, sql.ErrNoRows if new.
+// Pushes back its sweep, call with mediaMu held.
+func touchMedia(hash string) (string, error) {
+	var name string
+	err := DB.QueryRow(`
+        UPDATE media SET touched_at = CURRENT_TIMESTAMP
+        WHERE hash = ?
+        RETURNING name`,
+		hash,
+	).Scan(&name)
+	return name, err
+}
+
+// Drop an upload that isn't referenced anymore by its owner.
+// Tracked uploads may be shared and are left to the sweeper,
+// uploads from before the media table had a single owner.
+func releaseUpload(name string) {
+	if name == "" || name == "avatar.webp" {
+		return
+	}
+	var tracked bool
+	if err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM media WHERE name = ?)`, name).Scan(&tracked); err != nil {
+		slog.Error("Failed to release upload", "error", err)
+		return
+	}
+	if tracked {
+		return
+	}
+	if err := removeUpload(name); err != nil {
+		slog.Error("Failed to remove upload", "error", err)
+	}
+}
+
+// Delete the uploads unreferenced for longer than the grace period.
+func SweepMedia(ctx context.Context) error {
+	cutoff := time.Now().Add(-mediaGrace).UTC().Format(sqlTimeLayout)
+	rows, err := DB.QueryContext(ctx, `SELECT name FROM media WHERE refs <= 0 AND touched_at < ?`, cutoff)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		// The rest are swept on the next run
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := sweepUpload(ctx, name, cutoff); err != nil {
+			slog.Error("Failed to sweep upload", "name", name, "error", err)
+		}
+	}
+	return nil
+}
+
+// Delete the files then the row, if still unreferenced.
+// A failed delete leaves the row for the next sweep.
+func sweepUpload(ctx context.Context, name, cutoff string) error {
+	mediaMu.Lock()
+	defer mediaMu.Unlock()
+
+	var unreferenced bool
+	err := DB.QueryRowContext(ctx, `
+        SELECT EXISTS(SELECT 1 FROM media WHERE name = ? AND refs <= 0 AND touched_at < ?)`,
+		name, cutoff,
+	).Scan(&unreferenced)
+	if err != nil || !unreferenced {
+		return err
+	}
+	if err := removeUpload(name); err != nil {
+		return err
+	}
+	_, err = DB.ExecContext(ctx, `DELETE FROM media WHERE name = ?`, name)
+	return err
+}
+
+// Storage used in total, by unreferenced uploads, and per user (largest first).
+func GetStorageReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+	if !user.IsAdmin {
+		JsonError(w, "Admins only", http.StatusForbidden, nil)
+		return
+	}
+
+	offsetParam := r.URL.Query().Get("offset")
+	if offsetParam == "" {
+		offsetParam = "0"
+	}
+	offset, err := strconv.Atoi(offsetParam)
+	if err != nil {
+		JsonError(w, "Bad request", http.StatusBadRequest, err)
+		return
+	}
+
+	var report StorageReport
+	err = DB.QueryRow(`
+        SELECT
+            COUNT(*),
+            COALESCE(SUM(size), 0),
+            COALESCE(SUM(refs <= 0), 0),
+            COALESCE(SUM(CASE WHEN refs <= 0 THEN size ELSE 0 END), 0)
+        FROM media`,
+	).Scan(&report.Files, &report.Bytes, &report.UnreferencedFiles, &report.UnreferencedBytes)
+	if err != nil {
+		JsonError(w, "Failed to compute storage usage", http.StatusInternalServerError, err)
+		return
+	}
+
+	// A file used twice by the same user counts once (UNION)
+	rows, err := DB.Query(`
+        SELECT u.username, COUNT(*), SUM(m.size) AS bytes
+        FROM (
+            SELECT user_id, image AS name FROM posts
+            UNION SELECT p.user_id, pm.name FROM post_media pm JOIN posts p ON p.id = pm.post_id
+            UNION SELECT user_id, image FROM post_drafts
+            UNION SELECT d.user_id, json_extract(j.value, '$.name') FROM post_drafts d, json_each(d.media) j
+            UNION SELECT id, profile_pic FROM users
+        ) used
+        JOIN media m ON m.name = used.name
+        JOIN users u ON u.id = used.user_id
+        GROUP BY u.id
+        ORDER BY bytes DESC, u.id
+        LIMIT ? OFFSET ?`,
+		storageReportLimit, offset,
+	)
+	if err != nil {
+		JsonError(w, "Failed to compute storage usage", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	report.Users = []UserStorage{}
+	for rows.Next() {
+		var u UserStorage
+		if err := rows.Scan(&u.Username, &u.Files, &u.Bytes); err != nil {
+			JsonError(w, "Failed reading storage usage", http.StatusInternalServerError, err)
+			return
+		}
+		report.Users = append(report.Users, u)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
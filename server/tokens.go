@@ -0,0 +1,339 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scopes of personal access tokens. Routes with no scope need a session.
+const (
+	ScopeRead    = "read"    // GET routes
+	ScopePost    = "post"    // Posts and drafts
+	ScopeComment = "comment" // Comments, reactions and poll votes
+	ScopeMessage = "message" // Private messages
+)
+
+var tokenScopes = []string{ScopeRead, ScopePost, ScopeComment, ScopeMessage}
+
+const (
+	tokenPrefix       = "dwi_"
+	maxTokensPerUser  = 20
+	maxTokenNameSize  = 50
+	defaultTokenDays  = 90
+	maxTokenDays      = 365
+	tokenUsedInterval = time.Minute // last_used_at precision, saves a write per request
+)
+
+var (
+	ErrInvalidToken    = errors.New("invalid or expired token")
+	ErrTokenScope      = errors.New("token lacks the scope of this route")
+	ErrTokenNotAllowed = errors.New("this route needs a session")
+)
+
+// Personal access token, the secret is only in the reply that creates it.
+type APIToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+type scopeContextKey struct{}
+type tokenUserContextKey struct{}
+
+// Scope a token needs for the route of the request, "" when tokens can't be used.
+func RouteScope(r *http.Request) string {
+	scope, _ := r.Context().Value(scopeContextKey{}).(string)
+	return scope
+}
+
+// Token of an "Authorization: Bearer" header.
+func BearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// Set the scope of a route. Token requests are authenticated here, so
+// token errors are reported as such (403 for a missing scope) and
+// handlers reuse the user.
+func requireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), scopeContextKey{}, scope))
+		if _, ok := BearerToken(r); !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := GetUser(r)
+		if errors.Is(err, ErrTokenScope) || errors.Is(err, ErrTokenNotAllowed) {
+			JsonError(w, "Forbidden: "+err.Error(), http.StatusForbidden, nil)
+			return
+		}
+		if err != nil {
+			JsonError(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenUserContextKey{}, user)))
+	})
+}
+
+// User of a token, if it has the scope of the route.
+func userFromToken(token string, scope string) (*User, error) {
+	var tokenID int
+	var scopes string
+	var expiresAt time.Time
+	var user User
+	err := DB.QueryRow(`
+        SELECT t.id, t.scopes, t.expires_at, u.id, u.email, u.username, u.profile_pic, u.is_admin, u.is_bot
+        FROM api_tokens t
+        JOIN users u ON u.id = t.user_id
+        WHERE t.token_hash = ?`, hashToken(token)).Scan(
+		&tokenID, &scopes, &expiresAt, &user.ID, &user.Email, &user.Username, &user.ProfilePic, &user.IsAdmin, &user.IsBot,
+	)
+	if err != nil || time.Now().After(expiresAt) {
+		return nil, ErrInvalidToken
+	}
+	if scope == "" {
+		return nil, ErrTokenNotAllowed
+	}
+	if !slices.Contains(strings.Split(scopes, ","), scope) {
+		return nil, ErrTokenScope
+	}
+
+	now := time.Now().UTC()
+	DB.Exec(`
+        UPDATE api_tokens SET last_used_at = ?
+        WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`,
+		now.Format(sqlTimeLayout), tokenID, now.Add(-tokenUsedInterval).Format(sqlTimeLayout))
+	return &user, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// List the tokens of the logged in user.
+func GetTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	rows, err := DB.Query(`
+        SELECT id, name, prefix, scopes, created_at, expires_at, last_used_at
+        FROM api_tokens
+        WHERE user_id = ?
+        ORDER BY created_at DESC, id DESC`, user.ID)
+	if err != nil {
+		JsonError(w, "Failed to fetch tokens", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var t APIToken
+		var scopes string
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, &scopes, &t.CreatedAt, &t.ExpiresAt, &lastUsed); err != nil {
+			JsonError(w, "Failed to fetch tokens", http.StatusInternalServerError, err)
+			return
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		if lastUsed.Valid {
+			t.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		JsonError(w, "Failed to fetch tokens", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Create a token, its secret is only shown in this reply.
+func CreateToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	// Limit the size of the request body to 4 KB
+	r.Body = http.MaxBytesReader(w, r.Body, 4000)
+
+	var payload struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"` // Default 90
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		JsonError(w, "Invalid request body", http.StatusBadRequest, err)
+		return
+	}
+
+	name := strings.TrimSpace(payload.Name)
+	if name == "" || len([]rune(name)) > maxTokenNameSize {
+		JsonError(w, "Token name must be 1 to "+strconv.Itoa(maxTokenNameSize)+" characters", http.StatusBadRequest, nil)
+		return
+	}
+	if len(payload.Scopes) == 0 {
+		JsonError(w, "Pick at least one scope", http.StatusBadRequest, nil)
+		return
+	}
+	var scopes []string
+	for _, scope := range payload.Scopes {
+		if !slices.Contains(tokenScopes, scope) {
+			JsonError(w, "Unknown scope "+scope, http.StatusBadRequest, nil)
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	days := payload.ExpiresInDays
+	if days == 0 {
+		days = defaultTokenDays
+	}
+	if days < 1 || days > maxTokenDays {
+		JsonError(w, "Tokens expire in 1 to "+strconv.Itoa(maxTokenDays)+" days", http.StatusBadRequest, nil)
+		return
+	}
+
+	var count int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM api_tokens WHERE user_id = ?`, user.ID).Scan(&count); err != nil {
+		JsonError(w, "Failed to create token", http.StatusInternalServerError, err)
+		return
+	}
+	if count >= maxTokensPerUser {
+		JsonError(w, "You can have up to "+strconv.Itoa(maxTokensPerUser)+" tokens", http.StatusConflict, nil)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		JsonError(w, "Failed to create token", http.StatusInternalServerError, err)
+		return
+	}
+	token := APIToken{
+		Name:      name,
+		Token:     tokenPrefix + base64.RawURLEncoding.EncodeToString(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	token.Prefix = token.Token[:len(tokenPrefix)+6]
+	token.ExpiresAt = token.CreatedAt.AddDate(0, 0, days)
+
+	res, err := DB.Exec(`
+        INSERT INTO api_tokens (user_id, name, prefix, token_hash, scopes, created_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		user.ID, token.Name, token.Prefix, hashToken(token.Token), strings.Join(scopes, ","),
+		token.CreatedAt.Format(sqlTimeLayout), token.ExpiresAt.Format(sqlTimeLayout))
+	if err != nil {
+		JsonError(w, "Failed to create token", http.StatusInternalServerError, err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	token.ID = int(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(token)
+}
+
+// Revoke a token of the logged in user.
+func DeleteToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		JsonError(w, "Invalid token id", http.StatusBadRequest, err)
+		return
+	}
+
+	res, err := DB.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, user.ID)
+	if err != nil {
+		JsonError(w, "Failed to delete token", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		JsonError(w, "Token not found", http.StatusNotFound, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"token_id": id})
+}
+
+// Mark or unmark the logged in user's account as a bot.
+func SetBotAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return
+	}
+
+	// Limit the size of the request body to 1 KB
+	r.Body = http.MaxBytesReader(w, r.Body, 1000)
+
+	var payload struct {
+		IsBot bool `json:"is_bot"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		JsonError(w, "Invalid request body", http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := DB.Exec(`UPDATE users SET is_bot = ? WHERE id = ?`, payload.IsBot, user.ID); err != nil {
+		JsonError(w, "Failed to update account", http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"is_bot": payload.IsBot})
+}
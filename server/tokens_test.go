@@ -0,0 +1,117 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// User with a session, returns their id.
+func insertUser(t *testing.T, username, session string, admin bool) int {
+	t.Helper()
+	res, err := DB.Exec(`
+        INSERT INTO users (email, username, password, first_name, last_name, age, gender, is_admin)
+        VALUES (?, ?, '', 'First', 'Last', 20, 'male', ?)`,
+		username+"@example.com", username, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	expires := time.Now().UTC().Add(time.Hour).Format(sqlTimeLayout)
+	if _, err := DB.Exec(`INSERT INTO sessions (user_id, token, expires_at) VALUES (?, ?, ?)`, id, session, expires); err != nil {
+		t.Fatal(err)
+	}
+	return int(id)
+}
+
+func insertToken(t *testing.T, userID int, token string, scopes []string, expiresAt time.Time) {
+	t.Helper()
+	_, err := DB.Exec(`
+        INSERT INTO api_tokens (user_id, name, prefix, token_hash, scopes, expires_at)
+        VALUES (?, 'test', ?, ?, ?, ?)`,
+		userID, token[:len(tokenPrefix)+6], hashToken(token), strings.Join(scopes, ","), expiresAt.UTC().Format(sqlTimeLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	testDB(t)
+	adminID := insertUser(t, "admin", "admin-session", true)
+	insertToken(t, adminID, "dwi_readtoken", []string{ScopeRead}, time.Now().Add(time.Hour))
+	insertToken(t, adminID, "dwi_posttoken", []string{ScopeRead, ScopePost}, time.Now().Add(time.Hour))
+	insertToken(t, adminID, "dwi_oldtoken", []string{ScopeRead, ScopePost}, time.Now().Add(-time.Hour))
+	api := &apiRouter{rl: NewRateLimiter(0)}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		token   string // Bearer token
+		cookie  string // Session token
+		want    int
+		wantMsg string
+	}{
+		{"read token on a read route", "GET", "/posts", "dwi_readtoken", "", http.StatusOK, ""},
+		{"read token creating a post", "POST", "/posts", "dwi_readtoken", "", http.StatusForbidden, ErrTokenScope.Error()},
+		{"post token creating a post", "POST", "/posts", "dwi_posttoken", "", http.StatusUnsupportedMediaType, ""},
+		{"expired token", "GET", "/posts", "dwi_oldtoken", "", http.StatusUnauthorized, ErrInvalidToken.Error()},
+		{"unknown token", "GET", "/posts", "dwi_unknown", "", http.StatusUnauthorized, ErrInvalidToken.Error()},
+		{"token listing tokens", "GET", "/tokens", "dwi_posttoken", "", http.StatusForbidden, ErrTokenNotAllowed.Error()},
+		{"token on an admin route", "GET", "/admin/jobs", "dwi_posttoken", "", http.StatusForbidden, ErrTokenNotAllowed.Error()},
+		{"cookie listing tokens", "GET", "/tokens", "", "admin-session", http.StatusOK, ""},
+		{"cookie on an admin route", "GET", "/admin/jobs", "", "admin-session", http.StatusOK, ""},
+		{"cookie creating a post", "POST", "/posts", "", "admin-session", http.StatusUnsupportedMediaType, ""},
+		{"unknown cookie", "GET", "/tokens", "", "nope", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, apiPrefix+tt.path, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "session_token", Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("%s %s = %d %s, want %d", tt.method, tt.path, w.Code, w.Body, tt.want)
+			}
+			if tt.wantMsg != "" && !strings.Contains(w.Body.String(), tt.wantMsg) {
+				t.Errorf("%s %s = %s, want the error %q", tt.method, tt.path, w.Body, tt.wantMsg)
+			}
+		})
+	}
+
+	var lastUsed sql.NullTime
+	DB.QueryRow(`SELECT last_used_at FROM api_tokens WHERE token_hash = ?`, hashToken("dwi_readtoken")).Scan(&lastUsed)
+	if !lastUsed.Valid {
+		t.Error("last_used_at not set by a successful request")
+	}
+	DB.QueryRow(`SELECT last_used_at FROM api_tokens WHERE token_hash = ?`, hashToken("dwi_oldtoken")).Scan(&lastUsed)
+	if lastUsed.Valid {
+		t.Error("last_used_at set by an expired token")
+	}
+}
+
+// Tokens reach the old routes through the same checks.
+func TestTokenAuthLegacy(t *testing.T) {
+	testDB(t)
+	userID := insertUser(t, "user", "user-session", false)
+	insertToken(t, userID, "dwi_readtoken", []string{ScopeRead}, time.Now().Add(time.Hour))
+	mux := http.NewServeMux()
+	RegisterAPI(mux, NewRateLimiter(0))
+
+	r := httptest.NewRequest("POST", "/api/create-post", nil)
+	r.Header.Set("Authorization", "Bearer dwi_readtoken")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("read token on /api/create-post = %d %s, want 403", w.Code, w.Body)
+	}
+}
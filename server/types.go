@@ -12,18 +12,46 @@ type User struct {
 	Age        int    `json:"age"`
 	Gender     string `json:"gender"`
 	ProfilePic string `json:"profile_pic"`
+	IsAdmin    bool   `json:"is_admin"`
+	IsBot      bool   `json:"is_bot"`
 }
 
 type Post struct {
-	ID         int        `json:"id"`
-	UserID     int        `json:"user_id"`
-	Title      string     `json:"title"`
-	Content    string     `json:"content"`
-	CreatedAt  time.Time  `json:"created_at"`
-	Username   string     `json:"username"`
-	ProfilePic string     `json:"profile_pic"`
-	Image      string     `json:"image"`
-	Categories []Category `json:"categories,omitempty"`
+	ID          int         `json:"id"`
+	UserID      int         `json:"user_id"`
+	Title       string      `json:"title"`
+	Content     string      `json:"content"`      // Markdown source
+	ContentHTML string      `json:"content_html"` // Rendered and sanitized
+	CreatedAt   time.Time   `json:"created_at"`
+	Username    string      `json:"username"`
+	ProfilePic  string      `json:"profile_pic"`
+	IsBot       bool        `json:"is_bot"` // Author is a bot
+	Image       string      `json:"image"`  // First of Media
+	Categories  []Category  `json:"categories,omitempty"`
+	Media       []PostMedia `json:"media"` // Filled by LoadPostDetails
+	// Cards of the links of Content, filled by LoadPostDetails
+	Previews []LinkPreview `json:"previews"`
+	// Derived sizes of the uploads, filled by LoadPostDetails
+	ImageThumb      string `json:"image_thumb"`
+	ImageMedium     string `json:"image_medium"`
+	ProfilePicThumb string `json:"profile_pic_thumb"`
+	// Counters and viewer's reaction, filled by LoadPostDetails
+	Likes         int    `json:"likes"`
+	Dislikes      int    `json:"dislikes"`
+	CommentsCount int    `json:"comments_count"`
+	UserReaction  string `json:"user_reaction"`
+	Bookmarked    bool   `json:"bookmarked"`
+	BookmarkNote  string `json:"bookmark_note,omitempty"` // Private to the viewer
+	Poll          *Poll  `json:"poll,omitempty"`          // Single post only
+}
+
+// Image of a post gallery.
+type PostMedia struct {
+	Name     string `json:"name"`
+	Thumb    string `json:"thumb"`
+	Medium   string `json:"medium"`
+	Alt      string `json:"alt"`
+	Position int    `json:"position"`
 }
 
 type Category struct {
@@ -32,11 +60,14 @@ type Category struct {
 }
 
 type Comment struct {
-	ID         int       `json:"id"`
-	Username   string    `json:"username"`
-	Content    string    `json:"content"`
-	ProfilePic string    `json:"profile_pic"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID              int       `json:"id"`
+	Username        string    `json:"username"`
+	Content         string    `json:"content"`      // Markdown source
+	ContentHTML     string    `json:"content_html"` // Rendered and sanitized
+	ProfilePic      string    `json:"profile_pic"`
+	ProfilePicThumb string    `json:"profile_pic_thumb"`
+	IsBot           bool      `json:"is_bot"`
+	CreatedAt       time.Time `json:"created_at"`
 }
 
 type Notification struct {
@@ -62,12 +93,19 @@ type NotificationDeletion struct {
 	Action  string   `json:"action"` // "delete"
 }
 
+// Live unread notifications counter.
+type UnreadCountEvent struct {
+	Action string `json:"action"` // "unread_count"
+	Count  int    `json:"count"`
+}
+
 type Message struct {
-	ID        int       `json:"id"`
-	Sender    string    `json:"sender"`
-	Receiver  string    `json:"receiver"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        int           `json:"id"`
+	Sender    string        `json:"sender"`
+	Receiver  string        `json:"receiver"`
+	Content   string        `json:"content"`
+	CreatedAt time.Time     `json:"created_at"`
+	Previews  []LinkPreview `json:"previews"` // Cards of the links of Content
 }
 
 type TypingEvent struct {
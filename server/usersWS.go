@@ -3,6 +3,7 @@ package server
 import (
 	"database/sql"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
@@ -82,13 +83,20 @@ func BroadcastOnlineUsers() {
 	mu.Lock()
 	defer mu.Unlock()
 
-	// For each connected user, build a list of online users (excluding themselves)
+	// For each connected user, build a list of online users
+	// (excluding themselves, and users they blocked, muted or are blocked by)
 	for recipientID, recipientConns := range onlineUsers {
 		var users []OnlineUserInfo
 
+		hidden, err := HiddenUsers(recipientID)
+		if err != nil {
+			slog.Error("Failed to get hidden users", "error", err)
+			continue
+		}
+
 		// Get the list of online users
 		for userID := range onlineUsers {
-			if userID == recipientID {
+			if userID == recipientID || hidden[userID] {
 				continue
 			}
 			username := GetUsername(userID)
@@ -160,9 +168,19 @@ func UpdateOnlineUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update each user's LastMsg field
-	for i, user := range req.Users {
+	hidden, err := HiddenUsers(currentUser.ID)
+	if err != nil {
+		JsonError(w, "Failed to update users", http.StatusInternalServerError, err)
+		return
+	}
+
+	// Update each user's LastMsg field, drop hidden users
+	users := make([]OnlineUserInfo, 0, len(req.Users))
+	for _, user := range req.Users {
 		userID := GetUserID(user.Username) // Fetch user ID based on username
+		if hidden[userID] {
+			continue
+		}
 
 		// Get updated data
 		username := GetUsername(userID)
@@ -173,16 +191,16 @@ func UpdateOnlineUsers(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Assign updated info back
-		req.Users[i] = OnlineUserInfo{
+		users = append(users, OnlineUserInfo{
 			Username:   username,
 			ProfilePic: profilePic,
 			LastMsg:    lastMsg,
-		}
+		})
 	}
 
 	// Respond with updated user list
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(req.Users)
+	json.NewEncoder(w).Encode(users)
 }
 
 // Helper function to get user ID from username
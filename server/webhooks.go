@@ -0,0 +1,654 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Events sent to webhooks.
+const (
+	EventPostCreated    = "post.created"
+	EventCommentCreated = "comment.created"
+	EventReactionAdded  = "reaction.added"
+	EventUserRegistered = "user.registered"
+)
+
+var webhookEvents = []string{EventPostCreated, EventCommentCreated, EventReactionAdded, EventUserRegistered}
+
+const (
+	webhookTimeout     = 10 * time.Second
+	webhookMaxAttempts = 8
+	webhookBackoff     = 30 * time.Second // Doubled after each failed attempt
+	webhookMaxBackoff  = 6 * time.Hour
+	webhookPollEvery   = 15 * time.Second
+	webhookBatch       = 20
+	maxWebhooks        = 20
+	maxWebhookURL      = 2048
+	// Num of deliveries on each page of the log.
+	deliveriesLimit = 50
+	// Start of failed responses kept in the log.
+	webhookErrorSize = 300
+)
+
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	Secret    string    `json:"secret,omitempty"` // Only when created
+}
+
+// Attempted or pending delivery of an event to a webhook.
+type WebhookDelivery struct {
+	ID             int             `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"` // pending, succeeded or failed
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	ResponseStatus *int            `json:"response_status"`
+	LastError      string          `json:"last_error"`
+	ReplayOf       *int            `json:"replay_of"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+// Body of deliveries.
+type WebhookPayload struct {
+	ID        string    `json:"id"` // Same for each webhook, and on retries
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Data of comment.created.
+type CommentEvent struct {
+	Comment
+	PostID int `json:"post_id"`
+	UserID int `json:"user_id"`
+}
+
+// Data of reaction.added, also sent when a reaction changes.
+type ReactionEvent struct {
+	Target   string `json:"target"` // post or comment
+	ID       int    `json:"id"`
+	Reaction string `json:"reaction"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// Data of user.registered.
+type UserEvent struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+// Wakes the delivery loop when events are queued.
+var webhookWake = make(chan struct{}, 1)
+
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	// A redirect is a failed delivery, fix the URL instead
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Queue an event for the webhooks subscribed to it.
+func EmitWebhook(event string, data any) {
+	rows, err := DB.Query(`SELECT id, events FROM webhooks WHERE active = 1`)
+	if err != nil {
+		slog.Error("Failed to load webhooks", "error", err)
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		var events string
+		if err := rows.Scan(&id, &events); err != nil {
+			rows.Close()
+			slog.Error("Failed to load webhooks", "error", err)
+			return
+		}
+		if slices.Contains(strings.Split(events, ","), event) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return
+	}
+
+	eventID, err := uuid.NewV4()
+	if err != nil {
+		slog.Error("Failed to queue webhook event", "error", err)
+		return
+	}
+	payload, err := json.Marshal(WebhookPayload{
+		ID:        eventID.String(),
+		Event:     event,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Data:      data,
+	})
+	if err != nil {
+		slog.Error("Failed to queue webhook event", "error", err)
+		return
+	}
+
+	now := time.Now().UTC().Format(sqlTimeLayout)
+	for _, id := range ids {
+		_, err := DB.Exec(`
+            INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload, next_attempt_at)
+            VALUES (?, ?, ?, ?, ?)`, id, eventID.String(), event, string(payload), now)
+		if err != nil {
+			slog.Error("Failed to queue webhook delivery", "error", err)
+		}
+	}
+	wakeWebhooks()
+}
+
+func wakeWebhooks() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// Queue the forum's events for webhooks.
+func subscribeWebhooks() {
+	// New posts as guests see them in the API
+	SubscribeAsync(func(e PostCreated) {
+		post, err := LoadPost(e.PostID, 0)
+		if err != nil {
+			slog.Error("Failed to load post for webhooks", "error", err)
+			return
+		}
+		EmitWebhook(EventPostCreated, post)
+	})
+	SubscribeAsync(func(e CommentCreated) {
+		EmitWebhook(EventCommentCreated, CommentEvent{Comment: e.Comment, PostID: e.PostID, UserID: e.Author.ID})
+	})
+	SubscribeAsync(func(e ReactionAdded) {
+		EmitWebhook(EventReactionAdded, ReactionEvent{
+			Target:   e.Target,
+			ID:       e.TargetID,
+			Reaction: e.Reaction,
+			UserID:   e.User.ID,
+			Username: e.User.Username,
+		})
+	})
+	SubscribeAsync(func(e UserRegistered) {
+		EmitWebhook(EventUserRegistered, UserEvent{ID: e.UserID, Username: e.Username})
+	})
+}
+
+// Deliver due events, on wake up or every webhookPollEvery for retries.
+func StartWebhooks() {
+	goBackground(func() {
+		for !stopping() {
+			if err := deliverDueWebhooks(); err != nil {
+				slog.Error("Failed to deliver webhooks", "error", err)
+			}
+			select {
+			case <-webhookWake:
+			case <-time.After(webhookPollEvery):
+			case <-shutdownCtx.Done():
+			}
+		}
+	})
+}
+
+type dueDelivery struct {
+	id       int
+	attempts int
+	event    string
+	payload  string
+	url      string
+	secret   string
+}
+
+func deliverDueWebhooks() error {
+	for {
+		now := time.Now().UTC().Format(sqlTimeLayout)
+		rows, err := DB.Query(`
+            SELECT d.id, d.attempts, d.event, d.payload, w.url, w.secret
+            FROM webhook_deliveries d
+            JOIN webhooks w ON w.id = d.webhook_id
+            WHERE d.status = 'pending' AND d.next_attempt_at <= ? AND w.active = 1
+            ORDER BY d.next_attempt_at, d.id
+            LIMIT ?`, now, webhookBatch)
+		if err != nil {
+			return err
+		}
+		var due []dueDelivery
+		for rows.Next() {
+			var d dueDelivery
+			if err := rows.Scan(&d.id, &d.attempts, &d.event, &d.payload, &d.url, &d.secret); err != nil {
+				rows.Close()
+				return err
+			}
+			due = append(due, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, d := range due {
+			if stopping() {
+				return nil // Still pending, sent after restart
+			}
+			if err := deliverWebhook(d); err != nil {
+				return err
+			}
+		}
+		if len(due) < webhookBatch {
+			return nil
+		}
+	}
+}
+
+// Post a delivery and log the outcome, failures are retried with backoff.
+func deliverWebhook(d dueDelivery) error {
+	status, deliveryErr := postWebhook(d)
+	attempts := d.attempts + 1
+	now := time.Now().UTC()
+
+	var responseStatus any
+	if status != 0 {
+		responseStatus = status
+	}
+	if deliveryErr == nil {
+		_, err := DB.Exec(`
+            UPDATE webhook_deliveries
+            SET status = 'succeeded', attempts = ?, response_status = ?, last_error = '',
+                next_attempt_at = NULL, delivered_at = ?
+            WHERE id = ?`, attempts, responseStatus, now.Format(sqlTimeLayout), d.id)
+		return err
+	}
+
+	if attempts >= webhookMaxAttempts {
+		_, err := DB.Exec(`
+            UPDATE webhook_deliveries
+            SET status = 'failed', attempts = ?, response_status = ?, last_error = ?, next_attempt_at = NULL
+            WHERE id = ?`, attempts, responseStatus, deliveryErr.Error(), d.id)
+		return err
+	}
+	next := now.Add(webhookRetryDelay(attempts))
+	_, err := DB.Exec(`
+        UPDATE webhook_deliveries
+        SET attempts = ?, response_status = ?, last_error = ?, next_attempt_at = ?
+        WHERE id = ?`, attempts, responseStatus, deliveryErr.Error(), next.Format(sqlTimeLayout), d.id)
+	return err
+}
+
+// Delay before the next attempt: 30s, 1m, 2m... up to webhookMaxBackoff.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookBackoff
+	for i := 1; i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxBackoff)
+}
+
+// Send a delivery, a non 2xx status is an error.
+func postWebhook(d dueDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, d.url, strings.NewReader(d.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", siteName+"-webhooks")
+	req.Header.Set("X-Webhook-Event", d.event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(d.id))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhook(d.secret, timestamp, []byte(d.payload)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return resp.StatusCode, nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookErrorSize))
+	return resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+}
+
+// HMAC-SHA256 of "timestamp.body", hex encoded. Receivers compute the
+// same with their secret and reject old timestamps against replays.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Check the request comes from an admin, or write the error.
+func requireAdmin(w http.ResponseWriter, r *http.Request) (quit bool) {
+	user, err := GetUser(r)
+	if err != nil {
+		JsonError(w, "Unauthorized", http.StatusUnauthorized, err)
+		return true
+	}
+	if !user.IsAdmin {
+		JsonError(w, "Admins only", http.StatusForbidden, nil)
+		return true
+	}
+	return false
+}
+
+// List webhooks, without their secrets.
+func GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	if requireAdmin(w, r) {
+		return
+	}
+
+	rows, err := DB.Query(`SELECT id, url, events, active, created_at FROM webhooks ORDER BY id`)
+	if err != nil {
+		JsonError(w, "Failed to fetch webhooks", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		var h Webhook
+		var events string
+		if err := rows.Scan(&h.ID, &h.URL, &events, &h.Active, &h.CreatedAt); err != nil {
+			JsonError(w, "Failed to fetch webhooks", http.StatusInternalServerError, err)
+			return
+		}
+		h.Events = strings.Split(events, ",")
+		hooks = append(hooks, h)
+	}
+	if err := rows.Err(); err != nil {
+		JsonError(w, "Failed to fetch webhooks", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+// Subscribe a URL to events. The secret is generated when not given,
+// and only shown in this reply.
+func AddWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	if requireAdmin(w, r) {
+		return
+	}
+
+	// Limit the size of the request body to 8 KB
+	r.Body = http.MaxBytesReader(w, r.Body, 8000)
+
+	var payload struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		JsonError(w, "Invalid request body", http.StatusBadRequest, err)
+		return
+	}
+
+	u, err := url.Parse(payload.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(payload.URL) > maxWebhookURL {
+		JsonError(w, "Invalid webhook URL, expected http(s)://...", http.StatusBadRequest, err)
+		return
+	}
+	if len(payload.Events) == 0 {
+		JsonError(w, "Pick at least one event", http.StatusBadRequest, nil)
+		return
+	}
+	var events []string
+	for _, event := range payload.Events {
+		if !slices.Contains(webhookEvents, event) {
+			JsonError(w, "Unknown event "+event, http.StatusBadRequest, nil)
+			return
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+
+	secret := payload.Secret
+	if secret == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			JsonError(w, "Failed to add webhook", http.StatusInternalServerError, err)
+			return
+		}
+		secret = "whsec_" + hex.EncodeToString(key)
+	}
+
+	var count int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM webhooks`).Scan(&count); err != nil {
+		JsonError(w, "Failed to add webhook", http.StatusInternalServerError, err)
+		return
+	}
+	if count >= maxWebhooks {
+		JsonError(w, "You can have up to "+strconv.Itoa(maxWebhooks)+" webhooks", http.StatusConflict, nil)
+		return
+	}
+
+	hook := Webhook{
+		URL:       u.String(),
+		Events:    events,
+		Active:    true,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Secret:    secret,
+	}
+	res, err := DB.Exec(`INSERT INTO webhooks (url, secret, events, created_at) VALUES (?, ?, ?, ?)`,
+		hook.URL, hook.Secret, strings.Join(events, ","), hook.CreatedAt.Format(sqlTimeLayout))
+	if err != nil {
+		JsonError(w, "Failed to add webhook", http.StatusInternalServerError, err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	hook.ID = int(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// Delete a webhook and its delivery log.
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	if requireAdmin(w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		JsonError(w, "Invalid webhook id", http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		JsonError(w, "Failed to delete webhook", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		JsonError(w, "Failed to delete webhook", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		JsonError(w, "Webhook not found", http.StatusNotFound, nil)
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		JsonError(w, "Failed to delete webhook", http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		JsonError(w, "Failed to delete webhook", http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"webhook_id": id})
+}
+
+// Delivery log of a webhook, newest first.
+func GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	if requireAdmin(w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		JsonError(w, "Invalid webhook id", http.StatusBadRequest, err)
+		return
+	}
+	offsetParam := r.URL.Query().Get("offset")
+	if offsetParam == "" {
+		offsetParam = "0"
+	}
+	offset, err := strconv.Atoi(offsetParam)
+	if err != nil {
+		JsonError(w, "Bad request", http.StatusBadRequest, err)
+		return
+	}
+	filter, args := "", []any{id}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter = " AND status = ?"
+		args = append(args, status)
+	}
+	args = append(args, deliveriesLimit, offset)
+
+	rows, err := DB.Query(`
+        SELECT id, webhook_id, event_id, event, payload, status, attempts, next_attempt_at,
+               response_status, last_error, replay_of, created_at, delivered_at
+        FROM webhook_deliveries
+        WHERE webhook_id = ?`+filter+`
+        ORDER BY created_at DESC, id DESC
+        LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		JsonError(w, "Failed to fetch deliveries", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			JsonError(w, "Failed to fetch deliveries", http.StatusInternalServerError, err)
+			return
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		JsonError(w, "Failed to fetch deliveries", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func scanDelivery(rows *sql.Rows) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload string
+	var next, delivered sql.NullTime
+	var responseStatus, replayOf sql.NullInt64
+	err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &payload, &d.Status, &d.Attempts, &next,
+		&responseStatus, &d.LastError, &replayOf, &d.CreatedAt, &delivered)
+	if err != nil {
+		return d, err
+	}
+	d.Payload = json.RawMessage(payload)
+	if next.Valid {
+		d.NextAttemptAt = &next.Time
+	}
+	if delivered.Valid {
+		d.DeliveredAt = &delivered.Time
+	}
+	if responseStatus.Valid {
+		status := int(responseStatus.Int64)
+		d.ResponseStatus = &status
+	}
+	if replayOf.Valid {
+		original := int(replayOf.Int64)
+		d.ReplayOf = &original
+	}
+	return d, nil
+}
+
+// Send a delivery again, as a new delivery of the same event.
+func ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+	if requireAdmin(w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		JsonError(w, "Invalid delivery id", http.StatusBadRequest, err)
+		return
+	}
+
+	now := time.Now().UTC().Format(sqlTimeLayout)
+	res, err := DB.Exec(`
+        INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload, next_attempt_at, replay_of)
+        SELECT webhook_id, event_id, event, payload, ?, id
+        FROM webhook_deliveries
+        WHERE id = ?`, now, id)
+	if err != nil {
+		JsonError(w, "Failed to replay delivery", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		JsonError(w, "Delivery not found", http.StatusNotFound, nil)
+		return
+	}
+	replayID, _ := res.LastInsertId()
+	wakeWebhooks()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int64{"delivery_id": replayID})
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fresh database from schema.sql as DB, for the length of a test.
+func testDB(t *testing.T) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := os.ReadFile("../database/schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	previous := DB
+	DB = db
+	t.Cleanup(func() {
+		DB = previous
+		db.Close()
+	})
+	if err := migrateDB(); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+}
+
+// Webhook of url, returns its ID.
+func insertWebhook(t *testing.T, url, secret string) int {
+	t.Helper()
+	res, err := DB.Exec(`INSERT INTO webhooks (url, secret, events) VALUES (?, ?, ?)`, url, secret, EventPostCreated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return int(id)
+}
+
+// Pending delivery due now, returns it as the delivery loop reads it.
+func insertDelivery(t *testing.T, webhookID int, url, secret string) dueDelivery {
+	t.Helper()
+	payload := `{"id":"evt-1","event":"post.created","data":{"id":1}}`
+	res, err := DB.Exec(`
+        INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload, next_attempt_at)
+        VALUES (?, 'evt-1', ?, ?, ?)`,
+		webhookID, EventPostCreated, payload, time.Now().UTC().Format(sqlTimeLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return dueDelivery{id: int(id), event: EventPostCreated, payload: payload, url: url, secret: secret}
+}
+
+// Stored state of a delivery.
+func loadDelivery(t *testing.T, id int) WebhookDelivery {
+	t.Helper()
+	rows, err := DB.Query(`
+        SELECT id, webhook_id, event_id, event, payload, status, attempts, next_attempt_at,
+               response_status, last_error, replay_of, created_at, delivered_at
+        FROM webhook_deliveries WHERE id = ?`, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatalf("delivery %d not found", id)
+	}
+	d, err := scanDelivery(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"id":"evt-1"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	if got := SignWebhook("secret", "1700000000", body); got != want {
+		t.Errorf("SignWebhook = %s, want %s", got, want)
+	}
+	if SignWebhook("other", "1700000000", body) == want {
+		t.Error("signature doesn't depend on the secret")
+	}
+	if SignWebhook("secret", "1700000001", body) == want {
+		t.Error("signature doesn't depend on the timestamp")
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	d := dueDelivery{id: 42, event: EventPostCreated, payload: `{"id":"evt-1"}`, url: srv.URL, secret: "s3cret"}
+	status, err := postWebhook(d)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("postWebhook = %d, %v, want 204", status, err)
+	}
+
+	if string(gotBody) != d.payload {
+		t.Errorf("body = %s, want %s", gotBody, d.payload)
+	}
+	headers := map[string]string{
+		"Content-Type":       "application/json",
+		"X-Webhook-Event":    EventPostCreated,
+		"X-Webhook-Delivery": "42",
+	}
+	for name, want := range headers {
+		if v := got.Header.Get(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+
+	// Verified as a receiver would
+	timestamp := got.Header.Get("X-Webhook-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)).Abs() > time.Minute {
+		t.Errorf("X-Webhook-Timestamp = %q, want the current unix time", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte(d.secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(gotBody)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if sig := got.Header.Get("X-Webhook-Signature"); !hmac.Equal([]byte(sig), []byte(want)) {
+		t.Errorf("X-Webhook-Signature = %q, want %q", sig, want)
+	}
+}
+
+func TestPostWebhookFailures(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "receiver broke", http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	status, err := postWebhook(dueDelivery{url: srv.URL + "/error", payload: "{}"})
+	if status != http.StatusInternalServerError || err == nil || !strings.Contains(err.Error(), "receiver broke") {
+		t.Errorf("postWebhook(error) = %d, %v, want 500 with the response body", status, err)
+	}
+	// Redirects aren't followed
+	status, err = postWebhook(dueDelivery{url: srv.URL + "/moved", payload: "{}"})
+	if status != http.StatusFound || err == nil {
+		t.Errorf("postWebhook(moved) = %d, %v, want a failed 302", status, err)
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, webhookMaxBackoff},
+		{50, webhookMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := webhookRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("webhookRetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	testDB(t)
+
+	var mu sync.Mutex
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	setStatus := func(s int) {
+		mu.Lock()
+		status = s
+		mu.Unlock()
+	}
+
+	webhookID := insertWebhook(t, srv.URL, "secret")
+	d := insertDelivery(t, webhookID, srv.URL, "secret")
+
+	// Failed attempt, retried after the backoff
+	before := time.Now().UTC()
+	if err := deliverWebhook(d); err != nil {
+		t.Fatal(err)
+	}
+	got := loadDelivery(t, d.id)
+	if got.Status != "pending" || got.Attempts != 1 {
+		t.Errorf("after a failure: status %s, attempts %d, want pending, 1", got.Status, got.Attempts)
+	}
+	if got.ResponseStatus == nil || *got.ResponseStatus != http.StatusServiceUnavailable || !strings.Contains(got.LastError, "status 503") {
+		t.Errorf("after a failure: response %v, error %q, want 503", got.ResponseStatus, got.LastError)
+	}
+	wantNext := before.Add(webhookRetryDelay(1)).Truncate(time.Second)
+	if got.NextAttemptAt == nil || got.NextAttemptAt.Before(wantNext) || got.NextAttemptAt.After(wantNext.Add(5*time.Second)) {
+		t.Errorf("next attempt at %v, want about %v", got.NextAttemptAt, wantNext)
+	}
+
+	// Not due yet
+	if err := deliverDueWebhooks(); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadDelivery(t, d.id); got.Attempts != 1 {
+		t.Errorf("delivered before its next attempt, attempts %d", got.Attempts)
+	}
+
+	// Last attempt
+	d.attempts = webhookMaxAttempts - 1
+	if err := deliverWebhook(d); err != nil {
+		t.Fatal(err)
+	}
+	got = loadDelivery(t, d.id)
+	if got.Status != "failed" || got.Attempts != webhookMaxAttempts || got.NextAttemptAt != nil {
+		t.Errorf("after the last attempt: status %s, attempts %d, next %v, want failed, %d, none",
+			got.Status, got.Attempts, got.NextAttemptAt, webhookMaxAttempts)
+	}
+
+	// Due deliveries are sent by the loop
+	setStatus(http.StatusOK)
+	d2 := insertDelivery(t, webhookID, srv.URL, "secret")
+	if err := deliverDueWebhooks(); err != nil {
+		t.Fatal(err)
+	}
+	got = loadDelivery(t, d2.id)
+	if got.Status != "succeeded" || got.Attempts != 1 || got.DeliveredAt == nil || got.NextAttemptAt != nil || got.LastError != "" {
+		t.Errorf("after a success: %+v", got)
+	}
+	if got.ResponseStatus == nil || *got.ResponseStatus != http.StatusOK {
+		t.Errorf("after a success: response %v, want 200", got.ResponseStatus)
+	}
+}
+
+func TestReplayWebhookDelivery(t *testing.T) {
+	testDB(t)
+	webhookID := insertWebhook(t, "https://hooks.example.com/forum", "secret")
+	original := insertDelivery(t, webhookID, "", "")
+	if _, err := DB.Exec(`
+        UPDATE webhook_deliveries
+        SET status = 'failed', attempts = ?, next_attempt_at = NULL, last_error = 'status 500'
+        WHERE id = ?`, webhookMaxAttempts, original.id); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := func(id string, user *User) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/admin/webhook-deliveries/"+id+"/replay?id="+id, nil)
+		if user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), tokenUserContextKey{}, user))
+		}
+		w := httptest.NewRecorder()
+		ReplayWebhookDelivery(w, r)
+		return w
+	}
+	admin := &User{ID: 1, Username: "admin", IsAdmin: true}
+	id := strconv.Itoa(original.id)
+
+	if w := replay(id, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("replay without a user: %d, want 401", w.Code)
+	}
+	if w := replay(id, &User{ID: 2, Username: "user"}); w.Code != http.StatusForbidden {
+		t.Errorf("replay by a user: %d, want 403", w.Code)
+	}
+	if w := replay("9999", admin); w.Code != http.StatusNotFound {
+		t.Errorf("replay of a missing delivery: %d, want 404", w.Code)
+	}
+
+	w := replay(id, admin)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("replay: %d %s, want 201", w.Code, w.Body)
+	}
+	var reply struct {
+		DeliveryID int `json:"delivery_id"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&reply); err != nil || reply.DeliveryID == original.id {
+		t.Fatalf("replay reply: %+v, %v, want a new delivery", reply, err)
+	}
+
+	got := loadDelivery(t, reply.DeliveryID)
+	if got.ReplayOf == nil || *got.ReplayOf != original.id {
+		t.Errorf("replay_of = %v, want %d", got.ReplayOf, original.id)
+	}
+	if got.Status != "pending" || got.Attempts != 0 || got.NextAttemptAt == nil || got.LastError != "" {
+		t.Errorf("replay: status %s, attempts %d, next %v, error %q, want a due pending delivery",
+			got.Status, got.Attempts, got.NextAttemptAt, got.LastError)
+	}
+	// Same event, so receivers can tell it's a replay
+	if got.WebhookID != webhookID || got.EventID != "evt-1" || got.Event != EventPostCreated || string(got.Payload) != original.payload {
+		t.Errorf("replay of another event: %+v", got)
+	}
+
+	if before := loadDelivery(t, original.id); before.Status != "failed" || before.ReplayOf != nil {
+		t.Errorf("original changed: status %s, replay_of %v", before.Status, before.ReplayOf)
+	}
+}
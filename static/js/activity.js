@@ -11,7 +11,7 @@ function activityRenderer(username) {
         <div class="content-section">
             <div class="activity-card">
                 <div class="profile-image">
-                    <img src="../uploads/${ProfilePic}"
+                    <img src="../uploads/${imageVariant(ProfilePic, "medium")}"
                         alt="Profile Picture" />
                         <!-- Label for uploading a new picture -->
                         <label for="profilePic" class="edit-btn">
@@ -166,7 +166,7 @@ async function fetchLikedPosts(activityOffset, reaction) {
             return
         }
 
-        RenderPosts(posts, activityOffset, 100);
+        RenderPosts(posts, activityOffset);
     } catch (err) {
         console.error(err);
         DisplayError("errMsg", dynamicContent, "Error loading posts.");
@@ -196,7 +196,7 @@ async function fetchCommentedPosts(activityOffset) {
             endActivityFetch = true
             return
         }
-        RenderPosts(posts, activityOffset, 100);
+        RenderPosts(posts, activityOffset);
     } catch (err) {
         console.error(err);
         DisplayError("errMsg", dynamicContent, "Error loading user posts.");
@@ -286,10 +286,10 @@ function SetupImageUpdate() {
             // Update the image source with the new file from the server
             const imgElement = document.querySelector(".profile-image img");
             if (imgElement) {
-                imgElement.src = `../uploads/${data.profile_pic}`;
+                imgElement.src = `../uploads/${imageVariant(data.profile_pic, "medium")}`;
             }
             if (document.querySelector(".avatar-menu img")) {
-                document.querySelector(".avatar-menu img").src = `../uploads/${data.profile_pic}`;
+                document.querySelector(".avatar-menu img").src = `../uploads/${imageVariant(data.profile_pic, "thumb")}`;
             }
 
             ProfilePic = `${data.profile_pic}`;
@@ -38,7 +38,7 @@ function RenderComments(comments, isLoadMore) {
     }
 
     comments.forEach((comment) => {
-        const profilePic = comment.profile_pic || "../img/avatar.webp";
+        const profilePic = comment.profile_pic_thumb || "avatar.webp";
         const commentEl = document.createElement("div")
         
         commentEl.classList.add("comment-item")
@@ -47,11 +47,11 @@ function RenderComments(comments, isLoadMore) {
             <p class="comment-meta">
                 <div class="username">
                     <img src="../uploads/${profilePic}" alt="User Avatar" class="comment-user-avatar">
-                    <span class="username-select">${comment.username}</span>
+                    <span class="username-select">${comment.username}</span>${botBadge(comment.is_bot)}
                     <span class="time-ago" data-timestamp="${comment.created_at}">&nbsp• ${timeAgo(comment.created_at)}</span>
                 </div>
             </p>
-            <p class="comment-content">${comment.content}</p>
+            <div class="comment-content markdown">${comment.content_html}</div>
 
             <!-- Reaction Section for each comment -->
             <div class="comment-reaction-buttons">
@@ -108,6 +108,7 @@ async function AddComment(postID, content) {
         // Reset offset and fetch fresh comments
         commentOffset = 0
         await FetchComments(postID, false)
+        FetchCommentsCount(postID, document.getElementById("postDiv"))
 
         // Scroll up to the comments section
         document.querySelector(".reaction-buttons").scrollIntoView({ behavior: "smooth", block: "start" });
@@ -261,7 +261,7 @@ const NewPostForm = `
                     <span class="upload-icon">
                         <img src="../img/upload.svg" alt="Upload Icon" />
                     </span>
-                    <span class="upload-text-post">Upload Image (optional)</span>
+                    <span class="upload-text-post">Add Images (optional)</span>
                 </label>
 
                 <!-- The actual file input is hidden by CSS, but still clickable via the label -->
@@ -270,8 +270,32 @@ const NewPostForm = `
                     id="formPostImage"
                     class="post-input hidden-file-input"
                     accept="image/*"
+                    multiple
                 />
             </div>
+            <!-- One row per picked image, with its description -->
+            <div id="formPostImages" class="post-image-inputs"></div>
+
+            <!-- POLL INPUT -->
+            <button type="button" id="togglePoll" class="poll-toggle">Add a poll</button>
+            <div id="pollBuilder" class="poll-builder">
+                <label for="pollQuestion" class="post-label">Question</label>
+                <input
+                    type="text"
+                    id="pollQuestion"
+                    class="post-input"
+                    placeholder="Ask something..."
+                    maxlength="300" />
+                <div id="pollOptions">
+                    <input type="text" class="post-input poll-option-input" placeholder="Option 1" maxlength="100" />
+                    <input type="text" class="post-input poll-option-input" placeholder="Option 2" maxlength="100" />
+                </div>
+                <button type="button" id="addPollOption" class="poll-add-option">+ Add option</button>
+                <label class="poll-check"><input type="checkbox" id="pollMultiple" /> Allow several choices</label>
+                <label class="poll-check"><input type="checkbox" id="pollAnonymous" /> Anonymous votes</label>
+                <label for="pollClosesAt" class="post-label">Closes at (optional)</label>
+                <input type="datetime-local" id="pollClosesAt" class="post-input" />
+            </div>
 
             <button type="submit" class="post-submit">Publish</button>
         </form>
@@ -19,6 +19,11 @@ function timeAgo(date) {
     return `seconds ago`;
 }
 
+// Badge shown next to the username of bot accounts
+function botBadge(isBot) {
+    return isBot ? `<span class="bot-badge">bot</span>` : "";
+}
+
 // Updates all time-ago spans periodically
 function updateTimeAgo() {
     const timeAgoSpans = document.querySelectorAll('.time-ago');
@@ -49,12 +54,6 @@ function sortByQuery(array, query) {
     });
 }
 
-// Utility function to truncate long posts.
-function truncateContent(text, maxLength) {
-    if (!text || text.length <= maxLength) return text;
-    return text.slice(0, maxLength) + "...";
-}
-
 // Show a pop up welcome message.
 function welcomeMsg() {
     // Create the popup container
@@ -161,3 +160,36 @@ function getCookieValue(name) {
 function expireCookie(name) {
     document.cookie = `${name}=; expires=Thu, 01 Jan 1970 00:00:00 UTC; path=/`;
 }
+
+// Derived size of an upload, like ImageVariant in images.go. Uploads older
+// than the derived sizes are served their original instead.
+function imageVariant(name, size) {
+    const dot = name.lastIndexOf(".");
+    if (dot < 0) return `${name}_${size}`;
+    return `${name.slice(0, dot)}_${size}${name.slice(dot)}`;
+}
+
+// Cards of the links of a post or message. Titles and descriptions come
+// escaped, URLs without quotes nor angle brackets.
+function renderPreviews(previews) {
+    if (!previews || previews.length === 0) return "";
+    const cards = previews.map((p) => {
+        const site = p.site_name || new URL(p.url).hostname;
+        const image = p.image
+            ? `<img class="link-preview-image" src="${p.image}" alt="" loading="lazy" referrerpolicy="no-referrer">`
+            : "";
+        const description = p.description
+            ? `<span class="link-preview-description">${p.description}</span>`
+            : "";
+        return `
+            <a class="link-preview" href="${p.url}" target="_blank" rel="noopener noreferrer nofollow">
+                ${image}
+                <span class="link-preview-text">
+                    <span class="link-preview-site">${site}</span>
+                    <span class="link-preview-title">${p.title}</span>
+                    ${description}
+                </span>
+            </a>`;
+    }).join("");
+    return `<div class="link-previews">${cards}</div>`;
+}
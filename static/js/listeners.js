@@ -212,17 +212,11 @@ function NewPostListener() {
     });
 }
 
-// Listen for image upload in post form to display the filename.
+// Listen for avatar upload in signup form to display the filename.
 function imageUploaded(id) {
-    let uploadText
     const fileInput = document.getElementById(id);
     if (!fileInput) return;
-
-    if (id === "formPostImage") {
-        uploadText = document.querySelector(".upload-text-post");
-    } else {
-        uploadText = document.querySelector(".upload-text");
-    }
+    const uploadText = document.querySelector(".upload-text");
 
     fileInput.addEventListener("change", () => {
         if (!fileInput.files || fileInput.files.length === 0) {
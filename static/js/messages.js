@@ -11,7 +11,7 @@ async function loadMessages(selectedUsername, profilePic) {
     dynamicContent.innerHTML = `
       <div id="chatContainer" data-username="${selectedUsername}">
         <div id="chatHeader">
-            <img src="../uploads/${profilePic || 'avatar.webp'}" alt="${selectedUsername}" class="chat-profile-pic">
+            <img src="../uploads/${imageVariant(profilePic || 'avatar.webp', 'thumb')}" alt="${selectedUsername}" class="chat-profile-pic">
             <span id="chatUsername">${selectedUsername}</span>
         </div>
         <div id="chatMessages" class="chat-messages">
@@ -117,6 +117,7 @@ async function fetchMoreMessages(selectedUsername, prepend = false) {
 
             messageElement.innerHTML = `
                 <p>${msg.content}</p>
+                ${renderPreviews(msg.previews)}
                 <span class="message-time">${formatTime(msg.created_at)}</span>
             `;
 
@@ -192,6 +193,7 @@ async function sendMessage(receiver) {
         messageElement.className = "message sent";
         messageElement.innerHTML = `
             <p>${messageContent}</p>
+            ${renderPreviews(data.previews)}
             <span class="message-time">${formatTime(new Date())}</span>
         `;
         chatMessages.appendChild(messageElement);
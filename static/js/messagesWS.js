@@ -14,7 +14,7 @@ function connectMessagesWS() {
                 return;
             }
             // Append the received message to the chat UI
-            appendMessage(msg.sender, msg.content);
+            appendMessage(msg.sender, msg.content, msg.previews);
             updateOnlineUsers()
             NotifyMsg(msg.sender);
         } catch (e) {
@@ -27,7 +27,7 @@ function connectMessagesWS() {
     };
 }
 
-function appendMessage(sender, content) {
+function appendMessage(sender, content, previews) {
     const chatMessages = document.getElementById("chatMessages");
     if (!chatMessages) return;
 
@@ -46,6 +46,7 @@ function appendMessage(sender, content) {
 
     messageElement.innerHTML = `
         <p>${content}</p>
+        ${renderPreviews(previews)}
         <span class="message-time">${formatTime(new Date())}</span>
     `;
     msgUsername.style.marginTop = "-10px"
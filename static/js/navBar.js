@@ -17,7 +17,7 @@ function ShowLoggedInNav(username, profilePicture) {
     </div>
     <div class="avatar-menu">
         <img 
-            src="../uploads/${profilePicture}" 
+            src="../uploads/${imageVariant(profilePicture, 'thumb')}" 
             alt="Profile Avatar" 
             class="avatar-img" 
         />
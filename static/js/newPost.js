@@ -6,18 +6,11 @@ async function HandleNewPost(e, categories, clearTags) {
     const newPostModal = document.getElementById("newPostModal");
     const titleInput = document.getElementById("formPostTitle");
     const contentInput = document.getElementById("formPostContent");
-    const imageInput = document.getElementById("formPostImage");
 
     let title = titleInput.value.trim();
     let content = contentInput.value.trim();
 
-    // Determine file size (in bytes) if an image is selected
-    let imageSize = 0;
-    if (imageInput.files && imageInput.files[0]) {
-        imageSize = imageInput.files[0].size;
-    }
-
-    if (CheckPostSize(content.length, title.length, imageSize, e.target)) {
+    if (CheckPostSize(content.length, title.length, postImages, e.target)) {
         return
     }
 
@@ -26,10 +19,15 @@ async function HandleNewPost(e, categories, clearTags) {
         formData.append("title", title)
         formData.append("content", content)
         formData.append("categories", JSON.stringify(categories))
+        const poll = ReadPollForm();
+        if (poll) {
+            formData.append("poll", JSON.stringify(poll));
+        }
 
-        // If an image is selected, attach to FormData
-        if (imageInput.files && imageInput.files[0]) {
-            formData.append("image", imageInput.files[0]);
+        // Images in gallery order, their descriptions in the same order
+        if (postImages.length > 0) {
+            postImages.forEach((img) => formData.append("image", img.file));
+            formData.append("alts", JSON.stringify(postImages.map((img) => img.alt.trim())));
         }
 
         const res = await fetch("/api/create-post", {
@@ -48,8 +46,8 @@ async function HandleNewPost(e, categories, clearTags) {
             // Clear form fields
             titleInput.value = "";
             contentInput.value = "";
-            imageInput.value = "";
-            document.querySelector(".upload-text").textContent = "Upload Image (optional)";
+            ResetPostImages();
+            ResetPollForm();
             clearTags;
 
             if (window.location.pathname === "/" && currentActivityTab == "posts") {
@@ -86,7 +84,7 @@ async function HandleNewPost(e, categories, clearTags) {
 }
 
 // Implement front-end limits on title/content size.
-function CheckPostSize(contentLength, titleLength, imageSize, elem) {
+function CheckPostSize(contentLength, titleLength, images, elem) {
     if (contentLength == 0 || titleLength == 0) {
         DisplayError("postErrorMsg", elem, "Title and content are required");
         return true
@@ -108,12 +106,97 @@ function CheckPostSize(contentLength, titleLength, imageSize, elem) {
         return true;
     }
 
-    // Image size check (not above 20 MB)
+    // Image size checks (not above 20 MB each, 50 MB in all)
     const maxImageSize = 20 * 1024 * 1024;
-    if (imageSize && imageSize > maxImageSize) {
+    const maxImagesSize = 50 * 1024 * 1024;
+    if (images.some((img) => img.file.size > maxImageSize)) {
         DisplayError("postErrorMsg", elem, "Image is too large (max 20MB)");
         return true;
     }
+    if (images.reduce((sum, img) => sum + img.file.size, 0) > maxImagesSize) {
+        DisplayError("postErrorMsg", elem, "Images are too large (max 50MB in all)");
+        return true;
+    }
+    if (images.some((img) => img.alt.trim() === "")) {
+        DisplayError("postErrorMsg", elem, "Describe each image");
+        return true;
+    }
 
     return false;
 }
+
+/**********************************
+*     Images of the new post form *
+***********************************/
+const maxPostImages = 10;
+let postImages = []; // {file, alt} in gallery order
+
+// Add the picked images to the list, each with its own description.
+function PostImagesListener() {
+    const fileInput = document.getElementById("formPostImage");
+    const list = document.getElementById("formPostImages");
+    if (!fileInput || !list) return;
+
+    fileInput.addEventListener("change", () => {
+        for (const file of fileInput.files) {
+            if (postImages.length >= maxPostImages) {
+                PopError(`You can add up to ${maxPostImages} images`);
+                break;
+            }
+            postImages.push({ file, alt: "" });
+        }
+        // Allow picking the same file again
+        fileInput.value = "";
+        renderPostImageInputs();
+    });
+
+    list.addEventListener("input", (e) => {
+        const row = e.target.closest(".post-image-input");
+        if (row) postImages[row.dataset.index].alt = e.target.value;
+    });
+
+    list.addEventListener("click", (e) => {
+        const btn = e.target.closest(".post-image-remove");
+        if (!btn) return;
+        postImages.splice(btn.closest(".post-image-input").dataset.index, 1);
+        renderPostImageInputs();
+    });
+}
+
+function renderPostImageInputs() {
+    const list = document.getElementById("formPostImages");
+    list.innerHTML = "";
+    postImages.forEach((img, i) => {
+        // Long file names are truncated
+        let fileName = img.file.name;
+        if (fileName.length > 40) {
+            fileName = fileName.slice(0, 40) + "...";
+        }
+
+        const row = document.createElement("div");
+        row.className = "post-image-input";
+        row.dataset.index = i;
+        row.innerHTML = `
+            <span class="post-image-name"></span>
+            <input
+                type="text"
+                class="post-input"
+                placeholder="Image description (required)"
+                maxlength="300" />
+            <button type="button" class="post-image-remove" aria-label="Remove image">&times;</button>
+        `;
+        row.querySelector(".post-image-name").textContent = fileName;
+        row.querySelector("input").value = img.alt;
+        list.appendChild(row);
+    });
+
+    const uploadText = document.querySelector(".upload-text-post");
+    uploadText.textContent = postImages.length
+        ? `${postImages.length} of ${maxPostImages} images`
+        : "Add Images (optional)";
+}
+
+function ResetPostImages() {
+    postImages = [];
+    renderPostImageInputs();
+}
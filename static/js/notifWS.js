@@ -8,8 +8,11 @@ function connectNotificationsWS() {
 
     ws.onmessage = (event) => {
         const notif = JSON.parse(event.data);
+        // Live unread counter
+        if (notif.action === "unread_count") {
+            notif.count > 0 ? addNotificationBadge() : removeNotificationBadge();
         // Delete contradictory reaction
-        if (notif.action === "delete") {
+        } else if (notif.action === "delete") {
             handleDeletionNotification(notif);
         } else {
             // Add regular notification
@@ -38,7 +41,7 @@ function insertWSNotification(notif) {
 
     notifElement.innerHTML = `
         <div class="notif-avatar">
-            <img src="../uploads/${notif.actor_profile_pic || 'avatar.webp'}" alt="User Avatar">
+            <img src="../uploads/${imageVariant(notif.actor_profile_pic || 'avatar.webp', 'thumb')}" alt="User Avatar">
         </div>
         <div class="notif-content">
             <p class="notif-message"><strong>${notif.actor_username}</strong> ${notif.message}</p>
@@ -55,7 +58,7 @@ function insertWSNotification(notif) {
             markNotificationAsRead(notifId);
             this.classList.add('read');
 
-            history.pushState(null, "", `/post?post_id=${encodeURIComponent(notif.post_id)}`);
+            history.pushState(null, "", `/post/${encodeURIComponent(notif.post_id)}`);
             Routing();
         }
     });
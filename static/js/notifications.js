@@ -1,4 +1,5 @@
-let notifOffset = 0; // Offset for pagination
+let notifCursor = ""; // Cursor for pagination
+let notifEnd = false; // No more pages to fetch
 let notifLoading = false; // Prevents multiple fetches
 const dynamicContent = document.getElementById("content");
 // A global variable for all-notifications may lead to issues
@@ -7,19 +8,22 @@ const dynamicContent = document.getElementById("content");
 *   Update UI on tab content' load   *
 **************************************/
 async function notifsRenderer() {
-    if (notifLoading) return;
+    if (notifLoading || notifEnd) return;
     createNotifContainer();
     notifLoading = true;
+    const firstPage = notifCursor === "";
 
     try {
-        const res = await fetch(`/api/get-notifications?offset=${notifOffset}`);
+        const res = await fetch(`/api/get-notifications?cursor=${encodeURIComponent(notifCursor)}`);
         if (!res.ok) throw new Error("Failed to load notifications");
 
         const data = await res.json();
-        notifications = data;
+        notifications = data.items;
+        notifCursor = data.next_cursor || "";
+        if (!data.next_cursor) notifEnd = true;
 
         if (notifications.length !== 0) addClearAllButton();
-        if (notifications.length === 0 && notifOffset === 0) {
+        if (notifications.length === 0 && firstPage) {
             noNotification();
             return;
         }
@@ -40,7 +44,7 @@ async function notifsRenderer() {
 
             notifElement.innerHTML = `
                 <div class="notif-avatar">
-                    <img src="../uploads/${notif.actor_profile_pic || 'avatar.webp'}" alt="User Avatar">
+                    <img src="../uploads/${imageVariant(notif.actor_profile_pic || 'avatar.webp', 'thumb')}" alt="User Avatar">
                 </div>
                 <div class="notif-content">
                     <p class="notif-message"><strong>${notif.actor_username}</strong> ${notif.message}</p>
@@ -58,7 +62,7 @@ async function notifsRenderer() {
                     this.classList.add('read');
 
                     document.querySelector("#tagFilterSection").style.display = "none";
-                    history.pushState(null, "", `/post?post_id=${notif.post_id}`);
+                    history.pushState(null, "", `/post/${notif.post_id}`);
                     Routing();
                 }
             });
@@ -91,6 +95,7 @@ async function notifsRenderer() {
 *       Clear All Button Logic       *
 **************************************/
 function addClearAllButton() {
+    addMarkAllReadButton();
     let clearAllBtn = document.getElementById("clearAllNotifications");
 
     if (!clearAllBtn) {
@@ -107,6 +112,36 @@ function addClearAllButton() {
 function removeClearAllButton() {
     const clearBtn = document.getElementById("clearAllNotifications");
     if (clearBtn) { clearBtn.remove(); }
+    const readBtn = document.getElementById("markAllNotificationsRead");
+    if (readBtn) { readBtn.remove(); }
+}
+
+function addMarkAllReadButton() {
+    if (document.getElementById("markAllNotificationsRead")) return;
+    const readBtn = document.createElement("button");
+    readBtn.id = "markAllNotificationsRead";
+    readBtn.textContent = "Mark All Read";
+    readBtn.classList.add("clear-all-btn");
+    readBtn.addEventListener("click", markAllNotificationsRead);
+    dynamicContent.appendChild(readBtn);
+}
+
+async function markAllNotificationsRead() {
+    try {
+        const res = await fetch(`/api/mark-notifications-read`, {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ all: true })
+        });
+        if (!res.ok) throw new Error("Failed to mark notifications as read");
+
+        document.querySelectorAll(".notification-item").forEach(notif => {
+            notif.classList.add("read");
+        });
+        removeNotificationBadge();
+    } catch (err) {
+        console.error("Failed to mark all notifications as read:", err);
+    }
 }
 
 async function clearAllNotifications() {
@@ -170,10 +205,7 @@ function handleNotifScroll() {
 
     // Calculate when to load more notifications.
     if (notifContainer.scrollTop + notifContainer.clientHeight >= notifContainer.scrollHeight - 400) {
-        notifsRenderer()
-            .then(() => {
-                notifOffset += NotifLimit; // Ensure NotifLimit is defined
-            });
+        notifsRenderer();
     }
 }
 
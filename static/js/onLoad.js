@@ -19,7 +19,8 @@ document.addEventListener("DOMContentLoaded", async () => {
         LoginFormListener();
         SignUpFormListener();
         NewPostListener();
-        imageUploaded("formPostImage");
+        PollFormListener();
+        PostImagesListener();
         CheckOAuth();
     } catch (err) {
         console.log(err);
@@ -55,7 +56,10 @@ async function CheckSession() {
 
 async function Routing() {
     checkNotificationCount();
-    const path = window.location.pathname;
+    closePostWS();
+    // Post pages are served as /post/{id}
+    const postMatch = window.location.pathname.match(/^\/post\/(\d+)$/);
+    const path = postMatch ? "/post" : window.location.pathname;
     if (path !== "/") {
         const tagFilterSection = document.getElementById("tagFilterSection");
         if (tagFilterSection) tagFilterSection.style.display = "none";
@@ -76,8 +80,7 @@ async function Routing() {
         SetupTabListeners();
     } else if (path === "/post") {
         currentActivityTab = "";
-        const urlParams = new URLSearchParams(window.location.search);
-        const postId = urlParams.get("post_id");
+        const postId = postMatch[1];
         if (postId) {
             const exists = await checkPost(postId);
             if (exists) {
@@ -184,7 +187,8 @@ function LoadTabContent(tab) {
         } else if (tab === "notifs") {
             window.removeEventListener('scroll', handleScroll);
             window.removeEventListener('scroll', handleActivityScroll);
-            notifOffset = 0;
+            notifCursor = "";
+            notifEnd = false;
             notifsRenderer();
         } else if (tab === "messages") {
             window.removeEventListener('scroll', handleScroll);
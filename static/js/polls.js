@@ -0,0 +1,196 @@
+const maxPollOptions = 10;
+let postWS; // Live updates of the open post page
+
+/**********************************
+*      Poll of the new post form  *
+***********************************/
+function PollFormListener() {
+    const toggle = document.getElementById("togglePoll");
+    const builder = document.getElementById("pollBuilder");
+    const addBtn = document.getElementById("addPollOption");
+
+    toggle?.addEventListener("click", () => {
+        const open = builder.classList.toggle("open");
+        toggle.textContent = open ? "Remove poll" : "Add a poll";
+    });
+
+    addBtn?.addEventListener("click", () => {
+        const options = document.getElementById("pollOptions");
+        const count = options.querySelectorAll(".poll-option-input").length;
+        if (count >= maxPollOptions) return;
+        options.insertAdjacentHTML("beforeend", pollOptionInput(count + 1));
+        addBtn.style.display = count + 1 >= maxPollOptions ? "none" : "";
+    });
+}
+
+function pollOptionInput(n) {
+    return `<input type="text" class="post-input poll-option-input" placeholder="Option ${n}" maxlength="100" />`;
+}
+
+// Poll of the new post form, null when none was added.
+function ReadPollForm() {
+    const builder = document.getElementById("pollBuilder");
+    if (!builder || !builder.classList.contains("open")) return null;
+
+    const closesAt = document.getElementById("pollClosesAt").value;
+    return {
+        question: document.getElementById("pollQuestion").value.trim(),
+        options: [...builder.querySelectorAll(".poll-option-input")]
+            .map((input) => input.value.trim())
+            .filter((label) => label !== ""),
+        multiple: document.getElementById("pollMultiple").checked,
+        anonymous: document.getElementById("pollAnonymous").checked,
+        closes_at: closesAt ? new Date(closesAt).toISOString() : null,
+    };
+}
+
+function ResetPollForm() {
+    const builder = document.getElementById("pollBuilder");
+    if (!builder) return;
+    builder.classList.remove("open");
+    document.getElementById("togglePoll").textContent = "Add a poll";
+    document.getElementById("pollQuestion").value = "";
+    document.getElementById("pollOptions").innerHTML = pollOptionInput(1) + pollOptionInput(2);
+    document.getElementById("addPollOption").style.display = "";
+    document.getElementById("pollMultiple").checked = false;
+    document.getElementById("pollAnonymous").checked = false;
+    document.getElementById("pollClosesAt").value = "";
+}
+
+/**********************************
+*       Poll of a single post     *
+***********************************/
+// Render a poll with its results, or its choices until the user voted.
+function RenderPoll(poll, container) {
+    container.dataset.pollId = poll.id;
+    container.poll = poll;
+
+    const voted = poll.user_votes && poll.user_votes.length > 0;
+    const showResults = voted || poll.closed;
+    const total = poll.options.reduce((sum, o) => sum + o.votes, 0);
+    const inputType = poll.multiple ? "checkbox" : "radio";
+
+    const options = poll.options.map((o) => {
+        if (!showResults) {
+            return `
+                <label class="poll-choice">
+                    <input type="${inputType}" name="poll-${poll.id}" value="${o.id}">
+                    <span>${o.label}</span>
+                </label>`;
+        }
+        const percent = total ? Math.round((o.votes * 100) / total) : 0;
+        const mine = voted && poll.user_votes.includes(o.id);
+        const voters = o.voters && o.voters.length
+            ? `<span class="poll-voters">${o.voters.join(", ")}</span>`
+            : "";
+        return `
+            <div class="poll-result ${mine ? "mine" : ""}">
+                <div class="poll-bar" style="width: ${percent}%"></div>
+                <span class="poll-label">${o.label}</span>
+                <span class="poll-count">${o.votes} (${percent}%)</span>
+            </div>
+            ${voters}`;
+    }).join("");
+
+    let status = `${poll.voters} ${poll.voters === 1 ? "voter" : "voters"}`;
+    if (poll.closed) {
+        status += " • Closed";
+    } else if (poll.closes_at) {
+        status += ` • Closes ${new Date(poll.closes_at).toLocaleString()}`;
+    }
+    if (poll.anonymous) status += " • Anonymous";
+
+    container.innerHTML = `
+        <p class="poll-question">${poll.question}</p>
+        <div class="poll-options">${options}</div>
+        <div class="poll-footer">
+            <span class="poll-status">${status}</span>
+            ${showResults ? "" : `<button type="button" class="poll-vote">Vote</button>`}
+        </div>
+    `;
+
+    container.querySelector(".poll-vote")?.addEventListener("click", (e) => {
+        e.stopPropagation();
+        VotePoll(poll, container);
+    });
+}
+
+// Send the picked options, then show the results.
+async function VotePoll(poll, container) {
+    const picked = [...container.querySelectorAll(".poll-choice input:checked")]
+        .map((input) => Number(input.value));
+    if (picked.length === 0) {
+        PopError("Pick an option first");
+        return;
+    }
+
+    try {
+        const res = await fetch("/api/vote-poll", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ poll_id: poll.id, option_ids: picked }),
+        });
+
+        if (res.status == 401) {
+            // Not logged in => show auth modal
+            document.getElementById("authModal")?.classList.remove("hidden");
+            return;
+        }
+        if (!res.ok) {
+            const errData = await res.json();
+            PopError(errData.msg);
+            return;
+        }
+        RenderPoll(await res.json(), container);
+    } catch (err) {
+        console.log(err);
+        PopError("Something went wrong");
+    }
+}
+
+// Apply results pushed to the post viewers. They don't carry the viewer's
+// votes nor the voters, these are kept from the rendered poll.
+function UpdatePollResults(poll) {
+    const container = document.querySelector(`.post-poll[data-poll-id="${poll.id}"]`);
+    if (!container || !container.poll) return;
+
+    const current = container.poll;
+    const voters = new Map(current.options.map((o) => [o.id, o.voters]));
+    poll.user_votes = current.user_votes;
+    poll.options.forEach((o) => { o.voters = voters.get(o.id); });
+
+    // Keep the choices being picked
+    const checked = [...container.querySelectorAll(".poll-choice input:checked")].map((input) => input.value);
+    RenderPoll(poll, container);
+    checked.forEach((value) => {
+        const input = container.querySelector(`.poll-choice input[value="${value}"]`);
+        if (input) input.checked = true;
+    });
+}
+
+/**********************************
+*      Post page WebSocket        *
+***********************************/
+function connectPostWS(postID) {
+    closePostWS();
+    const protocol = (window.location.protocol === "https:") ? "wss" : "ws";
+    postWS = new WebSocket(`${protocol}://${window.location.host}/ws/post?post_id=${encodeURIComponent(postID)}`);
+
+    postWS.onmessage = (event) => {
+        const data = JSON.parse(event.data);
+        if (data.action === "poll_results") {
+            UpdatePollResults(data.poll);
+        }
+    };
+
+    postWS.onerror = (err) => {
+        console.error("WebSocket error:", err);
+    };
+}
+
+function closePostWS() {
+    if (postWS) {
+        postWS.close();
+        postWS = null;
+    }
+}
@@ -50,8 +50,8 @@ async function LoadPostPage(postID) {
     // Fetchers
     if (postID) {
         await FetchFullPost(postID);
-            FetchReactions(postID, postDiv, "post");
-            FetchComments(postID);
+        FetchComments(postID);
+        connectPostWS(postID);
     } else {
         console.log("No post found");
         PopError("Something went wrong");
@@ -81,9 +81,29 @@ async function LoadPostPage(postID) {
     });
 }
 
+// Post rendered by the server with the page, used once.
+function takeInitialPost(id) {
+    const script = document.getElementById("initialPost");
+    if (!script) return null;
+    script.remove();
+    try {
+        const post = JSON.parse(script.textContent);
+        return String(post.id) === String(id) ? post : null;
+    } catch {
+        return null;
+    }
+}
+
 // Send fetch to backEnd with post ID
 async function FetchFullPost(id) {
     try {
+        const initial = takeInitialPost(id);
+        if (initial) {
+            RenderPost(initial, postDiv, "single");
+            RedirectToProfile()
+            longTagNames();
+            return;
+        }
         const res = await fetch(`/api/get-singlePost?post_id=${encodeURIComponent(id)}`);
         if (!res.ok) {
             const errData = await res.json();
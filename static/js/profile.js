@@ -133,9 +133,9 @@ function RenderProfile(profile) {
         <div class="content-section">
             <div class="profile-card">
                 <div class="profile-image">
-                    <img src="../uploads/${profile.profile_pic || "avatar.webp"}" alt="Profile Picture" />
+                    <img src="../uploads/${profile.profile_pic_medium || "avatar.webp"}" alt="Profile Picture" />
                 </div>
-                <div class="profileUsername username">${profile.username}</div>
+                <div class="profileUsername username">${profile.username}${botBadge(profile.is_bot)}</div>
                 <nav class="profile-tab-bar">
                     <button class="profile-tab-btn active" data-tab="about">
                         <img src="../img/about.svg" alt="about">
@@ -224,7 +224,7 @@ async function fetchUserPosts(offset, content) {
         // If offset=0, replace the entire dynamicContent first
         if (offset === 0) dynamicContent.innerHTML = "";
 
-        RenderPosts(posts, offset, 100);
+        RenderPosts(posts, offset);
     } catch (err) {
         console.error("Error loading user posts:", err);
         DisplayError("errMsg", dynamicContent, "Error loading user posts.");
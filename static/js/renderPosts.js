@@ -1,6 +1,6 @@
 // Renders a list of post objects into #postsContainer.
 // if clearFirst we first remove content inside it.
-function RenderPosts(posts, offset, truncate = 300) {
+function RenderPosts(posts, offset) {
     let postsContainer = ""
     if (window.location.pathname === "/profile") {
         postsContainer = document.getElementById("profileDynamicContent");
@@ -29,7 +29,6 @@ function RenderPosts(posts, offset, truncate = 300) {
         postDiv.classList.add("post-card");
         postDiv.setAttribute("data-id", post.id);
 
-        post.content = truncateContent(post.content, truncate);
         RenderPost(post, postDiv);
         postsContainer.appendChild(postDiv);
 
@@ -37,17 +36,16 @@ function RenderPosts(posts, offset, truncate = 300) {
         postDiv.addEventListener("click", (e) => {
             if (e.target.closest(".reaction-buttons") ||
                 e.target.closest(".post-title") ||
+                e.target.closest(".link-preview") ||
                 e.target.closest(".user-avatar") ||
                 e.target.closest(".username-select")) return;
             // Then go to single post
             document.querySelector("#tagFilterSection").style.display = "none";
-            history.pushState(null, "", `/post?post_id=${post.id}`);
+            history.pushState(null, "", `/post/${post.id}`);
             const tabBar = document.querySelector(".tab-bar");
             if (tabBar) { tabBar.style.display = "none"; }
             Routing();
         });
-
-        FetchReactions(post.id, postDiv, "post")
     });
     RedirectToProfile()
     longTagNames()
@@ -55,7 +53,7 @@ function RenderPosts(posts, offset, truncate = 300) {
 
 // Build post html (user in getPosts and singlePost)
 function RenderPost(post, postDiv, single = "") {
-    const profilePic = post.profile_pic || "../img/avatar.webp";
+    const profilePic = post.profile_pic_thumb || "avatar.webp";
 
     // Build categories HTML
     const categoriesHTML = (post.categories || [])
@@ -68,16 +66,23 @@ function RenderPost(post, postDiv, single = "") {
         )
         .join("");
 
-    // Prepare image HTML
+    // Prepare images HTML, in gallery order with their own descriptions
+    const media = (post.media || []).slice().sort((a, b) => a.position - b.position);
     let imageSection = "";
-    if (post.image && post.image !== "") {
+    if (media.length > 0) {
+        const images = media
+            .map((m) => `<img class="post-image-centered" src="../uploads/${m.medium || m.name}" alt="${m.alt}" loading="lazy">`)
+            .join("");
         imageSection = `
-        <div id="${single}PostImage" class="post-image-wrapper">
-            <img class="post-image-centered" src="../uploads/${post.image}" alt="Post Image">
+        <div id="${single}PostImage" class="post-image-wrapper ${media.length > 1 ? "post-gallery" : ""}">
+            ${images}
         </div>
     `;
     }
 
+    // Poll, sent with single posts only
+    const pollSection = post.poll ? `<div class="post-poll"></div>` : "";
+
     // Start building HTML  
     postDiv.innerHTML = `
         <!-- Post Header -->
@@ -85,7 +90,7 @@ function RenderPost(post, postDiv, single = "") {
             <img src="../uploads/${profilePic}" alt="User Avatar" class="user-avatar">
             <div class="user-info">
                 <div class="username">
-                    <span class="username-select">${post.username}</span>
+                    <span class="username-select">${post.username}</span>${botBadge(post.is_bot)}
                     <span class="time-ago" data-timestamp="${post.created_at}">&nbsp• ${timeAgo(post.created_at)}</span>
                 </div>
             </div>
@@ -95,10 +100,12 @@ function RenderPost(post, postDiv, single = "") {
         <!-- Post Content + Image -->
         <div class="post-content" id="${single}postContent">
             <h1 id="${single}postTitle" class="post-title">
-                <a href="/post?post_id=${post.id}" class="post-header-link">${post.title}</a>
+                <a href="/post/${post.id}" class="post-header-link">${post.title}</a>
             </h1>
-            <p style="white-space: pre-wrap">${post.content}</p>
+            <div class="markdown ${single ? "" : "clamped"}">${post.content_html}</div>
             ${imageSection}
+            ${renderPreviews(post.previews)}
+            ${pollSection}
         </div>
 
         <!-- Reaction Buttons -->
@@ -134,8 +141,12 @@ function RenderPost(post, postDiv, single = "") {
         titleLink.style.pointerEvents = "none";
     }
 
+    if (post.poll) {
+        RenderPoll(post.poll, postDiv.querySelector(".post-poll"));
+    }
+
     AttachReactionListeners(post.id, postDiv, "post")
-    FetchCommentsCount(post.id, postDiv)
+    RenderPostCounters(post, postDiv)
     updateTagIcons()
     if (currentActivityTab == "comments" && window.location.pathname === "/") {
         // Create a container for *this user's* comments
@@ -188,7 +199,7 @@ function RenderUserComments(comments, container) {
 
     // Loop through each comment, build markup, append
     comments.forEach((comment) => {
-        const profilePic = comment.profile_pic || "../img/avatar.webp";
+        const profilePic = comment.profile_pic_thumb || "avatar.webp";
         const commentEl = document.createElement("div");
         commentEl.classList.add("comment-item");
 
@@ -196,13 +207,13 @@ function RenderUserComments(comments, container) {
             <p class="comment-meta">
                 <div class="username">
                     <img src="../uploads/${profilePic}" alt="User Avatar" class="comment-user-avatar">
-                    ${comment.username}
+                    ${comment.username}${botBadge(comment.is_bot)}
                     <span class="time-ago" data-timestamp="${comment.created_at}">
                         &nbsp• ${timeAgo(comment.created_at)}
                     </span>
                 </div>
             </p>
-            <p class="comment-content">${comment.content}</p>
+            <div class="comment-content markdown">${comment.content_html}</div>
 
             <!-- Reaction Section for each comment -->
             <div class="comment-reaction-buttons">
@@ -226,6 +237,14 @@ function RenderUserComments(comments, container) {
     });
 }
 
+// Fill counters sent along with the post (no extra requests)
+function RenderPostCounters(post, postDiv) {
+    postDiv.querySelector(".post-like-count").textContent = post.likes || 0;
+    postDiv.querySelector(".post-dislike-count").textContent = post.dislikes || 0;
+    postDiv.querySelector(".comments-count").textContent = post.comments_count || 0;
+    UpdateReactionIcons(postDiv, post.user_reaction, "post");
+}
+
 // Fetch comments count
 async function FetchCommentsCount(postId, postDiv) {
     try {
@@ -47,7 +47,7 @@ function RenderOnlineUsers(users) {
 
         userElement.innerHTML = `
             <div class="online-user-link">
-                <img src="../uploads/${user.profile_pic || 'avatar.webp'}" alt="${user.username}" class="online-user-avatar">
+                <img src="../uploads/${imageVariant(user.profile_pic || 'avatar.webp', 'thumb')}" alt="${user.username}" class="online-user-avatar">
                 <span class="online-user-name">${user.username}</span>
             </div>
         `;